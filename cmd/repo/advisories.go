@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zachomedia/composerrepo/pkg/composer"
+	"github.com/zachomedia/composerrepo/pkg/logging"
+)
+
+// knownPackages caches the names of the packages in the generated repository.
+type knownPackages struct {
+	conf *composer.Config
+
+	mux   sync.Mutex
+	names map[string]bool
+}
+
+// Has reports whether the repository contains the named package.
+func (known *knownPackages) Has(name string) (bool, error) {
+	known.mux.Lock()
+	defer known.mux.Unlock()
+
+	if known.names == nil {
+		pkgs, err := composer.ReadPackages(known.conf.Context(), known.conf.Output)
+		if err != nil {
+			return false, err
+		}
+
+		known.names = make(map[string]bool, len(pkgs))
+		for name := range pkgs {
+			known.names[strings.ToLower(name)] = true
+		}
+	}
+
+	return known.names[strings.ToLower(name)], nil
+}
+
+// Reset forgets the packages, so they are read again after the repository changes.
+func (known *knownPackages) Reset() {
+	known.mux.Lock()
+	defer known.mux.Unlock()
+
+	known.names = nil
+}
+
+// advisoriesPath returns the path the security advisories API is served
+// on, from its URL in packages.json.
+func advisoriesPath(apiURL string) (string, error) {
+	u, err := url.Parse(apiURL)
+	if err != nil {
+		return "", err
+	}
+
+	if u.Path == "" {
+		return "/", nil
+	}
+
+	return u.Path, nil
+}
+
+// advisoriesHandler serves the security advisories API used by `composer
+// audit`. Packages are listed in packages[] and only advisories of packages
+// in the repository are returned. updatedSince, a Unix timestamp, limits the
+// advisories to those reported since.
+func advisoriesHandler(db *composer.AdvisoryDatabase, known *knownPackages) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			w.WriteHeader(400)
+			fmt.Fprint(w, err.Error())
+			return
+		}
+
+		var updatedSince time.Time
+		if since := r.Form.Get("updatedSince"); since != "" {
+			timestamp, err := strconv.ParseInt(since, 10, 64)
+			if err != nil {
+				w.WriteHeader(400)
+				fmt.Fprintf(w, "Invalid updatedSince %q", since)
+				return
+			}
+			updatedSince = time.Unix(timestamp, 0)
+		}
+
+		names := make([]string, 0)
+		for _, name := range r.Form["packages[]"] {
+			ok, err := known.Has(name)
+			if err != nil {
+				logging.FromContext(r.Context()).Error("Unable to read the repository", "error", err)
+
+				w.WriteHeader(500)
+				fmt.Fprint(w, "Unable to read the repository")
+				return
+			}
+
+			if ok {
+				names = append(names, name)
+			}
+		}
+
+		advisories, err := db.Get(names, updatedSince)
+		if err != nil {
+			logging.FromContext(r.Context()).Error("Unable to read the security advisories", "error", err)
+
+			w.WriteHeader(500)
+			fmt.Fprint(w, "Unable to read the security advisories")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"advisories": advisories,
+		})
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/zachomedia/composerrepo/pkg/auth"
+	"github.com/zachomedia/composerrepo/pkg/logging"
+)
+
+// maxRequestSize is the largest body read to check the signature of a request.
+const maxRequestSize = 1 << 20
+
+// statusWriter remembers the status of the response, and its body if it is
+// an error, for the audit log.
+type statusWriter struct {
+	http.ResponseWriter
+	status  int
+	message strings.Builder
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.status >= 400 && w.message.Len() < 512 {
+		w.message.Write(b)
+	}
+
+	return w.ResponseWriter.Write(b)
+}
+
+// audited records each request to handler in the audit log. The handler
+// fills in the client and the package it acts on.
+func audited(a *auth.Auth, action string, handler func(http.ResponseWriter, *http.Request, *auth.AuditEntry)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		sw := &statusWriter{ResponseWriter: w, status: 200}
+		entry := &auth.AuditEntry{
+			Request:    w.Header().Get(requestIDHeader),
+			RemoteAddr: r.RemoteAddr,
+			Endpoint:   r.URL.Path,
+			Action:     action,
+		}
+
+		handler(sw, r, entry)
+
+		entry.Status = sw.status
+		entry.Error = sw.message.String()
+		a.Audit.Record(entry)
+	}
+}
+
+// remoteHost returns the address of the client without the port.
+func remoteHost(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
+
+// authorize authenticates the request, unless anonymous updates are allowed,
+// and takes it from the rate limit of the client. Requests without valid
+// credentials are limited by address. If the request is refused, the
+// response is written and nil is returned.
+func authorize(a *auth.Auth, w http.ResponseWriter, r *http.Request, entry *auth.AuditEntry) *auth.Client {
+	client := &auth.Client{Name: remoteHost(r)}
+
+	if a.Enabled() || !a.AllowAnonymous {
+		body, err := ioutil.ReadAll(io.LimitReader(r.Body, maxRequestSize))
+		if err == nil {
+			client, err = a.Authenticate(r, body)
+		}
+
+		if err != nil {
+			entry.Client = remoteHost(r)
+			if limit(a, w, r, remoteHost(r)) {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				w.WriteHeader(401)
+				fmt.Fprint(w, auth.ErrUnauthorized.Error())
+			}
+			return nil
+		}
+	}
+
+	entry.Client = client.Name
+	if !limit(a, w, r, client.Name) {
+		return nil
+	}
+
+	return client
+}
+
+// limit takes a request from the rate limit of key. If the limit is
+// reached, a 429 response is written and false is returned.
+func limit(a *auth.Auth, w http.ResponseWriter, r *http.Request, key string) bool {
+	if a.Limiter == nil {
+		return true
+	}
+
+	ok, retryAfter := a.Limiter.Allow(key)
+	if ok {
+		return true
+	}
+
+	logging.FromContext(r.Context()).Warn("Rate limiting", "key", key)
+
+	w.Header().Set("Retry-After", fmt.Sprint(int(math.Ceil(retryAfter.Seconds()))))
+	w.WriteHeader(429)
+	fmt.Fprint(w, "Too many requests")
+	return false
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/zachomedia/composerrepo/pkg/logging"
+)
+
+// requestIDHeader carries the correlation ID of a request. An ID given by
+// the client, such as one set by a proxy, is kept.
+const requestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength limits the IDs taken from clients.
+const maxRequestIDLength = 128
+
+// logged gives each request a correlation ID, returned in the response, and
+// a logger carrying it in the request context.
+func logged(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if id == "" || len(id) > maxRequestIDLength {
+			id = logging.NewID()
+		}
+		w.Header().Set(requestIDHeader, id)
+
+		log := logging.Default().With("request", id)
+		r = r.WithContext(logging.NewContext(r.Context(), log))
+
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: w, status: 200}
+		handler.ServeHTTP(sw, r)
+
+		log.Debug("Handled request", "method", r.Method, "path", r.URL.Path, "remoteAddr", r.RemoteAddr,
+			"status", sw.status, "duration", time.Since(start))
+	})
+}
@@ -1,19 +1,27 @@
 package main
 
 import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"strings"
-	"sync"
+	"time"
 
+	"github.com/zachomedia/composerrepo/pkg/auth"
 	"github.com/zachomedia/composerrepo/pkg/composer"
 	"github.com/zachomedia/composerrepo/pkg/config"
+	"github.com/zachomedia/composerrepo/pkg/logging"
+	"github.com/zachomedia/composerrepo/pkg/redact"
 
 	"github.com/urfave/cli"
+	yaml "gopkg.in/yaml.v2"
 )
 
+// getConfig loads the config and opens its inputs and outputs for use.
 func getConfig(c *cli.Context) (*composer.Config, error) {
 	f, err := os.Open(c.GlobalString("config"))
 	if err != nil {
@@ -21,12 +29,63 @@ func getConfig(c *cli.Context) (*composer.Config, error) {
 	}
 	defer f.Close()
 
-	return config.ConfigFromYAML(f)
+	conf, err := config.ConfigFromYAML(f)
+	if err != nil {
+		return nil, err
+	}
+
+	return conf, config.Open(conf)
 }
 
-func generate(c *cli.Context) error {
+// getJobConfig returns the config of a command which runs a single job, so
+// everything it logs, including its inputs and outputs, carries the
+// correlation ID of the job.
+func getJobConfig(c *cli.Context) (*composer.Config, error) {
 	conf, err := getConfig(c)
 	if err != nil {
+		return nil, err
+	}
+
+	conf.Log = conf.Log.With("job", logging.NewID())
+	return conf, nil
+}
+
+// requireSigningKeys fails commands which write the repository when signing
+// is configured with only trustedKeys, which can verify but not sign.
+func requireSigningKeys(conf *composer.Config) error {
+	if conf.Signing != nil && len(conf.Signing.Keys) == 0 {
+		return cli.NewExitError("Signing keys are not configured. Set signing.keys to sign the repository", 1)
+	}
+
+	return nil
+}
+
+// setupLogging configures the logger from the --log-level and --log-format
+// flags, and sends the standard log of libraries to it.
+func setupLogging(c *cli.Context) error {
+	level, err := logging.ParseLevel(c.GlobalString("log-level"))
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+
+	logger, err := logging.New(os.Stderr, level, c.GlobalString("log-format"))
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+
+	logging.SetDefault(logger)
+	log.SetFlags(0)
+	log.SetOutput(logger.Writer(logging.LevelInfo))
+	return nil
+}
+
+func generate(c *cli.Context) error {
+	conf, err := getJobConfig(c)
+	if err != nil {
+		return err
+	}
+
+	if err := requireSigningKeys(conf); err != nil {
 		return err
 	}
 
@@ -34,11 +93,15 @@ func generate(c *cli.Context) error {
 }
 
 func update(c *cli.Context) error {
-	conf, err := getConfig(c)
+	conf, err := getJobConfig(c)
 	if err != nil {
 		return err
 	}
 
+	if err := requireSigningKeys(conf); err != nil {
+		return err
+	}
+
 	packages := make([]*composer.PackageInfo, 0)
 
 	for _, arg := range c.Args() {
@@ -53,27 +116,159 @@ func update(c *cli.Context) error {
 	return composer.Update(conf, packages)
 }
 
+func validate(c *cli.Context) error {
+	f, err := os.Open(c.GlobalString("config"))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	rawConfig, err := config.ReadYAML(f)
+	if err == nil {
+		var conf *composer.Config
+		conf, err = config.ConfigFromRaw(rawConfig)
+
+		if err == nil && c.Bool("check") {
+			conf.Log.Info("Checking connectivity of inputs and outputs")
+			err = config.Check(conf)
+		}
+	}
+
+	if err != nil {
+		fmt.Println(redact.String(err.Error()))
+		return cli.NewExitError(fmt.Sprintf("Configuration %q is invalid", c.GlobalString("config")), 1)
+	}
+
+	if c.Bool("dump") {
+		out, err := yaml.Marshal(rawConfig)
+		if err != nil {
+			return err
+		}
+
+		fmt.Print(redact.String(string(out)))
+		return nil
+	}
+
+	fmt.Printf("Configuration %q is valid\n", c.GlobalString("config"))
+	return nil
+}
+
+func checkDeps(c *cli.Context) error {
+	conf, err := getConfig(c)
+	if err != nil {
+		return err
+	}
+
+	check := &composer.DependencyCheck{}
+	if conf.CheckDependencies != nil {
+		*check = *conf.CheckDependencies
+	}
+	check.Allow = append(check.Allow, c.StringSlice("allow")...)
+	check.Dev = check.Dev || c.Bool("dev")
+
+	pkgs, err := composer.ReadPackages(conf.Context(), conf.Output)
+	if err != nil {
+		return err
+	}
+
+	unsatisfied := composer.CheckDependencies(pkgs, check)
+	for _, dep := range unsatisfied {
+		fmt.Println(dep)
+	}
+
+	if len(unsatisfied) > 0 {
+		return cli.NewExitError(fmt.Sprintf("%d requirements can't be satisfied", len(unsatisfied)), 1)
+	}
+
+	fmt.Printf("The requirements of %d packages are satisfied\n", len(pkgs))
+	return nil
+}
+
+func verify(c *cli.Context) error {
+	conf, err := getJobConfig(c)
+	if err != nil {
+		return err
+	}
+
+	if c.Bool("repair") {
+		if err := requireSigningKeys(conf); err != nil {
+			return err
+		}
+	}
+
+	if conf.Signing == nil {
+		conf.Log.Warn("Signing is not configured, only checking hashes")
+	}
+
+	problems, err := composer.Verify(conf)
+	if err != nil {
+		return err
+	}
+
+	for _, problem := range problems {
+		fmt.Println(problem)
+	}
+
+	if len(problems) > 0 && c.Bool("repair") {
+		conf.Log.Info("Repairing files", "files", len(problems))
+		if err := composer.Repair(conf, problems); err != nil {
+			return err
+		}
+
+		if problems, err = composer.Verify(conf); err != nil {
+			return err
+		}
+
+		for _, problem := range problems {
+			fmt.Println(problem)
+		}
+	}
+
+	if len(problems) > 0 {
+		return cli.NewExitError(fmt.Sprintf("%d files failed verification", len(problems)), 1)
+	}
+
+	fmt.Println("The repository is valid")
+	return nil
+}
+
+func keygen(c *cli.Context) error {
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("privateKey: %s\n", base64.StdEncoding.EncodeToString(privateKey.Seed()))
+	fmt.Printf("publicKey: %s\n", base64.StdEncoding.EncodeToString(publicKey))
+	return nil
+}
+
 func serve(c *cli.Context) error {
 	conf, err := getConfig(c)
 	if err != nil {
 		return err
 	}
 
-	generating := false
+	if err := requireSigningKeys(conf); err != nil {
+		return err
+	}
 
-	// Do an initial generation of the repository
-	if !c.Bool("no-generate") {
-		generating = true
-		log.Println("Generating initial repository")
+	if conf.Auth == nil {
+		return cli.NewExitError("Authentication is not configured. Set auth.clients, or auth.allowAnonymous to let anyone update packages", 1)
+	} else if !conf.Auth.Enabled() {
+		conf.Log.Warn("Anonymous updates are allowed, anyone can update packages")
+	}
+
+	known := &knownPackages{conf: conf}
+	index := &indexCache{conf: conf}
+	updates := &updater{conf: conf, known: known, index: index}
 
-		go (func() {
-			err := composer.Generate(conf)
-			if err != nil {
-				log.Panic(err)
-			}
+	watch(conf, updates, c.Duration("watch-interval"))
 
-			generating = false
-		})()
+	// Do an initial generation of the repository
+	if !c.Bool("no-generate") {
+		conf.Log.Info("Generating initial repository")
+		updates.Generate()
 	}
 
 	// Handle incoming requests and update packages as requested
@@ -81,25 +276,73 @@ func serve(c *cli.Context) error {
 		fmt.Fprintf(w, "OK")
 	})
 
-	mux := sync.Mutex{}
-	http.HandleFunc(c.String("listen-path"), func(w http.ResponseWriter, r *http.Request) {
+	if conf.SecurityAdvisories != nil {
+		conf.Log.Info("Loading security advisories")
+		if err := conf.SecurityAdvisories.Check(); err != nil {
+			return err
+		}
+
+		advisoriesPath, err := advisoriesPath(conf.SecurityAdvisories.APIURL)
+		if err != nil {
+			return err
+		}
+
+		http.HandleFunc(advisoriesPath, advisoriesHandler(conf.SecurityAdvisories, known))
+	}
+
+	if conf.Search != nil && conf.Search.URL != "" {
+		searchPath, listPath, err := searchPaths(conf.Search.URL)
+		if err != nil {
+			return err
+		}
+
+		http.HandleFunc(searchPath, searchHandler(index))
+		http.HandleFunc(listPath, listHandler(index))
+	}
+
+	if conf.Stats != nil {
+		notifyPath, statsPath, err := statsPaths(conf.Stats.URL)
+		if err != nil {
+			return err
+		}
+
+		http.HandleFunc(notifyPath, notifyBatchHandler(conf.Stats.Store, known))
+		http.HandleFunc(statsPath, statsHandler(conf.Stats.Store))
+		http.HandleFunc("/metrics", metricsHandler(conf.Stats.Store))
+
+		go flushStats(conf, updates)
+	}
+
+	for _, hook := range conf.Webhooks {
+		http.HandleFunc(hook.Path, audited(conf.Auth, "webhook", webhookHandler(hook, conf.Auth, updates)))
+	}
+
+	http.HandleFunc(c.String("listen-path"), audited(conf.Auth, "update", func(w http.ResponseWriter, r *http.Request, entry *auth.AuditEntry) {
+		client := authorize(conf.Auth, w, r, entry)
+		if client == nil {
+			return
+		}
+
+		log := logging.FromContext(r.Context()).With("client", client.Name)
+
+		entry.Input = r.URL.Query().Get("input")
+		entry.Package = r.URL.Query().Get("package")
+
 		if r.URL.Query().Get("input") == "" || r.URL.Query().Get("package") == "" {
-			log.Printf("Expected 'input' and 'package' params")
+			log.Warn("Expected 'input' and 'package' params")
 
 			w.WriteHeader(400)
 			fmt.Fprintf(w, "Expected 'input' and 'package' params")
 			return
 		}
 
-		if generating {
-			log.Printf("Refusing update due to generation in progress: %s:%s", r.URL.Query().Get("input"), r.URL.Query().Get("package"))
+		if updates.Generating() {
+			log.Warn("Refusing update due to generation in progress", "input", entry.Input, "package", entry.Package)
 			w.WriteHeader(502)
 			fmt.Fprintf(w, "Unable to update as initial repository generation is in progress")
 			return
 		}
 
-		log.Printf("Updating %s:%s", r.URL.Query().Get("input"), r.URL.Query().Get("package"))
-
 		pkgInfo := &composer.PackageInfo{
 			InputID:     r.URL.Query().Get("input"),
 			PackageName: r.URL.Query().Get("package"),
@@ -107,19 +350,24 @@ func serve(c *cli.Context) error {
 
 		// Check that the input exists
 		if _, ok := conf.Inputs[pkgInfo.InputID]; !ok {
-			log.Printf("Unknown input %q", pkgInfo.InputID)
+			log.Warn("Unknown input", "input", pkgInfo.InputID)
 
 			w.WriteHeader(404)
 			fmt.Fprintf(w, "Unknown input %q", pkgInfo.InputID)
 			return
 		}
 
-		mux.Lock()
-		defer mux.Unlock()
+		if !client.AllowsInput(pkgInfo.InputID) {
+			log.Warn("Client may not update input", "input", pkgInfo.InputID)
 
-		err := composer.Update(conf, []*composer.PackageInfo{pkgInfo})
+			w.WriteHeader(403)
+			fmt.Fprintf(w, "Not allowed to update input %q", pkgInfo.InputID)
+			return
+		}
+
+		err := updates.Update(log, []*composer.PackageInfo{pkgInfo})
 		if err != nil {
-			log.Print(err)
+			log.Error("Unable to update the repository", "input", pkgInfo.InputID, "package", pkgInfo.PackageName, "error", err)
 
 			w.WriteHeader(500)
 			fmt.Fprintf(w, err.Error())
@@ -127,10 +375,10 @@ func serve(c *cli.Context) error {
 		}
 
 		fmt.Fprintf(w, "OK")
-	})
+	}))
 
-	log.Printf("Listening on %q", c.String("listen"))
-	return http.ListenAndServe(c.String("listen"), nil)
+	conf.Log.Info("Listening", "address", c.String("listen"))
+	return http.ListenAndServe(c.String("listen"), logged(http.DefaultServeMux))
 }
 
 func main() {
@@ -146,7 +394,18 @@ func main() {
 			Usage: "Location of the YAML configuration file.",
 			Value: "config.yml",
 		},
+		cli.StringFlag{
+			Name:  "log-level",
+			Usage: "Least severe messages to log: debug, info, warn or error.",
+			Value: "info",
+		},
+		cli.StringFlag{
+			Name:  "log-format",
+			Usage: "Format of the log: logfmt or json.",
+			Value: logging.FormatLogfmt,
+		},
 	}
+	app.Before = setupLogging
 
 	app.Commands = []cli.Command{
 		{
@@ -161,6 +420,53 @@ func main() {
 			Usage:   "Updates a specific package in the composer.",
 			Action:  update,
 		},
+		{
+			Name:    "validate",
+			Aliases: []string{"v"},
+			Usage:   "Validates the configuration.",
+			Action:  validate,
+			Flags: []cli.Flag{
+				cli.BoolFlag{
+					Name:  "check",
+					Usage: "Check the connectivity and credentials of inputs and outputs",
+				},
+				cli.BoolFlag{
+					Name:  "dump",
+					Usage: "Print the configuration after expanding references, with secrets redacted",
+				},
+			},
+		},
+		{
+			Name:   "check-deps",
+			Usage:  "Checks that the requirements of every package in the generated repository can be satisfied.",
+			Action: checkDeps,
+			Flags: []cli.Flag{
+				cli.StringSliceFlag{
+					Name:  "allow",
+					Usage: "Pattern of packages which may come from another repository, such as \"symfony/*\"",
+				},
+				cli.BoolFlag{
+					Name:  "dev",
+					Usage: "Also check require-dev",
+				},
+			},
+		},
+		{
+			Name:   "verify",
+			Usage:  "Verifies that the files referenced from packages.json exist and match their hashes and signatures.",
+			Action: verify,
+			Flags: []cli.Flag{
+				cli.BoolFlag{
+					Name:  "repair",
+					Usage: "Regenerate the entries of the files which failed verification",
+				},
+			},
+		},
+		{
+			Name:   "keygen",
+			Usage:  "Generates an ed25519 key for signing the repository.",
+			Action: keygen,
+		},
 		{
 			Name:    "serve",
 			Aliases: []string{"s"},
@@ -179,12 +485,18 @@ func main() {
 					Name:  "no-generate",
 					Usage: "Don't generate the entire repository before listening",
 				},
+				cli.DurationFlag{
+					Name:  "watch-interval",
+					Usage: "How often to check the files of static inputs for changes",
+					Value: 10 * time.Second,
+				},
 			},
 		},
 	}
 
 	err := app.Run(os.Args)
 	if err != nil {
-		log.Fatal(err)
+		logging.Default().Error(err.Error())
+		os.Exit(1)
 	}
 }
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/zachomedia/composerrepo/pkg/composer"
+	"github.com/zachomedia/composerrepo/pkg/logging"
+)
+
+// indexMaxAge is how long the search index is cached, so updates made by
+// other replicas are picked up.
+const indexMaxAge = time.Minute
+
+// indexCache caches the search index of the repository.
+type indexCache struct {
+	conf *composer.Config
+
+	mux    sync.Mutex
+	index  composer.Index
+	loaded time.Time
+}
+
+// Get returns the index, reading it again once it is older than indexMaxAge.
+func (cache *indexCache) Get() (composer.Index, error) {
+	cache.mux.Lock()
+	defer cache.mux.Unlock()
+
+	if cache.index == nil || time.Since(cache.loaded) >= indexMaxAge {
+		index, err := composer.ReadIndex(cache.conf.Context(), cache.conf.Output)
+		if err != nil {
+			return nil, err
+		}
+
+		cache.index = index
+		cache.loaded = time.Now()
+	}
+
+	return cache.index, nil
+}
+
+// Reset forgets the index, so it is read again after the repository changes.
+func (cache *indexCache) Reset() {
+	cache.mux.Lock()
+	defer cache.mux.Unlock()
+
+	cache.index = nil
+}
+
+// searchPaths returns the paths search.json and list.json are served on,
+// from the search URL in the config.
+func searchPaths(searchURL string) (string, string, error) {
+	u, err := url.Parse(searchURL)
+	if err != nil {
+		return "", "", err
+	}
+
+	base := "/" + u.Path
+	return path.Join(base, "search.json"), path.Join(base, composer.ListFile), nil
+}
+
+type searchResult struct {
+	Name        string      `json:"name"`
+	Description string      `json:"description"`
+	Abandoned   interface{} `json:"abandoned,omitempty"`
+}
+
+// searchHandler serves search.json, used by `composer search`. q is the
+// query and type, if set, limits the results to a package type.
+func searchHandler(cache *indexCache) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		index, err := cache.Get()
+		if err != nil {
+			logging.FromContext(r.Context()).Error("Unable to read the search index", "error", err)
+
+			w.WriteHeader(500)
+			fmt.Fprint(w, "Unable to read the search index")
+			return
+		}
+
+		entries := index.Search(r.URL.Query().Get("q"), r.URL.Query().Get("type"))
+
+		results := make([]*searchResult, len(entries))
+		for indx, entry := range entries {
+			results[indx] = &searchResult{
+				Name:        entry.Name,
+				Description: entry.Description,
+				Abandoned:   entry.Abandoned,
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"results": results,
+			"total":   len(results),
+		})
+	}
+}
+
+// listHandler serves list.json, used by `composer show -a`. filter, if
+// set, is a pattern such as "vendor/*" the names must match.
+func listHandler(cache *indexCache) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		index, err := cache.Get()
+		if err != nil {
+			logging.FromContext(r.Context()).Error("Unable to read the search index", "error", err)
+
+			w.WriteHeader(500)
+			fmt.Fprint(w, "Unable to read the search index")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"packageNames": index.Names(r.URL.Query().Get("filter")),
+		})
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"path"
+	"sort"
+	"syscall"
+	"time"
+
+	"github.com/zachomedia/composerrepo/pkg/composer"
+	"github.com/zachomedia/composerrepo/pkg/logging"
+	"github.com/zachomedia/composerrepo/pkg/stats"
+)
+
+// maxNotifyBatchSize is the largest notify-batch request accepted.
+const maxNotifyBatchSize = 1 << 20
+
+// statsPaths returns the paths notify-batch and stats.json are served on,
+// from the stats URL in the config.
+func statsPaths(statsURL string) (string, string, error) {
+	u, err := url.Parse(statsURL)
+	if err != nil {
+		return "", "", err
+	}
+
+	base := "/" + u.Path
+	return path.Join(base, "downloads") + "/", path.Join(base, "stats.json"), nil
+}
+
+// flushStats saves the recorded downloads every FlushInterval, and writes
+// the pages of the packages whose counts changed again. The downloads are
+// also saved when serve is stopped.
+func flushStats(conf *composer.Config, updates *updater) {
+	log := conf.Log
+	store := conf.Stats.Store
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go (func() {
+		<-signals
+		if _, err := store.Flush(); err != nil {
+			log.Error("Unable to save the downloads", "error", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	})()
+
+	// Packages whose pages show outdated counts, such as during the
+	// initial generation
+	pending := make(map[string]bool)
+
+	ticker := time.NewTicker(conf.Stats.FlushInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		changed, err := store.Flush()
+		if err != nil {
+			log.Warn("Unable to save the downloads, retrying", "error", err)
+			continue
+		}
+
+		if conf.Site == nil {
+			continue
+		}
+
+		for _, name := range changed {
+			pending[name] = true
+		}
+		if len(pending) == 0 {
+			continue
+		}
+
+		names := make([]string, 0, len(pending))
+		for name := range pending {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		if err := updates.RefreshSite(log, names); err != nil {
+			log.Warn("Unable to write the download counts to the site, retrying", "error", err)
+			continue
+		}
+
+		pending = make(map[string]bool)
+	}
+}
+
+// notifyBatchHandler records the downloads Composer reports after
+// installing packages. Downloads of packages which aren't in the
+// repository are ignored.
+func notifyBatchHandler(store *stats.Store, known *knownPackages) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(405)
+			fmt.Fprint(w, "Expected a POST request")
+			return
+		}
+
+		body := struct {
+			Downloads []*stats.Download `json:"downloads"`
+		}{}
+		if err := json.NewDecoder(io.LimitReader(r.Body, maxNotifyBatchSize)).Decode(&body); err != nil {
+			w.WriteHeader(400)
+			fmt.Fprintf(w, "Invalid request: %v", err)
+			return
+		}
+
+		downloads := make([]*stats.Download, 0, len(body.Downloads))
+		for _, download := range body.Downloads {
+			ok, err := known.Has(download.Name)
+			if err != nil {
+				logging.FromContext(r.Context()).Error("Unable to read the repository", "error", err)
+
+				w.WriteHeader(500)
+				fmt.Fprint(w, "Unable to read the repository")
+				return
+			}
+
+			if ok && download.Version != "" {
+				downloads = append(downloads, download)
+			}
+		}
+
+		store.Record(downloads)
+
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"status":"success"}`)
+	}
+}
+
+// statsHandler serves the downloads of every package, or of the package
+// named by the package parameter.
+func statsHandler(store *stats.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var result interface{}
+
+		if name := r.URL.Query().Get("package"); name != "" {
+			pkg := store.Package(name)
+			if pkg == nil {
+				w.WriteHeader(404)
+				fmt.Fprintf(w, "No downloads of %q", name)
+				return
+			}
+			result = pkg
+		} else {
+			result = map[string]interface{}{"packages": store.Packages()}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(result)
+	}
+}
+
+// metricsHandler serves the downloads to Prometheus.
+func metricsHandler(store *stats.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		if err := store.WriteMetrics(w); err != nil {
+			logging.FromContext(r.Context()).Error("Unable to write the metrics", "error", err)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+
+	"github.com/zachomedia/composerrepo/pkg/composer"
+	"github.com/zachomedia/composerrepo/pkg/logging"
+)
+
+// errGenerating is returned for updates requested while the initial
+// repository is generated.
+var errGenerating = errors.New("Unable to update as initial repository generation is in progress")
+
+// updater runs the updates and removals requested over HTTP one at a time,
+// and resets the caches of the repository after each. Each runs as a job
+// with its own correlation ID, which the inputs, transformers and outputs
+// log with while it runs.
+type updater struct {
+	conf  *composer.Config
+	known *knownPackages
+	index *indexCache
+
+	mux sync.Mutex
+
+	// generating is set to 1, using sync/atomic, while the initial
+	// repository is generated.
+	generating int32
+}
+
+// Generate generates the repository in the background.
+func (u *updater) Generate() {
+	atomic.StoreInt32(&u.generating, 1)
+
+	conf := u.job(u.conf.Log)
+
+	go (func() {
+		defer atomic.StoreInt32(&u.generating, 0)
+
+		if err := composer.Generate(conf); err != nil {
+			conf.Log.Error("Unable to generate the repository", "error", err)
+		}
+
+		u.reset()
+	})()
+}
+
+// Generating reports whether the initial repository is being generated.
+func (u *updater) Generating() bool {
+	return atomic.LoadInt32(&u.generating) != 0
+}
+
+// Update updates packages in the repository, logging to log, such as the
+// logger of the request.
+func (u *updater) Update(log *logging.Logger, packageInfos []*composer.PackageInfo) error {
+	return u.run(log, composer.Update, packageInfos)
+}
+
+// Remove removes packages from the repository, logging to log.
+func (u *updater) Remove(log *logging.Logger, packageInfos []*composer.PackageInfo) error {
+	return u.run(log, composer.Remove, packageInfos)
+}
+
+// RefreshSite writes the pages of packages again, logging to log.
+func (u *updater) RefreshSite(log *logging.Logger, names []string) error {
+	return u.run(log, func(conf *composer.Config, _ []*composer.PackageInfo) error {
+		return composer.RefreshSite(conf, names)
+	}, nil)
+}
+
+func (u *updater) run(log *logging.Logger, fn func(*composer.Config, []*composer.PackageInfo) error, packageInfos []*composer.PackageInfo) error {
+	if u.Generating() {
+		return errGenerating
+	}
+
+	u.mux.Lock()
+	defer u.mux.Unlock()
+
+	conf := u.job(log)
+	err := fn(conf, packageInfos)
+	u.reset()
+	return err
+}
+
+// job returns a copy of the config logging to log with a new job ID, which
+// the inputs, transformers and outputs log with through conf.Context.
+func (u *updater) job(log *logging.Logger) *composer.Config {
+	conf := *u.conf
+	conf.Log = log.With("job", logging.NewID())
+	return &conf
+}
+
+func (u *updater) reset() {
+	u.known.Reset()
+	u.index.Reset()
+}
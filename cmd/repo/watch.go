@@ -0,0 +1,93 @@
+package main
+
+import (
+	"sort"
+	"time"
+
+	"github.com/zachomedia/composerrepo/pkg/composer"
+	"github.com/zachomedia/composerrepo/pkg/logging"
+)
+
+// watch checks the inputs whose packages change outside of the repository,
+// such as the files of static inputs, every interval, and updates or
+// removes the packages which changed. It is called before the initial
+// generation, so changes made after it are published.
+func watch(conf *composer.Config, updates *updater, interval time.Duration) {
+	for id, input := range conf.Inputs {
+		watched, ok := input.(composer.Watched)
+		if !ok {
+			continue
+		}
+
+		// The first call records the packages as they are generated
+		if _, _, err := watched.Changed(); err != nil {
+			conf.Log.Warn("Unable to check the packages for changes", "input", id, "error", err)
+		}
+
+		go watchInput(conf.Log, id, watched, updates, interval)
+	}
+}
+
+func watchInput(log *logging.Logger, id string, watched composer.Watched, updates *updater, interval time.Duration) {
+	// Packages waiting to be published, and whether they were removed. They
+	// stay pending until they are published, such as after the initial
+	// generation.
+	pending := make(map[string]bool)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		changed, removed, err := watched.Changed()
+		if err != nil {
+			log.Warn("Unable to check the packages for changes", "input", id, "error", err)
+			continue
+		}
+
+		for _, name := range changed {
+			pending[name] = false
+		}
+		for _, name := range removed {
+			pending[name] = true
+		}
+
+		if len(pending) == 0 {
+			continue
+		}
+
+		names := make([]string, 0, len(pending))
+		for name := range pending {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		toUpdate := make([]*composer.PackageInfo, 0)
+		toRemove := make([]*composer.PackageInfo, 0)
+		for _, name := range names {
+			pkgInfo := &composer.PackageInfo{InputID: id, PackageName: name}
+			if pending[name] {
+				toRemove = append(toRemove, pkgInfo)
+			} else {
+				toUpdate = append(toUpdate, pkgInfo)
+			}
+		}
+
+		log.Info("Packages changed", "input", id, "changed", len(toUpdate), "removed", len(toRemove))
+
+		if len(toUpdate) > 0 {
+			if err := updates.Update(log, toUpdate); err != nil {
+				log.Warn("Unable to update the changed packages, retrying", "input", id, "error", err)
+				continue
+			}
+		}
+
+		if len(toRemove) > 0 {
+			if err := updates.Remove(log, toRemove); err != nil {
+				log.Warn("Unable to remove the deleted packages, retrying", "input", id, "error", err)
+				continue
+			}
+		}
+
+		pending = make(map[string]bool)
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/zachomedia/composerrepo/pkg/auth"
+	"github.com/zachomedia/composerrepo/pkg/composer"
+	"github.com/zachomedia/composerrepo/pkg/logging"
+	"github.com/zachomedia/composerrepo/pkg/webhook"
+)
+
+// webhookHandler updates or removes the package of the repository named by
+// the webhooks of a Git host.
+//
+// The signature of the webhook authenticates it, so requests are limited
+// per webhook, and those with an invalid signature per address.
+func webhookHandler(hook *webhook.Hook, a *auth.Auth, updates *updater) func(http.ResponseWriter, *http.Request, *auth.AuditEntry) {
+	return func(w http.ResponseWriter, r *http.Request, entry *auth.AuditEntry) {
+		log := logging.FromContext(r.Context()).With("webhook", hook.Type)
+
+		if r.Method != http.MethodPost {
+			w.WriteHeader(405)
+			fmt.Fprint(w, "Expected a POST request")
+			return
+		}
+
+		event, err := hook.Read(r)
+		if err == webhook.ErrSignature {
+			log.Warn("Rejecting webhook with an invalid signature")
+
+			entry.Client = remoteHost(r)
+			if !limit(a, w, r, entry.Client) {
+				return
+			}
+
+			w.WriteHeader(401)
+			fmt.Fprint(w, err.Error())
+			return
+		} else if err != nil {
+			log.Warn("Unable to read the webhook", "error", err)
+
+			w.WriteHeader(400)
+			fmt.Fprint(w, err.Error())
+			return
+		}
+
+		entry.Client = hook.Type + " webhook " + hook.Path
+		if !limit(a, w, r, entry.Client) {
+			return
+		}
+
+		if event.Action == webhook.ActionIgnore {
+			fmt.Fprint(w, "OK")
+			return
+		}
+
+		pkgInfo := &composer.PackageInfo{
+			InputID:     hook.InputID,
+			PackageName: hook.PackageName(event.Repository),
+		}
+
+		entry.Action = string(event.Action)
+		entry.Input = pkgInfo.InputID
+		entry.Package = pkgInfo.PackageName
+
+		if event.Action == webhook.ActionRemove {
+			err = updates.Remove(log, []*composer.PackageInfo{pkgInfo})
+		} else {
+			err = updates.Update(log, []*composer.PackageInfo{pkgInfo})
+		}
+
+		if err == errGenerating {
+			log.Warn("Refusing update due to generation in progress", "input", pkgInfo.InputID, "package", pkgInfo.PackageName)
+
+			w.WriteHeader(502)
+			fmt.Fprint(w, err.Error())
+			return
+		} else if err != nil {
+			log.Error("Unable to update the repository", "input", pkgInfo.InputID, "package", pkgInfo.PackageName, "error", err)
+
+			w.WriteHeader(500)
+			fmt.Fprint(w, err.Error())
+			return
+		}
+
+		fmt.Fprint(w, "OK")
+	}
+}
@@ -0,0 +1,83 @@
+package auth
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/zachomedia/composerrepo/pkg/logging"
+)
+
+// AuditEntry records a request to change the repository.
+type AuditEntry struct {
+	Time       time.Time `json:"time"`
+	Request    string    `json:"request,omitempty"`
+	Client     string    `json:"client"`
+	RemoteAddr string    `json:"remoteAddr"`
+	Endpoint   string    `json:"endpoint"`
+	Action     string    `json:"action"`
+	Input      string    `json:"input,omitempty"`
+	Package    string    `json:"package,omitempty"`
+	Status     int       `json:"status"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// AuditLog writes an entry per line, as JSON, to W or to Log if W is nil.
+type AuditLog struct {
+	W   io.Writer
+	Log *logging.Logger
+
+	// Path, if set, is the file Open appends entries to.
+	Path string
+
+	mux sync.Mutex
+}
+
+// Open opens Path, creating it if it doesn't exist, as W.
+func (audit *AuditLog) Open() error {
+	if audit.Path == "" {
+		return nil
+	}
+
+	f, err := os.OpenFile(audit.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+
+	audit.W = f
+	return nil
+}
+
+// Record writes an entry.
+func (audit *AuditLog) Record(entry *AuditEntry) {
+	if entry.Time.IsZero() {
+		entry.Time = time.Now().UTC()
+	}
+
+	var log *logging.Logger
+	if audit != nil {
+		log = audit.Log
+	}
+
+	if audit == nil || audit.W == nil {
+		log.Info("Audit", "request", entry.Request, "client", entry.Client, "remoteAddr", entry.RemoteAddr,
+			"endpoint", entry.Endpoint, "action", entry.Action, "input", entry.Input, "package", entry.Package,
+			"status", entry.Status, "error", entry.Error)
+		return
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		log.Error("Unable to write the audit log", "error", err)
+		return
+	}
+
+	audit.mux.Lock()
+	defer audit.mux.Unlock()
+
+	if _, err := audit.W.Write(append(data, '\n')); err != nil {
+		log.Error("Unable to write the audit log", "error", err)
+	}
+}
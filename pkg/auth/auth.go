@@ -0,0 +1,144 @@
+// Package auth authenticates the clients of the endpoints of `repo serve`
+// which change the repository, limits how often they can be called, and
+// records who called them.
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MaxClockSkew is how far the timestamp of an HMAC signed request may be
+// from the time of the server.
+const MaxClockSkew = 5 * time.Minute
+
+// ErrUnauthorized is returned for requests without valid credentials.
+var ErrUnauthorized = errors.New("Invalid or missing credentials")
+
+// Client is allowed to call the endpoints with a bearer Token, or by signing
+// requests with Secret.
+type Client struct {
+	Name   string
+	Token  string
+	Secret string
+
+	// Inputs are the inputs the client may update. Empty allows every input.
+	Inputs []string
+}
+
+// AllowsInput reports whether the client may update packages of the input.
+func (client *Client) AllowsInput(inputID string) bool {
+	if len(client.Inputs) == 0 {
+		return true
+	}
+
+	for _, id := range client.Inputs {
+		if id == inputID {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Auth protects the endpoints which change the repository.
+type Auth struct {
+	// Clients are the credentials accepted.
+	Clients []*Client
+
+	// AllowAnonymous, without clients, accepts requests without
+	// credentials. They are still limited and audited. Without clients or
+	// AllowAnonymous, every request is refused.
+	AllowAnonymous bool
+
+	// Limiter, if set, limits the requests of each client.
+	Limiter *RateLimiter
+
+	// Audit records every request.
+	Audit *AuditLog
+}
+
+// Enabled reports whether requests have to be authenticated.
+func (auth *Auth) Enabled() bool {
+	return auth != nil && len(auth.Clients) > 0
+}
+
+// Authenticate returns the client making the request, from its
+// Authorization header:
+//
+//	Authorization: Bearer <token>
+//	Authorization: HMAC <client>:<timestamp>:<signature>
+//
+// The signature is the hex encoded HMAC-SHA256, with the client's secret, of
+// the Unix timestamp, the method, the request URI and the body, separated by
+// newlines. body is the body of the request, which has already been read.
+func (auth *Auth) Authenticate(r *http.Request, body []byte) (*Client, error) {
+	header := r.Header.Get("Authorization")
+
+	switch {
+	case strings.HasPrefix(header, "Bearer "):
+		token := []byte(strings.TrimPrefix(header, "Bearer "))
+		for _, client := range auth.Clients {
+			if client.Token != "" && subtle.ConstantTimeCompare(token, []byte(client.Token)) == 1 {
+				return client, nil
+			}
+		}
+
+	case strings.HasPrefix(header, "HMAC "):
+		parts := strings.Split(strings.TrimPrefix(header, "HMAC "), ":")
+		if len(parts) != 3 {
+			return nil, ErrUnauthorized
+		}
+
+		client := auth.client(parts[0])
+		if client == nil || client.Secret == "" {
+			return nil, ErrUnauthorized
+		}
+
+		timestamp, err := strconv.ParseInt(parts[1], 10, 64)
+		if err != nil {
+			return nil, ErrUnauthorized
+		}
+
+		if skew := time.Since(time.Unix(timestamp, 0)); skew > MaxClockSkew || skew < -MaxClockSkew {
+			return nil, ErrUnauthorized
+		}
+
+		signature, err := hex.DecodeString(parts[2])
+		if err != nil || !hmac.Equal(signature, Sign(client.Secret, timestamp, r.Method, r.URL.RequestURI(), body)) {
+			return nil, ErrUnauthorized
+		}
+
+		return client, nil
+	}
+
+	return nil, ErrUnauthorized
+}
+
+// client returns the client with the name, if any.
+func (auth *Auth) client(name string) *Client {
+	for _, client := range auth.Clients {
+		if client.Name == name {
+			return client
+		}
+	}
+
+	return nil
+}
+
+// Sign returns the HMAC-SHA256 signature of a request.
+func Sign(secret string, timestamp int64, method string, requestURI string, body []byte) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d\n%s\n%s\n", timestamp, method, requestURI)
+	mac.Write(body)
+
+	return mac.Sum(nil)
+}
@@ -0,0 +1,96 @@
+package auth
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestAuthenticate(t *testing.T) {
+	auth := &Auth{
+		Clients: []*Client{
+			{Name: "ci", Token: "ci-token"},
+			{Name: "deploy", Secret: "deploy-secret"},
+			{Name: "empty"},
+		},
+	}
+
+	const uri = "/?input=gitlab&package=acme/widget"
+	body := []byte("payload")
+	now := time.Now().Unix()
+
+	hmacHeader := func(name, secret string, timestamp int64, method, requestURI string, body []byte) string {
+		return fmt.Sprintf("HMAC %s:%d:%s", name, timestamp, hex.EncodeToString(Sign(secret, timestamp, method, requestURI, body)))
+	}
+
+	tests := []struct {
+		name   string
+		header string
+		client string
+	}{
+		{"bearer token", "Bearer ci-token", "ci"},
+		{"wrong bearer token", "Bearer nope", ""},
+		{"empty bearer token", "Bearer ", ""},
+		{"bearer secret", "Bearer deploy-secret", ""},
+		{"missing header", "", ""},
+		{"unknown scheme", "Basic Y2k6Y2ktdG9rZW4=", ""},
+
+		{"hmac", hmacHeader("deploy", "deploy-secret", now, "POST", uri, body), "deploy"},
+		{"hmac within skew", hmacHeader("deploy", "deploy-secret", now-int64(MaxClockSkew/time.Second)+30, "POST", uri, body), "deploy"},
+		{"hmac from the past", hmacHeader("deploy", "deploy-secret", now-int64(MaxClockSkew/time.Second)-30, "POST", uri, body), ""},
+		{"hmac from the future", hmacHeader("deploy", "deploy-secret", now+int64(MaxClockSkew/time.Second)+30, "POST", uri, body), ""},
+		{"hmac wrong secret", hmacHeader("deploy", "other-secret", now, "POST", uri, body), ""},
+		{"hmac other method", hmacHeader("deploy", "deploy-secret", now, "DELETE", uri, body), ""},
+		{"hmac other uri", hmacHeader("deploy", "deploy-secret", now, "POST", "/?input=gitlab&package=acme/other", body), ""},
+		{"hmac other body", hmacHeader("deploy", "deploy-secret", now, "POST", uri, []byte("tampered")), ""},
+		{"hmac unknown client", hmacHeader("nobody", "deploy-secret", now, "POST", uri, body), ""},
+		{"hmac client without secret", hmacHeader("empty", "", now, "POST", uri, body), ""},
+		{"hmac client with token", hmacHeader("ci", "ci-token", now, "POST", uri, body), ""},
+		{"hmac invalid signature", fmt.Sprintf("HMAC deploy:%d:not-hex", now), ""},
+		{"hmac invalid timestamp", "HMAC deploy:yesterday:00", ""},
+		{"hmac missing parts", "HMAC deploy:00", ""},
+	}
+
+	for _, test := range tests {
+		r := httptest.NewRequest("POST", uri, nil)
+		if test.header != "" {
+			r.Header.Set("Authorization", test.header)
+		}
+
+		client, err := auth.Authenticate(r, body)
+		if test.client == "" {
+			if err != ErrUnauthorized {
+				t.Errorf("%s: Authenticate = %v, %v, want ErrUnauthorized", test.name, client, err)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%s: Authenticate returned error: %v", test.name, err)
+		} else if client.Name != test.client {
+			t.Errorf("%s: Authenticate = %q, want %q", test.name, client.Name, test.client)
+		}
+	}
+}
+
+func TestAllowsInput(t *testing.T) {
+	tests := []struct {
+		inputs  []string
+		input   string
+		allowed bool
+	}{
+		{nil, "gitlab", true},
+		{[]string{"gitlab"}, "gitlab", true},
+		{[]string{"gitlab", "static"}, "static", true},
+		{[]string{"gitlab"}, "static", false},
+	}
+
+	for _, test := range tests {
+		client := &Client{Name: "ci", Inputs: test.inputs}
+		if got := client.AllowsInput(test.input); got != test.allowed {
+			t.Errorf("AllowsInput(%q) with inputs %v = %t, want %t", test.input, test.inputs, got, test.allowed)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package auth
+
+import (
+	"sync"
+	"time"
+)
+
+// RateLimiter allows each client Requests requests every Interval, with a
+// token bucket refilled continuously, so short bursts are allowed.
+type RateLimiter struct {
+	Requests int
+	Interval time.Duration
+
+	mux     sync.Mutex
+	buckets map[string]*bucket
+}
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// Allow takes a request from the bucket of client. If the bucket is empty,
+// it returns false and how long until the next request is allowed.
+func (limiter *RateLimiter) Allow(client string) (bool, time.Duration) {
+	limiter.mux.Lock()
+	defer limiter.mux.Unlock()
+
+	if limiter.buckets == nil {
+		limiter.buckets = make(map[string]*bucket)
+	}
+
+	now := time.Now()
+	capacity := float64(limiter.Requests)
+	rate := capacity / float64(limiter.Interval)
+
+	b, ok := limiter.buckets[client]
+	if !ok {
+		b = &bucket{tokens: capacity, updated: now}
+		limiter.buckets[client] = b
+	}
+
+	b.tokens += float64(now.Sub(b.updated)) * rate
+	if b.tokens > capacity {
+		b.tokens = capacity
+	}
+	b.updated = now
+
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / rate)
+	}
+
+	b.tokens--
+	limiter.prune(now)
+	return true, 0
+}
+
+// prune forgets the buckets which have been full for a while, so clients
+// which come and go don't accumulate.
+func (limiter *RateLimiter) prune(now time.Time) {
+	if len(limiter.buckets) < 1024 {
+		return
+	}
+
+	for client, b := range limiter.buckets {
+		if now.Sub(b.updated) > limiter.Interval {
+			delete(limiter.buckets, client)
+		}
+	}
+}
@@ -0,0 +1,319 @@
+package composer
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// advisoryTimeFormat is the format of dates in advisories, as used by Packagist.
+const advisoryTimeFormat = "2006-01-02 15:04:05"
+
+// SecurityAdvisories is the security-advisories entry of packages.json,
+// which tells Composer where to find advisories for `composer audit`.
+type SecurityAdvisories struct {
+	// Metadata is set when advisories are included in the package
+	// metadata. They are only available from the API.
+	Metadata bool   `json:"metadata"`
+	APIURL   string `json:"api-url,omitempty"`
+}
+
+// Advisory is a security advisory, as returned by the security-advisories API.
+type Advisory struct {
+	AdvisoryID         string            `json:"advisoryId"`
+	PackageName        string            `json:"packageName"`
+	RemoteID           string            `json:"remoteId"`
+	Title              string            `json:"title"`
+	Link               string            `json:"link,omitempty"`
+	CVE                string            `json:"cve,omitempty"`
+	AffectedVersions   string            `json:"affectedVersions"`
+	Source             string            `json:"source"`
+	ReportedAt         string            `json:"reportedAt"`
+	ComposerRepository string            `json:"composerRepository,omitempty"`
+	Severity           string            `json:"severity,omitempty"`
+	Sources            []*AdvisorySource `json:"sources"`
+}
+
+// AdvisorySource identifies where an advisory was published.
+type AdvisorySource struct {
+	Name     string `json:"name"`
+	RemoteID string `json:"remoteId"`
+}
+
+// AdvisoryDir is a directory of advisories in the format of the
+// FriendsOfPHP/security-advisories database, with one YAML file per advisory.
+type AdvisoryDir struct {
+	Name string
+	Dir  string
+}
+
+// advisoryFile is an advisory in the FriendsOfPHP/security-advisories format.
+type advisoryFile struct {
+	Title              string                     `yaml:"title"`
+	Link               string                     `yaml:"link"`
+	CVE                string                     `yaml:"cve"`
+	Reference          string                     `yaml:"reference"`
+	Severity           string                     `yaml:"severity"`
+	ComposerRepository interface{}                `yaml:"composer-repository"`
+	Branches           map[string]*advisoryBranch `yaml:"branches"`
+}
+
+type advisoryBranch struct {
+	Time     string   `yaml:"time"`
+	Versions []string `yaml:"versions"`
+}
+
+// AdvisoryDatabase serves the advisories read from a list of directories.
+// The directories are read again when their files change, at most once
+// every ReloadInterval.
+type AdvisoryDatabase struct {
+	APIURL         string
+	Dirs           []*AdvisoryDir
+	ReloadInterval time.Duration
+
+	mux        sync.Mutex
+	checked    time.Time
+	files      map[string]time.Time
+	advisories map[string][]*Advisory
+}
+
+// Metadata returns the security-advisories entry of packages.json.
+func (db *AdvisoryDatabase) Metadata() *SecurityAdvisories {
+	return &SecurityAdvisories{APIURL: db.APIURL}
+}
+
+// Check reads every advisory, reporting invalid files.
+func (db *AdvisoryDatabase) Check() error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	return db.load()
+}
+
+// Get returns the advisories of the named packages, reported after
+// updatedSince unless it is zero.
+func (db *AdvisoryDatabase) Get(names []string, updatedSince time.Time) (map[string][]*Advisory, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	if db.advisories == nil || time.Since(db.checked) >= db.ReloadInterval {
+		if err := db.load(); err != nil {
+			return nil, err
+		}
+	}
+
+	result := make(map[string][]*Advisory)
+	for _, name := range names {
+		name = strings.ToLower(name)
+
+		for _, advisory := range db.advisories[name] {
+			if !updatedSince.IsZero() {
+				reported, err := time.Parse(advisoryTimeFormat, advisory.ReportedAt)
+				if err == nil && reported.Before(updatedSince) {
+					continue
+				}
+			}
+
+			result[name] = append(result[name], advisory)
+		}
+	}
+
+	return result, nil
+}
+
+// load reads the advisories, unless no file changed since they were last read.
+func (db *AdvisoryDatabase) load() error {
+	files := make(map[string]time.Time)
+	paths := make([][]string, len(db.Dirs))
+
+	for indx, dir := range db.Dirs {
+		root := dir.Dir
+		err := filepath.Walk(root, func(fPath string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+
+			// Skip .git and the other hidden folders of a clone of the database
+			if info.IsDir() && strings.HasPrefix(info.Name(), ".") && fPath != root {
+				return filepath.SkipDir
+			}
+
+			if !info.IsDir() && isAdvisoryFile(info.Name()) {
+				files[fPath] = info.ModTime()
+				paths[indx] = append(paths[indx], fPath)
+			}
+
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	db.checked = time.Now()
+
+	if db.advisories != nil && !advisoryFilesChanged(db.files, files) {
+		return nil
+	}
+
+	advisories := make(map[string][]*Advisory)
+	for indx, dir := range db.Dirs {
+		for _, fPath := range paths[indx] {
+			rel, err := filepath.Rel(dir.Dir, fPath)
+			if err != nil {
+				return err
+			}
+
+			advisory, err := readAdvisory(dir.Name, filepath.ToSlash(rel), fPath)
+			if err != nil {
+				return fmt.Errorf("Unable to read advisory %q: %v", fPath, err)
+			}
+
+			advisories[advisory.PackageName] = append(advisories[advisory.PackageName], advisory)
+		}
+	}
+
+	db.files = files
+	db.advisories = advisories
+
+	return nil
+}
+
+func advisoryFilesChanged(old map[string]time.Time, files map[string]time.Time) bool {
+	if len(old) != len(files) {
+		return true
+	}
+
+	for name, modTime := range files {
+		if !old[name].Equal(modTime) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func isAdvisoryFile(name string) bool {
+	ext := strings.ToLower(filepath.Ext(name))
+	return ext == ".yaml" || ext == ".yml"
+}
+
+// readAdvisory reads an advisory file. remoteID is its path in the
+// database, such as vendor/package/CVE-2020-1234.yaml.
+func readAdvisory(source string, remoteID string, fPath string) (*Advisory, error) {
+	data, err := ioutil.ReadFile(fPath)
+	if err != nil {
+		return nil, err
+	}
+
+	file := &advisoryFile{}
+	if err := yaml.Unmarshal(data, file); err != nil {
+		return nil, err
+	}
+
+	// The package is referenced as composer://vendor/package, and otherwise
+	// is the folder containing the advisory
+	name := strings.TrimPrefix(file.Reference, "composer://")
+	if name == "" {
+		name = strings.TrimPrefix(filepath.ToSlash(filepath.Dir(remoteID)), "./")
+	}
+	if strings.Count(name, "/") != 1 {
+		return nil, fmt.Errorf("unable to find the package of the advisory, set reference to composer://vendor/package")
+	}
+
+	if file.Title == "" {
+		return nil, fmt.Errorf("title is required")
+	}
+
+	if len(file.Branches) == 0 {
+		return nil, fmt.Errorf("branches is required")
+	}
+
+	branchNames := make([]string, 0, len(file.Branches))
+	for branch := range file.Branches {
+		branchNames = append(branchNames, branch)
+	}
+	sort.Strings(branchNames)
+
+	// Affected versions are the constraints of each branch, which Composer
+	// combines with "|"
+	constraints := make([]string, 0, len(branchNames))
+	var reportedAt time.Time
+	for _, branchName := range branchNames {
+		branch := file.Branches[branchName]
+		if branch == nil || len(branch.Versions) == 0 {
+			return nil, fmt.Errorf("branches.%s.versions is required", branchName)
+		}
+		constraints = append(constraints, strings.Join(branch.Versions, ","))
+
+		if branch.Time == "" {
+			continue
+		}
+
+		t, err := time.Parse(advisoryTimeFormat, branch.Time)
+		if err != nil {
+			return nil, fmt.Errorf("branches.%s.time: expected a time such as %q, got %q", branchName, advisoryTimeFormat, branch.Time)
+		}
+
+		if reportedAt.IsZero() || t.Before(reportedAt) {
+			reportedAt = t
+		}
+	}
+
+	// Without a time, the advisory is reported when it was added
+	if reportedAt.IsZero() {
+		info, err := os.Stat(fPath)
+		if err != nil {
+			return nil, err
+		}
+		reportedAt = info.ModTime().UTC()
+	}
+
+	advisory := &Advisory{
+		AdvisoryID:       advisoryID(source, remoteID),
+		PackageName:      strings.ToLower(name),
+		RemoteID:         remoteID,
+		Title:            file.Title,
+		Link:             file.Link,
+		CVE:              file.CVE,
+		AffectedVersions: strings.Join(constraints, "|"),
+		Source:           source,
+		ReportedAt:       reportedAt.Format(advisoryTimeFormat),
+		Severity:         file.Severity,
+		Sources:          []*AdvisorySource{{Name: source, RemoteID: remoteID}},
+	}
+
+	if repo, ok := file.ComposerRepository.(string); ok {
+		advisory.ComposerRepository = repo
+	}
+
+	return advisory, nil
+}
+
+// advisoryID returns a stable ID for an advisory, such as FRIE-1a2b3c4d5e6f
+// for an advisory of FriendsOfPHP/security-advisories.
+func advisoryID(source string, remoteID string) string {
+	prefix := make([]rune, 0, 4)
+	for _, r := range strings.ToUpper(source) {
+		if len(prefix) == 4 {
+			break
+		}
+
+		if (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			prefix = append(prefix, r)
+		}
+	}
+
+	if len(prefix) == 0 {
+		prefix = []rune("ADV")
+	}
+
+	return fmt.Sprintf("%s-%x", string(prefix), sha1.Sum([]byte(source+"/"+remoteID)))[:len(prefix)+13]
+}
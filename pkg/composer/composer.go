@@ -1,10 +1,19 @@
 package composer
 
-import "time"
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
 
 type Dist struct {
-	URL  string `json:"url" msgpack:"url"`
-	Type string `json:"type" msgpack:"type"`
+	URL       string `json:"url" msgpack:"url"`
+	Type      string `json:"type" msgpack:"type"`
+	Reference string `json:"reference,omitempty" msgpack:"reference"`
+	Shasum    string `json:"shasum,omitempty" msgpack:"shasum"`
 }
 
 type Source struct {
@@ -72,6 +81,87 @@ type Package struct {
 	NonFeatureBranches []string               `json:"non-feature-branches,omitempty" msgpack:"non-feature-branches"`
 	Dist               *Dist                  `json:"dist,omitempty" msgpack:"dist"`
 	Source             *Source                `json:"source,omitempty" msgpack:"source"`
+
+	// Other holds the fields of composer.json the struct doesn't model, such
+	// as funding, so they're kept when packages are read and written.
+	Other map[string]json.RawMessage `json:"-" msgpack:"-"`
+}
+
+// packageFields are the composer.json fields modelled by Package.
+var packageFields = func() map[string]bool {
+	fields := make(map[string]bool)
+
+	t := reflect.TypeOf(Package{})
+	for indx := 0; indx < t.NumField(); indx++ {
+		name := strings.Split(t.Field(indx).Tag.Get("json"), ",")[0]
+		if name != "" && name != "-" {
+			fields[name] = true
+		}
+	}
+
+	return fields
+}()
+
+// packageJSON has the fields of Package without its methods.
+type packageJSON Package
+
+// UnmarshalJSON reads the package, keeping the fields it doesn't model in
+// Other.
+func (pkg *Package) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, (*packageJSON)(pkg)); err != nil {
+		return err
+	}
+
+	raw := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	pkg.Other = nil
+	for k, v := range raw {
+		if packageFields[k] {
+			continue
+		}
+
+		if pkg.Other == nil {
+			pkg.Other = make(map[string]json.RawMessage)
+		}
+		pkg.Other[k] = v
+	}
+
+	return nil
+}
+
+// MarshalJSON writes the package, followed by the fields in Other.
+func (pkg Package) MarshalJSON() ([]byte, error) {
+	data, err := json.Marshal(packageJSON(pkg))
+	if err != nil || len(pkg.Other) == 0 {
+		return data, err
+	}
+
+	keys := make([]string, 0, len(pkg.Other))
+	for k := range pkg.Other {
+		if !packageFields[k] {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	var buf bytes.Buffer
+	buf.Write(data[:len(data)-1])
+	for _, k := range keys {
+		if buf.Len() > 1 {
+			buf.WriteByte(',')
+		}
+
+		key, _ := json.Marshal(k)
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(pkg.Other[k])
+	}
+	buf.WriteByte('}')
+
+	return buf.Bytes(), nil
 }
 
 type PackageVersions map[string]*Package
@@ -0,0 +1,315 @@
+package composer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/zachomedia/composerrepo/pkg/semver"
+)
+
+// DependencyCheck configures checking that the requirements of every
+// package can be satisfied by the repository.
+type DependencyCheck struct {
+	// Allow are patterns of packages which may come from another
+	// repository, such as "symfony/*".
+	Allow []string
+
+	// Dev also checks require-dev.
+	Dev bool
+
+	// Fail makes Generate fail when a requirement can't be satisfied,
+	// instead of only logging it.
+	Fail bool
+}
+
+// UnsatisfiedDependency is a requirement no version in the repository matches.
+type UnsatisfiedDependency struct {
+	Package    string
+	Version    string
+	Link       string
+	Target     string
+	Constraint string
+	Reason     string
+}
+
+func (dep *UnsatisfiedDependency) String() string {
+	return fmt.Sprintf("%s@%s %s %s %s: %s", dep.Package, dep.Version, dep.Link, dep.Target, dep.Constraint, dep.Reason)
+}
+
+// platformPattern matches requirements on the platform, which are provided
+// by the environment rather than a repository.
+var platformPattern = regexp.MustCompile(`^(php(-64bit|-ipv6|-zts|-debug)?|hhvm|composer(-plugin-api|-runtime-api)?|(ext|lib)-.+)$`)
+
+// IsPlatformPackage reports whether name is a platform package, such as php or ext-json.
+func IsPlatformPackage(name string) bool {
+	return platformPattern.MatchString(strings.ToLower(name))
+}
+
+// candidate is a version of a package, or of a package it provides or replaces.
+type candidate struct {
+	version *semver.Version
+
+	// ranged is set for packages provided or replaced as a range of
+	// versions, which are assumed to overlap any requirement.
+	ranged bool
+}
+
+func (c *candidate) satisfies(constraint semver.Constraint) bool {
+	return c.ranged || constraint.Matches(c.version)
+}
+
+type namedLinks struct {
+	name  string
+	links PackageLink
+}
+
+// CheckDependencies reports the requirements of pkgs which no package in
+// pkgs, or allowed by check, satisfies.
+func CheckDependencies(pkgs Packages, check *DependencyCheck) []*UnsatisfiedDependency {
+	candidates := make(map[string][]*candidate)
+	add := func(name string, c *candidate) {
+		name = strings.ToLower(name)
+		candidates[name] = append(candidates[name], c)
+	}
+
+	for name, versions := range pkgs {
+		for version, pkg := range versions {
+			v, err := semver.Parse(version)
+			if err != nil {
+				continue
+			}
+			add(name, &candidate{version: v})
+
+			for _, alias := range branchAliases(pkg, version) {
+				add(name, &candidate{version: alias})
+			}
+
+			for _, links := range []PackageLink{pkg.Provide, pkg.Replace} {
+				for target, constraint := range links {
+					add(target, linkCandidate(constraint, v))
+				}
+			}
+		}
+	}
+
+	unsatisfied := make([]*UnsatisfiedDependency, 0)
+
+	for _, name := range sortedPackageNames(pkgs) {
+		versions := pkgs[name]
+		for _, version := range sortedVersions(versions) {
+			pkg := versions[version]
+
+			links := []namedLinks{{"require", pkg.Require}}
+			if check.Dev {
+				links = append(links, namedLinks{"require-dev", pkg.RequireDev})
+			}
+
+			for _, link := range links {
+				for _, target := range sortedLinkTargets(link.links) {
+					if IsPlatformPackage(target) || isAllowed(target, check.Allow) {
+						continue
+					}
+
+					dep := &UnsatisfiedDependency{
+						Package:    name,
+						Version:    version,
+						Link:       link.name,
+						Target:     target,
+						Constraint: link.links[target],
+					}
+
+					if reason := satisfy(candidates[strings.ToLower(target)], dep.Constraint); reason != "" {
+						dep.Reason = reason
+						unsatisfied = append(unsatisfied, dep)
+					}
+				}
+			}
+		}
+	}
+
+	return unsatisfied
+}
+
+// satisfy returns why none of candidates satisfy constraint, or an empty string.
+func satisfy(candidates []*candidate, constraint string) string {
+	if len(candidates) == 0 {
+		return "not in the repository"
+	}
+
+	c, err := semver.ParseConstraint(constraint)
+	if err != nil {
+		return err.Error()
+	}
+
+	for _, candidate := range candidates {
+		if candidate.satisfies(c) {
+			return ""
+		}
+	}
+
+	return "no version matches"
+}
+
+// linkCandidate returns the candidate for a provide or replace link of
+// version. The link is usually a constraint, or self.version.
+func linkCandidate(constraint string, version *semver.Version) *candidate {
+	if constraint == "self.version" {
+		return &candidate{version: version}
+	}
+
+	if v, err := semver.Parse(constraint); err == nil {
+		return &candidate{version: v}
+	}
+
+	return &candidate{ranged: true}
+}
+
+// branchAliases returns the versions the branch is aliased to in
+// extra.branch-alias, such as 1.x-dev for dev-main.
+func branchAliases(pkg *Package, version string) []*semver.Version {
+	extra, ok := pkg.Extra.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+
+	aliases, ok := extra["branch-alias"].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+
+	result := make([]*semver.Version, 0)
+	for branch, alias := range aliases {
+		aliasStr, ok := alias.(string)
+		if !ok || branch != version {
+			continue
+		}
+
+		if v, err := semver.Parse(aliasStr); err == nil {
+			result = append(result, v)
+		}
+	}
+
+	return result
+}
+
+func isAllowed(name string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(strings.ToLower(pattern), strings.ToLower(name)); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+func sortedPackageNames(pkgs Packages) []string {
+	names := make([]string, 0, len(pkgs))
+	for name := range pkgs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+func sortedVersions(versions PackageVersions) []string {
+	result := make([]string, 0, len(versions))
+	for version := range versions {
+		result = append(result, version)
+	}
+	sort.Strings(result)
+
+	return result
+}
+
+func sortedLinkTargets(links PackageLink) []string {
+	targets := make([]string, 0, len(links))
+	for target := range links {
+		targets = append(targets, target)
+	}
+	sort.Strings(targets)
+
+	return targets
+}
+
+// ReadPackages reads every package in the repository written to output,
+// whether they are listed in packages.json or in provider files.
+func ReadPackages(ctx context.Context, output Output) (Packages, error) {
+	return readPackages(ctx, output, nil)
+}
+
+// readPackages reads the named packages in the repository written to
+// output, or every package if names is nil.
+func readPackages(ctx context.Context, output Output, names []string) (Packages, error) {
+	var only map[string]bool
+	if names != nil {
+		only = make(map[string]bool, len(names))
+		for _, name := range names {
+			only[strings.ToLower(name)] = true
+		}
+	}
+	wanted := func(name string) bool {
+		return only == nil || only[strings.ToLower(name)]
+	}
+
+	repo := &Repository{}
+	if err := readJSON(ctx, output, "packages.json", repo); err != nil {
+		return nil, err
+	}
+
+	pkgs := make(Packages)
+	for name, versions := range repo.Packages {
+		if wanted(name) {
+			pkgs[name] = versions
+		}
+	}
+
+	includes := make([]string, 0, len(repo.ProviderIncludes))
+	for include := range repo.ProviderIncludes {
+		includes = append(includes, include)
+	}
+	sort.Strings(includes)
+
+	for _, include := range includes {
+		provider := &Repository{}
+		providerPath := strings.Replace(include, "%hash%", repo.ProviderIncludes[include].SHA256, -1)
+		if err := readJSON(ctx, output, providerPath, provider); err != nil {
+			return nil, err
+		}
+
+		for name, ref := range provider.Providers {
+			if !wanted(name) {
+				continue
+			}
+
+			pkgRepo := &Repository{}
+			if err := readJSON(ctx, output, fmt.Sprintf("p/%s$%s.json", name, ref.SHA256), pkgRepo); err != nil {
+				return nil, err
+			}
+
+			for pkgName, versions := range pkgRepo.Packages {
+				pkgs[pkgName] = versions
+			}
+		}
+	}
+
+	return pkgs, nil
+}
+
+func readJSON(ctx context.Context, output Output, name string, v interface{}) error {
+	data, err := output.Get(ctx, name)
+	if err != nil {
+		return err
+	}
+
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("Unable to read %q: %v", name, err)
+	}
+
+	return nil
+}
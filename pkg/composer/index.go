@@ -0,0 +1,266 @@
+package composer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/zachomedia/composerrepo/pkg/semver"
+)
+
+// IndexFile is the file of the output containing the search index.
+const IndexFile = "index.json"
+
+// ListFile is the file of the output listing the package names, written
+// when Search.Static is set.
+const ListFile = "list.json"
+
+// Search configures the index behind the search.json and list.json endpoints.
+type Search struct {
+	// URL is where `repo serve` answers search.json and list.json.
+	URL string
+
+	// Static also writes list.json to the output.
+	Static bool
+}
+
+// IndexEntry is what the index knows about a package, from its latest version.
+type IndexEntry struct {
+	Name        string      `json:"name"`
+	Version     string      `json:"version,omitempty"`
+	Description string      `json:"description,omitempty"`
+	Keywords    []string    `json:"keywords,omitempty"`
+	Type        string      `json:"type,omitempty"`
+	Abandoned   interface{} `json:"abandoned,omitempty"`
+}
+
+// Index is the search index of the repository, by package name.
+type Index map[string]*IndexEntry
+
+// NewIndexEntry returns the index entry of a package, described by its
+// latest stable version, or its latest version if none are stable.
+func NewIndexEntry(name string, versions PackageVersions) *IndexEntry {
+	entry := &IndexEntry{Name: name}
+
+	var latest *Package
+	var latestVersion *semver.Version
+	for _, version := range sortedVersions(versions) {
+		v, err := semver.Parse(version)
+		if err != nil {
+			continue
+		}
+
+		if latestVersion == nil || preferVersion(v, latestVersion) {
+			latest, latestVersion = versions[version], v
+		}
+	}
+
+	if latest == nil {
+		return entry
+	}
+
+	entry.Version = latest.Version
+	entry.Description = latest.Description
+	entry.Keywords = latest.Keywords
+	entry.Type = latest.Type
+	if latest.Abandoned {
+		entry.Abandoned = true
+	}
+
+	return entry
+}
+
+// preferVersion reports whether v describes a package better than current.
+// Stable versions are preferred, then the highest version.
+func preferVersion(v *semver.Version, current *semver.Version) bool {
+	stable := v.Stability == semver.StabilityStable
+	currentStable := current.Stability == semver.StabilityStable
+	if stable != currentStable {
+		return stable
+	}
+
+	return semver.Compare(v, current) > 0
+}
+
+// Names returns the names of the packages matching filter, in sorted order.
+// Like Packagist, * in the filter matches any characters, including /, and
+// case is ignored, so "vendor/*" and "*logger*" both match "vendor/logger".
+// An empty filter matches every package.
+func (index Index) Names(filter string) []string {
+	var pattern *regexp.Regexp
+	if filter != "" {
+		pattern = regexp.MustCompile("(?i)^" + strings.Replace(regexp.QuoteMeta(filter), `\*`, ".*", -1) + "$")
+	}
+
+	names := make([]string, 0, len(index))
+	for name, entry := range index {
+		if pattern != nil && !pattern.MatchString(name) {
+			continue
+		}
+
+		names = append(names, entry.Name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// Search returns the packages whose name, description or keywords contain
+// every word of query, and whose type is typ unless it is empty. Packages
+// whose name matches come first.
+func (index Index) Search(query string, typ string) []*IndexEntry {
+	words := strings.Fields(strings.ToLower(query))
+
+	type result struct {
+		entry     *IndexEntry
+		nameMatch bool
+	}
+	results := make([]*result, 0)
+
+	for _, entry := range index {
+		if typ != "" && entry.Type != typ {
+			continue
+		}
+
+		name := strings.ToLower(entry.Name)
+		text := strings.ToLower(strings.Join(append([]string{name, entry.Description}, entry.Keywords...), " "))
+
+		matches := true
+		nameMatch := len(words) > 0
+		for _, word := range words {
+			if !strings.Contains(text, word) {
+				matches = false
+				break
+			}
+
+			if !strings.Contains(name, word) {
+				nameMatch = false
+			}
+		}
+
+		if matches {
+			results = append(results, &result{entry: entry, nameMatch: nameMatch})
+		}
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].nameMatch != results[j].nameMatch {
+			return results[i].nameMatch
+		}
+
+		return results[i].entry.Name < results[j].entry.Name
+	})
+
+	entries := make([]*IndexEntry, len(results))
+	for indx, r := range results {
+		entries[indx] = r.entry
+	}
+
+	return entries
+}
+
+// ReadIndex reads the search index from output.
+func ReadIndex(ctx context.Context, output Output) (Index, error) {
+	index := make(Index)
+	if err := readJSON(ctx, output, IndexFile, &index); err != nil {
+		return nil, err
+	}
+
+	return index, nil
+}
+
+// usesIndex reports whether the config needs the index, for the search or the site.
+func usesIndex(conf *Config) bool {
+	return conf.Search != nil || conf.Site != nil
+}
+
+// writeIndex writes the index, and list.json if the search is static. When
+// etag is set, the index is only written if it hasn't changed since.
+func writeIndex(conf *Config, index Index, etag string) error {
+	contents, err := json.Marshal(index)
+	if err != nil {
+		return err
+	}
+
+	if err := WriteIfMatch(conf.Context(), conf.Output, IndexFile, contents, etag); err != nil {
+		return err
+	}
+
+	if conf.Search == nil || !conf.Search.Static {
+		return nil
+	}
+
+	list, err := json.Marshal(map[string][]string{"packageNames": index.Names("")})
+	if err != nil {
+		return err
+	}
+
+	return conf.Output.Write(conf.Context(), ListFile, list)
+}
+
+// updateIndex replaces the entries of pkgs in the index and deletes those
+// of removed, retrying if another writer changes the index in the meantime.
+func updateIndex(conf *Config, pkgs Packages, removed []string) (Index, error) {
+	for attempt := 1; ; attempt++ {
+		data, etag, err := GetWithETag(conf.Context(), conf.Output, IndexFile)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to read the search index, the repository may need to be generated again: %v", err)
+		}
+
+		index := make(Index)
+		if err := json.Unmarshal(data, &index); err != nil {
+			return nil, fmt.Errorf("Unable to read %q: %v", IndexFile, err)
+		}
+
+		for name, versions := range pkgs {
+			index[strings.ToLower(name)] = NewIndexEntry(name, versions)
+		}
+		for _, name := range removed {
+			delete(index, strings.ToLower(name))
+		}
+
+		err = writeIndex(conf, index, etag)
+		if err == nil {
+			return index, nil
+		} else if err != ErrConflict || attempt == maxUpdateAttempts {
+			return nil, err
+		}
+
+		conf.Log.Warn("The search index was modified, retrying", "file", IndexFile, "attempt", attempt+1, "maxAttempts", maxUpdateAttempts)
+	}
+}
+
+// Entries returns the entries of the index, sorted by name.
+func (index Index) Entries() []*IndexEntry {
+	entries := make([]*IndexEntry, 0, len(index))
+	for _, entry := range index {
+		entries = append(entries, entry)
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name < entries[j].Name
+	})
+
+	return entries
+}
+
+// searchMetadata sets the search and list URLs of packages.json.
+func searchMetadata(conf *Config, repo *Repository) {
+	repo.Search = ""
+	repo.List = ""
+
+	if conf.Search == nil {
+		return
+	}
+
+	if conf.Search.URL != "" {
+		base := strings.TrimSuffix(conf.Search.URL, "/")
+		repo.Search = base + "/search.json?q=%query%&type=%type%"
+		repo.List = base + "/" + ListFile
+	} else if conf.Search.Static {
+		repo.List = conf.Output.GetBasePath() + "/" + ListFile
+	}
+}
@@ -1,19 +1,34 @@
 package composer
 
 import (
+	"context"
 	"crypto/sha256"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"strings"
+	"time"
+
+	"github.com/zachomedia/composerrepo/pkg/auth"
+	"github.com/zachomedia/composerrepo/pkg/logging"
+	"github.com/zachomedia/composerrepo/pkg/stats"
+	"github.com/zachomedia/composerrepo/pkg/webhook"
 )
 
+// maxUpdateAttempts is how many times Update retries after losing a conflicting write.
+const maxUpdateAttempts = 5
+
+// ErrConflict is returned by a ConditionalOutput when the file was changed
+// by another writer since it was read.
+var ErrConflict = errors.New("File was modified by another writer")
+
 type Input interface {
 	Init(id string, conf map[string]interface{}) error
 
 	GetID() string
 	GetName() string
-	GetPackages() (Packages, error)
-	GetPackage(packageName string) (PackageVersions, error)
+	GetPackages(ctx context.Context) (Packages, error)
+	GetPackage(ctx context.Context, packageName string) (PackageVersions, error)
 }
 
 type Transformer interface {
@@ -21,7 +36,7 @@ type Transformer interface {
 
 	GetID() int
 
-	Transform(input Input, name string, pkg PackageVersions) error
+	Transform(ctx context.Context, input Input, name string, pkg PackageVersions) error
 }
 
 type Output interface {
@@ -29,15 +44,161 @@ type Output interface {
 
 	GetBasePath() string
 
-	Get(name string) ([]byte, error)
-	Write(name string, data []byte) error
+	Get(ctx context.Context, name string) ([]byte, error)
+	Write(ctx context.Context, name string, data []byte) error
+}
+
+// Logged is implemented by inputs, transformers and outputs which log.
+// SetLogger is called before Init, with a logger naming the component. The
+// methods given a context log with the logger of the job in it, through
+// logging.Logger.WithContext.
+type Logged interface {
+	SetLogger(log *logging.Logger)
+}
+
+// OutputFactory creates and initializes an output from its configuration.
+type OutputFactory func(conf map[string]interface{}) (Output, error)
+
+// OutputBuilder is implemented by inputs and outputs which create the
+// outputs they read from or write to, such as the multi output.
+// SetOutputFactory is called before Init.
+type OutputBuilder interface {
+	SetOutputFactory(factory OutputFactory)
+}
+
+// Opener is implemented by inputs and outputs which prepare their storage
+// before they're used, such as by removing files left behind by an
+// interrupted run. Open is called by the commands using the config, but not
+// when it's only validated.
+type Opener interface {
+	Open() error
+}
+
+// Checker is implemented by inputs and outputs which can check their
+// connectivity and credentials.
+type Checker interface {
+	Check() error
+}
+
+// Lister is implemented by outputs which can list the files they contain.
+type Lister interface {
+	// List returns the names of the files starting with prefix.
+	List(ctx context.Context, prefix string) ([]string, error)
+}
+
+// Watched is implemented by inputs whose packages can change outside of the
+// repository, such as files on disk.
+type Watched interface {
+	// Changed returns the names of the packages added or changed, and of
+	// those removed, since it was last called. The first call returns none.
+	Changed() (changed []string, removed []string, err error)
+}
+
+// Deleter is implemented by outputs which can delete files.
+type Deleter interface {
+	// Delete deletes a file. Deleting a file which doesn't exist succeeds.
+	Delete(ctx context.Context, name string) error
+}
+
+// DependentInput is implemented by inputs which read the packages of other
+// inputs. SetInputs is called once every input is initialized.
+type DependentInput interface {
+	SetInputs(inputs map[string]Input) error
+}
+
+// RepositoryTransformer is implemented by transformers which also change
+// packages.json, such as by adding mirrors.
+type RepositoryTransformer interface {
+	TransformRepository(repo *Repository) error
+}
+
+// Site generates a browsable site for the repository, written to the output
+// next to packages.json.
+type Site interface {
+	// WritePackage writes the page of a package.
+	WritePackage(ctx context.Context, output Output, name string, versions PackageVersions) error
+
+	// WriteIndex writes the page listing every package.
+	WriteIndex(ctx context.Context, output Output, index Index) error
+
+	// RemovePackage removes the page of a package.
+	RemovePackage(ctx context.Context, output Output, name string) error
+}
+
+// ConditionalOutput is implemented by outputs which can detect concurrent
+// writers, so that read-modify-write cycles don't silently drop changes.
+type ConditionalOutput interface {
+	Output
+
+	// GetWithETag returns the contents of the file and a tag identifying its current version.
+	GetWithETag(ctx context.Context, name string) ([]byte, string, error)
+
+	// WriteIfMatch writes the file only if its current version matches etag,
+	// otherwise it returns ErrConflict. An empty etag writes unconditionally.
+	WriteIfMatch(ctx context.Context, name string, data []byte, etag string) error
+}
+
+// GetWithETag reads a file from output, along with its ETag if the output supports it.
+func GetWithETag(ctx context.Context, output Output, name string) ([]byte, string, error) {
+	if co, ok := output.(ConditionalOutput); ok {
+		return co.GetWithETag(ctx, name)
+	}
+
+	data, err := output.Get(ctx, name)
+	return data, "", err
+}
+
+// WriteIfMatch writes a file to output if it hasn't changed since etag was read.
+// Outputs that don't support conditional writes are written unconditionally.
+func WriteIfMatch(ctx context.Context, output Output, name string, data []byte, etag string) error {
+	if co, ok := output.(ConditionalOutput); ok {
+		return co.WriteIfMatch(ctx, name, data, etag)
+	}
+
+	return output.Write(ctx, name, data)
 }
 
 type Config struct {
+	// Log is the logger of the job using the config, such as a generation
+	// or an update, with its correlation ID.
+	Log *logging.Logger
+
 	UseProviders bool
 	Inputs       map[string]Input
 	Transformers []Transformer
 	Output       Output
+
+	// CheckDependencies, if set, checks the requirements of the packages
+	// once the repository is generated.
+	CheckDependencies *DependencyCheck
+
+	// SecurityAdvisories, if set, are served to `composer audit`.
+	SecurityAdvisories *AdvisoryDatabase
+
+	// Search, if set, maintains the index behind search.json and list.json.
+	Search *Search
+
+	// Site, if set, generates a browsable site from the index.
+	Site Site
+
+	// Stats, if set, records the downloads of each package.
+	Stats *DownloadStats
+
+	// Signing, if set, signs packages.json and the provider files.
+	Signing *Signing
+
+	// Webhooks receive push events from Git hosts in `repo serve`.
+	Webhooks []*webhook.Hook
+
+	// Auth, if set, protects the endpoints of `repo serve` which change
+	// the repository.
+	Auth *auth.Auth
+}
+
+// Context returns a context carrying Log, which the inputs, transformers and
+// outputs of the config log with while they work for the job.
+func (conf *Config) Context() context.Context {
+	return logging.NewContext(context.Background(), conf.Log)
 }
 
 type Reference struct {
@@ -51,6 +212,41 @@ type Repository struct {
 	Providers        map[string]*Reference `json:"providers,omitempty"`
 	ProviderIncludes map[string]*Reference `json:"provider-includes,omitempty"`
 	ProvidersURL     string                `json:"providers-url,omitempty"`
+	Mirrors          []*Mirror             `json:"mirrors,omitempty"`
+
+	SecurityAdvisories *SecurityAdvisories `json:"security-advisories,omitempty"`
+	Search             string              `json:"search,omitempty"`
+	List               string              `json:"list,omitempty"`
+	NotifyBatch        string              `json:"notify-batch,omitempty"`
+}
+
+// DownloadStats configures recording the downloads Composer reports.
+type DownloadStats struct {
+	// URL is where `repo serve` answers notify-batch and the stats API.
+	URL string
+
+	// File is where the counts are stored, and Store the counts loaded
+	// from it when the config is opened.
+	File  string
+	Store *stats.Store
+
+	// FlushInterval is how often the recorded downloads are saved, and the
+	// pages showing them written again.
+	FlushInterval time.Duration
+}
+
+// NotifyBatchURL returns the URL Composer reports downloads to.
+func (ds *DownloadStats) NotifyBatchURL() string {
+	return strings.TrimSuffix(ds.URL, "/") + "/downloads/"
+}
+
+// Mirror is an alternative location Composer downloads packages from.
+// URLs may contain the %package%, %version%, %reference% and %type% placeholders.
+type Mirror struct {
+	DistURL   string `json:"dist-url,omitempty"`
+	GitURL    string `json:"git-url,omitempty"`
+	HgURL     string `json:"hg-url,omitempty"`
+	Preferred bool   `json:"preferred,omitempty"`
 }
 
 type PackageInfo struct {
@@ -58,6 +254,35 @@ type PackageInfo struct {
 	PackageName string
 }
 
+// transformRepository lets transformers change packages.json. Mirrors and
+// the URLs of the APIs are always rebuilt from the config, so removed
+// entries don't linger.
+func transformRepository(conf *Config, repo *Repository) error {
+	repo.Mirrors = nil
+
+	repo.SecurityAdvisories = nil
+	if conf.SecurityAdvisories != nil {
+		repo.SecurityAdvisories = conf.SecurityAdvisories.Metadata()
+	}
+
+	searchMetadata(conf, repo)
+
+	repo.NotifyBatch = ""
+	if conf.Stats != nil {
+		repo.NotifyBatch = conf.Stats.NotifyBatchURL()
+	}
+
+	for _, transformer := range conf.Transformers {
+		if rt, ok := transformer.(RepositoryTransformer); ok {
+			if err := rt.TransformRepository(repo); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
 func generateContentsAndHash(obj interface{}) ([]byte, string, error) {
 	b, err := json.Marshal(obj)
 	if err != nil {
@@ -67,8 +292,79 @@ func generateContentsAndHash(obj interface{}) ([]byte, string, error) {
 	return b, fmt.Sprintf("%x", sha256.Sum256(b)), nil
 }
 
+// transformedPackages returns every package of input, after the transformers
+// are applied.
+func transformedPackages(conf *Config, input Input) (Packages, error) {
+	pkgs, err := input.GetPackages(conf.Context())
+	if err != nil {
+		return nil, err
+	}
+
+	for name, versions := range pkgs {
+		// Allow transformers to modify the package
+		for _, transformer := range conf.Transformers {
+			if err := transformer.Transform(conf.Context(), input, name, versions); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return pkgs, nil
+}
+
+// writeProvider writes the file of each package in pkgs and the provider
+// file of the input listing them. It returns the provider-includes entry of
+// the provider.
+func writeProvider(conf *Config, inputID string, pkgs Packages) (string, *Reference, error) {
+	provider := &Repository{
+		Providers: make(map[string]*Reference),
+	}
+
+	for name, versions := range pkgs {
+		// Add a unique ID to all versions
+		for _, version := range versions {
+			version.UID = fmt.Sprintf("%s@%s", version.Name, version.Version)
+		}
+
+		contents, hash, err := generateContentsAndHash(&Repository{
+			Packages: Packages{
+				name: versions,
+			},
+		})
+		if err != nil {
+			return "", nil, err
+		}
+
+		err = writeSigned(conf, fmt.Sprintf("p/%s$%s.json", name, hash), contents)
+		if err != nil {
+			return "", nil, err
+		}
+
+		provider.Providers[name] = &Reference{
+			SHA256: hash,
+		}
+	}
+
+	providerPath := fmt.Sprintf("p/provider-%s$%%hash%%.json", inputID)
+
+	contents, hash, err := generateContentsAndHash(provider)
+	if err != nil {
+		return "", nil, err
+	}
+
+	err = writeSigned(conf, strings.Replace(providerPath, "%hash%", hash, -1), contents)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return providerPath, &Reference{SHA256: hash}, nil
+}
+
 // Generate generates the repository.
 func Generate(conf *Config) error {
+	start := time.Now()
+	conf.Log.Info("Generating the repository")
+
 	repo := &Repository{}
 
 	if conf.UseProviders {
@@ -78,70 +374,279 @@ func Generate(conf *Config) error {
 		repo.Packages = make(Packages)
 	}
 
+	generated := make(Packages)
+
 	// If UseProviders is false, save packages directly to packages.json
 	for _, connector := range conf.Inputs {
-		provider := &Repository{
-			Providers: make(map[string]*Reference),
-		}
+		conf.Log.Info("Loading the packages of input", "input", connector.GetID())
 
-		pkgs, err := connector.GetPackages()
+		pkgs, err := transformedPackages(conf, connector)
 		if err != nil {
 			return err
 		}
 
 		for name, versions := range pkgs {
-			// Allow transformers to modify the package
-			for _, transformer := range conf.Transformers {
-				if err := transformer.Transform(connector, name, versions); err != nil {
-					return err
-				}
+			generated[name] = versions
+		}
+
+		if conf.UseProviders {
+			providerPath, ref, err := writeProvider(conf, connector.GetID(), pkgs)
+			if err != nil {
+				return err
 			}
 
-			if conf.UseProviders {
-				// Add a unique ID to all versions
-				for _, version := range versions {
-					version.UID = fmt.Sprintf("%s@%s", version.Name, version.Version)
-				}
+			repo.ProviderIncludes[providerPath] = ref
+		} else {
+			for name, versions := range pkgs {
+				repo.Packages[name] = versions
+			}
+		}
+	}
 
-				contents, hash, err := generateContentsAndHash(&Repository{
-					Packages: Packages{
-						name: versions,
-					},
-				})
-				if err != nil {
-					return err
-				}
+	if usesIndex(conf) {
+		index := make(Index, len(generated))
+		for name, versions := range generated {
+			index[strings.ToLower(name)] = NewIndexEntry(name, versions)
+		}
 
-				err = conf.Output.Write(fmt.Sprintf("p/%s$%s.json", name, hash), contents)
-				if err != nil {
-					return err
-				}
+		if err := writeIndex(conf, index, ""); err != nil {
+			return err
+		}
 
-				provider.Providers[name] = &Reference{
-					SHA256: hash,
-				}
-			} else {
-				repo.Packages[name] = versions
+		if conf.Site != nil {
+			if err := writeSite(conf, generated, index); err != nil {
+				return err
 			}
 		}
+	}
 
-		if conf.UseProviders {
-			providerPath := fmt.Sprintf("p/provider-%s$%%hash%%.json", connector.GetID())
+	if err := transformRepository(conf, repo); err != nil {
+		return err
+	}
 
-			contents, hash, err := generateContentsAndHash(provider)
-			if err != nil {
+	// packages.json is written last, once every file it references is in place.
+	contents, _, err := generateContentsAndHash(repo)
+	if err != nil {
+		return err
+	}
+
+	for attempt := 1; ; attempt++ {
+		if err := conf.Output.Write(conf.Context(), "packages.json", contents); err != nil {
+			return err
+		}
+
+		err := signRepository(conf, contents)
+		if err == nil {
+			break
+		} else if err != ErrConflict || attempt == maxUpdateAttempts {
+			return err
+		}
+
+		conf.Log.Warn("packages.json was modified while it was signed, writing it again", "attempt", attempt+1, "maxAttempts", maxUpdateAttempts)
+	}
+
+	conf.Log.Info("Generated the repository", "packages", len(generated), "duration", time.Since(start))
+
+	if conf.CheckDependencies != nil {
+		return checkGenerated(conf.Log, generated, conf.CheckDependencies)
+	}
+
+	return nil
+}
+
+// checkGenerated logs the unsatisfied requirements of pkgs, and fails if
+// check requires it.
+func checkGenerated(log *logging.Logger, pkgs Packages, check *DependencyCheck) error {
+	unsatisfied := CheckDependencies(pkgs, check)
+	for _, dep := range unsatisfied {
+		log.Warn("Unsatisfied requirement", "requirement", dep)
+	}
+
+	if check.Fail && len(unsatisfied) > 0 {
+		return fmt.Errorf("%d requirements can't be satisfied", len(unsatisfied))
+	}
+
+	return nil
+}
+
+// Update updates packages in the repository.
+//
+// packages.json is rewritten with a conditional write. If another writer
+// changed it in the meantime, the update is retried against the new contents.
+func Update(conf *Config, packageInfos []*PackageInfo) error {
+	pkgs := make([]PackageVersions, len(packageInfos))
+
+	for indx, packageInfo := range packageInfos {
+		input, ok := conf.Inputs[packageInfo.InputID]
+		if !ok {
+			return fmt.Errorf("No input matching %q", packageInfo.InputID)
+		}
+
+		conf.Log.Info("Updating package", "input", packageInfo.InputID, "package", packageInfo.PackageName)
+
+		pkg, err := input.GetPackage(conf.Context(), packageInfo.PackageName)
+		if err != nil {
+			return err
+		}
+
+		// Allow transformers to modify the package
+		for _, transformer := range conf.Transformers {
+			if err := transformer.Transform(conf.Context(), input, packageInfo.PackageName, pkg); err != nil {
 				return err
 			}
+		}
+
+		pkgs[indx] = pkg
+	}
+
+	for attempt := 1; ; attempt++ {
+		err := updateRepository(conf, packageInfos, pkgs)
+		if err == nil {
+			break
+		} else if err != ErrConflict || attempt == maxUpdateAttempts {
+			return err
+		}
+
+		conf.Log.Warn("packages.json was modified during update, retrying", "attempt", attempt+1, "maxAttempts", maxUpdateAttempts)
+	}
+
+	if !usesIndex(conf) {
+		return nil
+	}
+
+	updated := make(Packages, len(packageInfos))
+	for indx, packageInfo := range packageInfos {
+		updated[packageInfo.PackageName] = pkgs[indx]
+	}
+
+	index, err := updateIndex(conf, updated, nil)
+	if err != nil {
+		return err
+	}
+
+	// Only the pages of the updated packages, and the list of packages, change
+	if conf.Site != nil {
+		return writeSite(conf, updated, index)
+	}
+
+	return nil
+}
+
+// Remove removes packages from the repository, such as when their project
+// is deleted. Like Update, packages.json is rewritten with a conditional write.
+func Remove(conf *Config, packageInfos []*PackageInfo) error {
+	for _, packageInfo := range packageInfos {
+		if _, ok := conf.Inputs[packageInfo.InputID]; !ok {
+			return fmt.Errorf("No input matching %q", packageInfo.InputID)
+		}
+
+		conf.Log.Info("Removing package", "input", packageInfo.InputID, "package", packageInfo.PackageName)
+	}
+
+	for attempt := 1; ; attempt++ {
+		err := removeFromRepository(conf, packageInfos)
+		if err == nil {
+			break
+		} else if err != ErrConflict || attempt == maxUpdateAttempts {
+			return err
+		}
+
+		conf.Log.Warn("packages.json was modified during removal, retrying", "attempt", attempt+1, "maxAttempts", maxUpdateAttempts)
+	}
+
+	if !usesIndex(conf) {
+		return nil
+	}
+
+	removed := make([]string, len(packageInfos))
+	for indx, packageInfo := range packageInfos {
+		removed[indx] = packageInfo.PackageName
+	}
+
+	index, err := updateIndex(conf, nil, removed)
+	if err != nil {
+		return err
+	}
+
+	if conf.Site == nil {
+		return nil
+	}
+
+	for _, name := range removed {
+		if err := conf.Site.RemovePackage(conf.Context(), conf.Output, name); err != nil {
+			return err
+		}
+	}
+
+	return conf.Site.WriteIndex(conf.Context(), conf.Output, index)
+}
+
+// removeFromRepository removes packages from the current repository. The
+// files of the removed packages are left in place, since they are named
+// after their hash and clients may still have a provider referencing them.
+func removeFromRepository(conf *Config, packageInfos []*PackageInfo) error {
+	repo := Repository{}
 
-			err = conf.Output.Write(strings.Replace(providerPath, "%hash%", hash, -1), contents)
+	repoData, etag, err := GetWithETag(conf.Context(), conf.Output, "packages.json")
+	if err != nil {
+		return err
+	}
+
+	if err := json.Unmarshal(repoData, &repo); err != nil {
+		return err
+	}
+
+	providers := make(map[string]*Repository)
+
+	for _, packageInfo := range packageInfos {
+		if !conf.UseProviders {
+			delete(repo.Packages, packageInfo.PackageName)
+			continue
+		}
+
+		if _, ok := providers[packageInfo.InputID]; !ok {
+			providerID := fmt.Sprintf("p/provider-%s$%%hash%%.json", packageInfo.InputID)
+			providerInfo, ok := repo.ProviderIncludes[providerID]
+			if !ok {
+				return fmt.Errorf("No input matching %q", packageInfo.InputID)
+			}
+
+			provider := &Repository{}
+			providerData, err := conf.Output.Get(conf.Context(), strings.Replace(providerID, "%hash%", providerInfo.SHA256, -1))
 			if err != nil {
 				return err
 			}
 
-			repo.ProviderIncludes[providerPath] = &Reference{
-				SHA256: hash,
+			if err := json.Unmarshal(providerData, provider); err != nil {
+				return err
 			}
+
+			providers[packageInfo.InputID] = provider
 		}
+
+		delete(providers[packageInfo.InputID].Providers, packageInfo.PackageName)
+	}
+
+	for providerID, provider := range providers {
+		providerPath := fmt.Sprintf("p/provider-%s$%%hash%%.json", providerID)
+
+		contents, hash, err := generateContentsAndHash(provider)
+		if err != nil {
+			return err
+		}
+
+		err = writeSigned(conf, strings.Replace(providerPath, "%hash%", hash, -1), contents)
+		if err != nil {
+			return err
+		}
+
+		repo.ProviderIncludes[providerPath] = &Reference{
+			SHA256: hash,
+		}
+	}
+
+	if err := transformRepository(conf, &repo); err != nil {
+		return err
 	}
 
 	contents, _, err := generateContentsAndHash(repo)
@@ -149,15 +654,51 @@ func Generate(conf *Config) error {
 		return err
 	}
 
-	return conf.Output.Write("packages.json", contents)
+	if err := WriteIfMatch(conf.Context(), conf.Output, "packages.json", contents, etag); err != nil {
+		return err
+	}
+
+	return signRepository(conf, contents)
 }
 
-// Update updates packages in the repository.
-func Update(conf *Config, packageInfos []*PackageInfo) error {
+// writeSite writes the pages of pkgs, and the page listing every package in index.
+func writeSite(conf *Config, pkgs Packages, index Index) error {
+	for _, name := range sortedPackageNames(pkgs) {
+		if err := conf.Site.WritePackage(conf.Context(), conf.Output, name, pkgs[name]); err != nil {
+			return err
+		}
+	}
+
+	return conf.Site.WriteIndex(conf.Context(), conf.Output, index)
+}
+
+// RefreshSite writes the pages of the named packages which are in the
+// repository again, and the page listing every package, such as when their
+// download counts change.
+func RefreshSite(conf *Config, names []string) error {
+	if conf.Site == nil {
+		return nil
+	}
+
+	index, err := ReadIndex(conf.Context(), conf.Output)
+	if err != nil {
+		return err
+	}
+
+	pkgs, err := readPackages(conf.Context(), conf.Output, names)
+	if err != nil {
+		return err
+	}
+
+	return writeSite(conf, pkgs, index)
+}
+
+// updateRepository writes pkgs into the current repository.
+func updateRepository(conf *Config, packageInfos []*PackageInfo, pkgs []PackageVersions) error {
 	repo := Repository{}
 
 	// Read the current repository
-	repoData, err := conf.Output.Get("packages.json")
+	repoData, etag, err := GetWithETag(conf.Context(), conf.Output, "packages.json")
 	if err != nil {
 		return err
 	}
@@ -169,21 +710,11 @@ func Update(conf *Config, packageInfos []*PackageInfo) error {
 
 	providers := make(map[string]*Repository)
 
-	for _, packageInfo := range packageInfos {
-		pkg, err := conf.Inputs[packageInfo.InputID].GetPackage(packageInfo.PackageName)
-		if err != nil {
-			return err
-		}
-
-		// Allow transformers to modify the package
-		for _, transformer := range conf.Transformers {
-			if err := transformer.Transform(conf.Inputs[packageInfo.InputID], packageInfo.PackageName, pkg); err != nil {
-				return err
-			}
-		}
+	for indx, packageInfo := range packageInfos {
+		pkg := pkgs[indx]
 
 		if conf.UseProviders {
-			if _, ok := repo.ProviderIncludes[packageInfo.InputID]; !ok {
+			if _, ok := providers[packageInfo.InputID]; !ok {
 				// Find the provider
 				providerID := fmt.Sprintf("p/provider-%s$%%hash%%.json", packageInfo.InputID)
 				providerInfo, ok := repo.ProviderIncludes[providerID]
@@ -192,7 +723,7 @@ func Update(conf *Config, packageInfos []*PackageInfo) error {
 				}
 
 				provider := &Repository{}
-				providerData, err := conf.Output.Get(strings.Replace(providerID, "%hash%", providerInfo.SHA256, -1))
+				providerData, err := conf.Output.Get(conf.Context(), strings.Replace(providerID, "%hash%", providerInfo.SHA256, -1))
 				if err != nil {
 					return err
 				}
@@ -219,7 +750,7 @@ func Update(conf *Config, packageInfos []*PackageInfo) error {
 				return err
 			}
 
-			err = conf.Output.Write(fmt.Sprintf("p/%s$%s.json", packageInfo.PackageName, hash), contents)
+			err = writeSigned(conf, fmt.Sprintf("p/%s$%s.json", packageInfo.PackageName, hash), contents)
 			if err != nil {
 				return err
 			}
@@ -242,7 +773,7 @@ func Update(conf *Config, packageInfos []*PackageInfo) error {
 				return err
 			}
 
-			err = conf.Output.Write(strings.Replace(providerPath, "%hash%", hash, -1), contents)
+			err = writeSigned(conf, strings.Replace(providerPath, "%hash%", hash, -1), contents)
 			if err != nil {
 				return err
 			}
@@ -253,10 +784,18 @@ func Update(conf *Config, packageInfos []*PackageInfo) error {
 		}
 	}
 
+	if err := transformRepository(conf, &repo); err != nil {
+		return err
+	}
+
 	contents, _, err := generateContentsAndHash(repo)
 	if err != nil {
 		return err
 	}
 
-	return conf.Output.Write("packages.json", contents)
+	if err := WriteIfMatch(conf.Context(), conf.Output, "packages.json", contents, etag); err != nil {
+		return err
+	}
+
+	return signRepository(conf, contents)
 }
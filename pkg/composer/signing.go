@@ -0,0 +1,194 @@
+package composer
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+)
+
+// SignatureSuffix is appended to the name of a file to get the name of its
+// detached signature, such as packages.json.sig.
+const SignatureSuffix = ".sig"
+
+// SigningKey is an ed25519 key metadata is signed with.
+type SigningKey struct {
+	ID         string
+	PrivateKey ed25519.PrivateKey
+}
+
+// TrustedKey is an ed25519 public key whose signatures are accepted.
+type TrustedKey struct {
+	ID        string
+	PublicKey ed25519.PublicKey
+}
+
+// Signing signs packages.json and the provider files with every key in
+// Keys. Signatures by any of Keys or TrustedKeys are accepted, so keys can
+// be rotated by signing with the old and new keys until the repository is
+// regenerated, then keeping the old key in TrustedKeys until it is retired.
+type Signing struct {
+	Keys        []*SigningKey
+	TrustedKeys []*TrustedKey
+}
+
+// Signature is a signature of a file by one key.
+type Signature struct {
+	KeyID string `json:"keyid"`
+	Sig   string `json:"sig"`
+}
+
+// SignatureFile is the contents of a detached signature.
+type SignatureFile struct {
+	Signatures []*Signature `json:"signatures"`
+}
+
+// ParsePrivateKey parses a base64 encoded ed25519 private key or seed.
+func ParsePrivateKey(s string) (ed25519.PrivateKey, error) {
+	b, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("expected a base64 encoded key: %v", err)
+	}
+
+	switch len(b) {
+	case ed25519.SeedSize:
+		return ed25519.NewKeyFromSeed(b), nil
+	case ed25519.PrivateKeySize:
+		return ed25519.PrivateKey(b), nil
+	}
+
+	return nil, fmt.Errorf("expected a key of %d or %d bytes, got %d", ed25519.SeedSize, ed25519.PrivateKeySize, len(b))
+}
+
+// ParsePublicKey parses a base64 encoded ed25519 public key.
+func ParsePublicKey(s string) (ed25519.PublicKey, error) {
+	b, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("expected a base64 encoded key: %v", err)
+	}
+
+	if len(b) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("expected a key of %d bytes, got %d", ed25519.PublicKeySize, len(b))
+	}
+
+	return ed25519.PublicKey(b), nil
+}
+
+// Sign returns the detached signature of data by each signing key.
+func (s *Signing) Sign(data []byte) ([]byte, error) {
+	file := &SignatureFile{Signatures: make([]*Signature, 0, len(s.Keys))}
+	for _, key := range s.Keys {
+		file.Signatures = append(file.Signatures, &Signature{
+			KeyID: key.ID,
+			Sig:   base64.StdEncoding.EncodeToString(ed25519.Sign(key.PrivateKey, data)),
+		})
+	}
+
+	return json.Marshal(file)
+}
+
+// trustedKeys returns the public keys whose signatures are accepted, by ID.
+func (s *Signing) trustedKeys() map[string]ed25519.PublicKey {
+	keys := make(map[string]ed25519.PublicKey, len(s.Keys)+len(s.TrustedKeys))
+	for _, key := range s.TrustedKeys {
+		keys[key.ID] = key.PublicKey
+	}
+	for _, key := range s.Keys {
+		keys[key.ID] = key.PrivateKey.Public().(ed25519.PublicKey)
+	}
+
+	return keys
+}
+
+// Verify checks that sig contains a valid signature of data by a trusted key.
+// Signatures by unknown keys are ignored, but an invalid signature by a
+// trusted key means the file was changed and fails the check.
+func (s *Signing) Verify(data []byte, sig []byte) error {
+	file := &SignatureFile{}
+	if err := json.Unmarshal(sig, file); err != nil {
+		return fmt.Errorf("Unable to read the signature: %v", err)
+	}
+
+	keys := s.trustedKeys()
+	valid := false
+
+	for _, signature := range file.Signatures {
+		key, ok := keys[signature.KeyID]
+		if !ok {
+			continue
+		}
+
+		b, err := base64.StdEncoding.DecodeString(signature.Sig)
+		if err != nil || !ed25519.Verify(key, data, b) {
+			return fmt.Errorf("Invalid signature by key %q", signature.KeyID)
+		}
+
+		valid = true
+	}
+
+	if !valid {
+		return fmt.Errorf("Not signed by a trusted key")
+	}
+
+	return nil
+}
+
+// writeSignature writes the detached signature of a file, if signing is configured.
+func writeSignature(conf *Config, name string, data []byte) error {
+	if conf.Signing == nil {
+		return nil
+	}
+
+	sig, err := conf.Signing.Sign(data)
+	if err != nil {
+		return err
+	}
+
+	return conf.Output.Write(conf.Context(), name+SignatureSuffix, sig)
+}
+
+// writeSigned writes a file followed by its signature.
+func writeSigned(conf *Config, name string, data []byte) error {
+	if err := conf.Output.Write(conf.Context(), name, data); err != nil {
+		return err
+	}
+
+	return writeSignature(conf, name, data)
+}
+
+// signRepository writes the signature of packages.json, once it has been
+// written with data. Another writer may have replaced packages.json, with
+// its signature written before this one, so packages.json is read again
+// afterwards, and ErrConflict returned if it changed. Only data this process
+// generated is signed, so the caller writes and signs packages.json again.
+func signRepository(conf *Config, data []byte) error {
+	if conf.Signing == nil {
+		return nil
+	}
+
+	if err := writeSignature(conf, "packages.json", data); err != nil {
+		return err
+	}
+
+	current, err := conf.Output.Get(conf.Context(), "packages.json")
+	if err != nil {
+		return err
+	}
+
+	if !bytes.Equal(current, data) {
+		return ErrConflict
+	}
+
+	return nil
+}
+
+// verifySignature checks the detached signature of a file.
+func verifySignature(conf *Config, name string, data []byte) error {
+	sig, err := conf.Output.Get(conf.Context(), name+SignatureSuffix)
+	if err != nil {
+		return fmt.Errorf("Unable to read the signature: %v", err)
+	}
+
+	return conf.Signing.Verify(data, sig)
+}
@@ -0,0 +1,372 @@
+package composer
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/zachomedia/composerrepo/pkg/logging"
+)
+
+// memoryOutput keeps the files of a repository in memory. beforeWrite, if
+// set, is called before each write, such as to simulate another writer.
+type memoryOutput struct {
+	files       map[string][]byte
+	beforeWrite func(name string, data []byte)
+}
+
+func newMemoryOutput() *memoryOutput {
+	return &memoryOutput{files: make(map[string][]byte)}
+}
+
+func (mo *memoryOutput) Init(conf map[string]interface{}) error {
+	return nil
+}
+
+func (mo *memoryOutput) GetBasePath() string {
+	return "https://repo.example.com"
+}
+
+func (mo *memoryOutput) Get(ctx context.Context, name string) ([]byte, error) {
+	data, ok := mo.files[name]
+	if !ok {
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+	}
+
+	return data, nil
+}
+
+func (mo *memoryOutput) Write(ctx context.Context, name string, data []byte) error {
+	if mo.beforeWrite != nil {
+		mo.beforeWrite(name, data)
+	}
+
+	mo.files[name] = data
+	return nil
+}
+
+// names returns the names of the files starting with prefix, in order.
+func (mo *memoryOutput) names(prefix string) []string {
+	names := make([]string, 0)
+	for name := range mo.files {
+		if strings.HasPrefix(name, prefix) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// memoryInput is an input with fixed packages.
+type memoryInput struct {
+	id       string
+	packages Packages
+}
+
+func (mi *memoryInput) Init(id string, conf map[string]interface{}) error {
+	mi.id = id
+	return nil
+}
+
+func (mi *memoryInput) GetID() string {
+	return mi.id
+}
+
+func (mi *memoryInput) GetName() string {
+	return mi.id
+}
+
+func (mi *memoryInput) GetPackages(ctx context.Context) (Packages, error) {
+	pkgs := make(Packages, len(mi.packages))
+	for name := range mi.packages {
+		versions, err := mi.GetPackage(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		pkgs[name] = versions
+	}
+
+	return pkgs, nil
+}
+
+// GetPackage returns copies of the versions, since they are modified while
+// the repository is written.
+func (mi *memoryInput) GetPackage(ctx context.Context, packageName string) (PackageVersions, error) {
+	versions, ok := mi.packages[packageName]
+	if !ok {
+		return nil, fmt.Errorf("No package %q", packageName)
+	}
+
+	copied := make(PackageVersions, len(versions))
+	for version, pkg := range versions {
+		pkgCopy := *pkg
+		copied[version] = &pkgCopy
+	}
+
+	return copied, nil
+}
+
+func newSigningKey(t *testing.T, id string) *SigningKey {
+	_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &SigningKey{ID: id, PrivateKey: privateKey}
+}
+
+func trusted(key *SigningKey) *TrustedKey {
+	return &TrustedKey{ID: key.ID, PublicKey: key.PrivateKey.Public().(ed25519.PublicKey)}
+}
+
+// newTestConfig returns the config of a signed repository with providers,
+// generated from one input.
+func newTestConfig(t *testing.T, key *SigningKey) *Config {
+	log, err := logging.New(ioutil.Discard, logging.LevelError, logging.FormatLogfmt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	input := &memoryInput{id: "acme", packages: Packages{
+		"acme/widget": PackageVersions{
+			"1.0.0": {Name: "acme/widget", Version: "1.0.0"},
+			"1.1.0": {Name: "acme/widget", Version: "1.1.0"},
+		},
+		"acme/gadget": PackageVersions{
+			"2.0.0": {Name: "acme/gadget", Version: "2.0.0"},
+		},
+	}}
+
+	return &Config{
+		Log:          log,
+		UseProviders: true,
+		Inputs:       map[string]Input{"acme": input},
+		Output:       newMemoryOutput(),
+		Signing:      &Signing{Keys: []*SigningKey{key}},
+	}
+}
+
+func TestParseKeys(t *testing.T) {
+	key := newSigningKey(t, "a")
+	seed := base64.StdEncoding.EncodeToString(key.PrivateKey.Seed())
+	full := base64.StdEncoding.EncodeToString(key.PrivateKey)
+	public := base64.StdEncoding.EncodeToString(key.PrivateKey.Public().(ed25519.PublicKey))
+
+	for _, s := range []string{seed, full} {
+		privateKey, err := ParsePrivateKey(s)
+		if err != nil {
+			t.Errorf("ParsePrivateKey(%q) returned error: %v", s, err)
+		} else if !bytes.Equal(privateKey, key.PrivateKey) {
+			t.Errorf("ParsePrivateKey(%q) returned another key", s)
+		}
+	}
+
+	if publicKey, err := ParsePublicKey(public); err != nil {
+		t.Errorf("ParsePublicKey returned error: %v", err)
+	} else if !bytes.Equal(publicKey, key.PrivateKey.Public().(ed25519.PublicKey)) {
+		t.Errorf("ParsePublicKey returned another key")
+	}
+
+	short := base64.StdEncoding.EncodeToString([]byte("too short"))
+
+	for _, s := range []string{"not base64!", short} {
+		if _, err := ParsePrivateKey(s); err == nil {
+			t.Errorf("ParsePrivateKey(%q) returned no error", s)
+		}
+	}
+
+	for _, s := range []string{"not base64!", short, full} {
+		if _, err := ParsePublicKey(s); err == nil {
+			t.Errorf("ParsePublicKey(%q) returned no error", s)
+		}
+	}
+}
+
+func TestVerify(t *testing.T) {
+	current := newSigningKey(t, "current")
+	old := newSigningKey(t, "old")
+	unknown := newSigningKey(t, "unknown")
+	data := []byte(`{"packages":[]}`)
+
+	sign := func(keys ...*SigningKey) []byte {
+		sig, err := (&Signing{Keys: keys}).Sign(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return sig
+	}
+
+	// forged claims to be signed by current, but is signed by unknown
+	forged := sign(&SigningKey{ID: current.ID, PrivateKey: unknown.PrivateKey})
+
+	// A forged signature fails the check even next to a valid one
+	both := &SignatureFile{}
+	forgedFile := &SignatureFile{}
+	if err := json.Unmarshal(sign(old), both); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(forged, forgedFile); err != nil {
+		t.Fatal(err)
+	}
+	both.Signatures = append(both.Signatures, forgedFile.Signatures...)
+	forgedNextToValid, err := json.Marshal(both)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signing := &Signing{
+		Keys:        []*SigningKey{current},
+		TrustedKeys: []*TrustedKey{trusted(old)},
+	}
+	verifyOnly := &Signing{TrustedKeys: []*TrustedKey{trusted(current)}}
+
+	tests := []struct {
+		name    string
+		signing *Signing
+		data    []byte
+		sig     []byte
+		err     string
+	}{
+		{"signing key", signing, data, sign(current), ""},
+		{"trusted key", signing, data, sign(old), ""},
+		{"trusted key only", verifyOnly, data, sign(current), ""},
+		{"unknown key ignored", signing, data, sign(unknown, current), ""},
+		{"unknown key", signing, data, sign(unknown), "Not signed by a trusted key"},
+		{"no signatures", signing, data, []byte(`{"signatures":[]}`), "Not signed by a trusted key"},
+		{"forged signature", signing, data, forged, `Invalid signature by key "current"`},
+		{"forged next to valid", signing, data, forgedNextToValid, `Invalid signature by key "current"`},
+		{"changed data", signing, []byte(`{"packages":{}}`), sign(current), `Invalid signature by key "current"`},
+		{"invalid base64", signing, data, []byte(`{"signatures":[{"keyid":"current","sig":"!"}]}`), `Invalid signature by key "current"`},
+		{"invalid JSON", signing, data, []byte(`signature`), "Unable to read the signature"},
+	}
+
+	for _, test := range tests {
+		err := test.signing.Verify(test.data, test.sig)
+		if test.err == "" {
+			if err != nil {
+				t.Errorf("%s: Verify returned error: %v", test.name, err)
+			}
+			continue
+		}
+
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: Verify = %v, want error containing %q", test.name, err, test.err)
+		}
+	}
+}
+
+func TestGenerateSigns(t *testing.T) {
+	key := newSigningKey(t, "a")
+	conf := newTestConfig(t, key)
+	output := conf.Output.(*memoryOutput)
+
+	if err := Generate(conf); err != nil {
+		t.Fatalf("Generate returned error: %v", err)
+	}
+
+	files := append(output.names("p/"), "packages.json")
+	for _, name := range files {
+		if strings.HasSuffix(name, SignatureSuffix) {
+			continue
+		}
+
+		sig, ok := output.files[name+SignatureSuffix]
+		if !ok {
+			t.Errorf("%s isn't signed", name)
+			continue
+		}
+
+		if err := conf.Signing.Verify(output.files[name], sig); err != nil {
+			t.Errorf("%s: Verify returned error: %v", name, err)
+		}
+	}
+
+	// 2 packages and a provider, with their signatures
+	if got := len(output.names("p/")); got != 6 {
+		t.Errorf("Generate wrote %d files under p/, want 6: %v", got, output.names("p/"))
+	}
+}
+
+func TestSignRepositoryConflict(t *testing.T) {
+	key := newSigningKey(t, "a")
+	conf := newTestConfig(t, key)
+	output := conf.Output.(*memoryOutput)
+
+	output.files["packages.json"] = []byte(`{"packages":{}}`)
+
+	// Another writer replaces packages.json while it is signed
+	output.beforeWrite = func(name string, data []byte) {
+		if name == "packages.json"+SignatureSuffix {
+			output.files["packages.json"] = []byte(`{"packages":{"other":{}}}`)
+		}
+	}
+
+	if err := signRepository(conf, []byte(`{"packages":{}}`)); err != ErrConflict {
+		t.Errorf("signRepository = %v, want ErrConflict", err)
+	}
+
+	// Only the contents it was given are signed
+	if err := conf.Signing.Verify([]byte(`{"packages":{}}`), output.files["packages.json"+SignatureSuffix]); err != nil {
+		t.Errorf("Verify returned error: %v", err)
+	}
+}
+
+func TestGenerateWritesAgainOnConflict(t *testing.T) {
+	key := newSigningKey(t, "a")
+	conf := newTestConfig(t, key)
+	output := conf.Output.(*memoryOutput)
+
+	// Another writer replaces packages.json while the first signature is written
+	conflicts := 0
+	output.beforeWrite = func(name string, data []byte) {
+		if name == "packages.json"+SignatureSuffix && conflicts == 0 {
+			conflicts++
+			output.files["packages.json"] = []byte(`{"packages":{"other":{}}}`)
+		}
+	}
+
+	if err := Generate(conf); err != nil {
+		t.Fatalf("Generate returned error: %v", err)
+	}
+
+	data := output.files["packages.json"]
+	if strings.Contains(string(data), "other") {
+		t.Errorf("Generate left packages.json of the other writer: %s", data)
+	}
+
+	if err := conf.Signing.Verify(data, output.files["packages.json"+SignatureSuffix]); err != nil {
+		t.Errorf("Verify returned error: %v", err)
+	}
+}
+
+func TestGenerateGivesUpOnConflicts(t *testing.T) {
+	key := newSigningKey(t, "a")
+	conf := newTestConfig(t, key)
+	output := conf.Output.(*memoryOutput)
+
+	other := []byte(`{"packages":{"other":{}}}`)
+	output.beforeWrite = func(name string, data []byte) {
+		if name == "packages.json"+SignatureSuffix {
+			output.files["packages.json"] = other
+		}
+	}
+
+	if err := Generate(conf); err != ErrConflict {
+		t.Errorf("Generate = %v, want ErrConflict", err)
+	}
+
+	// The contents of the other writer are never signed
+	if err := conf.Signing.Verify(other, output.files["packages.json"+SignatureSuffix]); err == nil {
+		t.Errorf("Generate signed packages.json of the other writer")
+	}
+}
@@ -0,0 +1,334 @@
+package composer
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ProblemKind describes what is wrong with a file of the repository.
+type ProblemKind string
+
+const (
+	// ProblemMissing is a referenced file which can't be read.
+	ProblemMissing ProblemKind = "missing"
+	// ProblemMismatch is a file whose sha256 doesn't match its reference.
+	ProblemMismatch ProblemKind = "mismatch"
+	// ProblemInvalid is a file which isn't valid metadata.
+	ProblemInvalid ProblemKind = "invalid"
+	// ProblemSignature is a file without a valid signature by a trusted key.
+	ProblemSignature ProblemKind = "signature"
+	// ProblemDangling is a provider of an input which isn't configured.
+	ProblemDangling ProblemKind = "dangling"
+)
+
+// Problem is a file of the repository which failed verification.
+type Problem struct {
+	File    string
+	Kind    ProblemKind
+	Message string
+
+	// InputID and PackageName identify the entry of packages.json the file
+	// belongs to. Both are empty for packages.json, and PackageName is
+	// empty for provider files.
+	InputID     string
+	PackageName string
+}
+
+func (p *Problem) String() string {
+	return fmt.Sprintf("%s: %s", p.File, p.Message)
+}
+
+// providerPrefix and providerSuffix surround the input ID in the
+// provider-includes of packages.json.
+const (
+	providerPrefix = "p/provider-"
+	providerSuffix = "$%hash%.json"
+)
+
+// verifier collects the problems found while walking the repository.
+type verifier struct {
+	conf     *Config
+	problems []*Problem
+}
+
+func (v *verifier) add(problem *Problem, kind ProblemKind, format string, args ...interface{}) {
+	problem.Kind = kind
+	problem.Message = fmt.Sprintf(format, args...)
+	v.problems = append(v.problems, problem)
+}
+
+// read reads the file of problem, which is referenced with hash, and checks
+// its hash and signature. It returns nil if the file can't be trusted. A file
+// whose only problem is its signature is still returned, since it matches the
+// hash it's referenced with, so the files it references are checked too.
+func (v *verifier) read(problem *Problem, hash string) []byte {
+	data, err := v.conf.Output.Get(v.conf.Context(), problem.File)
+	if err != nil {
+		v.add(problem, ProblemMissing, "Unable to read the file: %v", err)
+		return nil
+	}
+
+	if actual := fmt.Sprintf("%x", sha256.Sum256(data)); actual != hash {
+		v.add(problem, ProblemMismatch, "sha256 is %s, expected %s", actual, hash)
+		return nil
+	}
+
+	if !json.Valid(data) {
+		v.add(problem, ProblemInvalid, "The file isn't valid JSON")
+		return nil
+	}
+
+	if v.conf.Signing != nil {
+		if err := verifySignature(v.conf, problem.File, data); err != nil {
+			v.add(problem, ProblemSignature, "%v", err)
+		}
+	}
+
+	return data
+}
+
+// Verify walks the repository from packages.json through the output. Each
+// provider and package file it references must exist, match its sha256 and,
+// if signing is configured, be signed by a trusted key. Providers of inputs
+// which aren't configured are reported as dangling. It returns the problems
+// found, or an error if packages.json can't be read.
+func Verify(conf *Config) ([]*Problem, error) {
+	v := &verifier{conf: conf}
+
+	data, err := conf.Output.Get(conf.Context(), "packages.json")
+	if err != nil {
+		return nil, err
+	}
+
+	repo := &Repository{}
+	if err := json.Unmarshal(data, repo); err != nil {
+		return nil, fmt.Errorf("Unable to read packages.json: %v", err)
+	}
+
+	if conf.Signing != nil {
+		if err := verifySignature(conf, "packages.json", data); err != nil {
+			v.add(&Problem{File: "packages.json"}, ProblemSignature, "%v", err)
+		}
+	}
+
+	includes := make([]string, 0, len(repo.ProviderIncludes))
+	for include := range repo.ProviderIncludes {
+		includes = append(includes, include)
+	}
+	sort.Strings(includes)
+
+	for _, include := range includes {
+		hash := repo.ProviderIncludes[include].SHA256
+		problem := &Problem{
+			File:    strings.Replace(include, "%hash%", hash, -1),
+			InputID: strings.TrimSuffix(strings.TrimPrefix(include, providerPrefix), providerSuffix),
+		}
+
+		if _, ok := conf.Inputs[problem.InputID]; !ok {
+			v.add(problem, ProblemDangling, "No input matching %q", problem.InputID)
+			continue
+		}
+
+		providerData := v.read(problem, hash)
+		if providerData == nil {
+			continue
+		}
+
+		provider := &Repository{}
+		if err := json.Unmarshal(providerData, provider); err != nil {
+			v.add(problem, ProblemInvalid, "Unable to read the provider: %v", err)
+			continue
+		}
+
+		packageNames := make([]string, 0, len(provider.Providers))
+		for packageName := range provider.Providers {
+			packageNames = append(packageNames, packageName)
+		}
+		sort.Strings(packageNames)
+
+		for _, packageName := range packageNames {
+			hash := provider.Providers[packageName].SHA256
+			v.read(&Problem{
+				File:        fmt.Sprintf("p/%s$%s.json", packageName, hash),
+				InputID:     problem.InputID,
+				PackageName: packageName,
+			}, hash)
+		}
+	}
+
+	return v.problems, nil
+}
+
+// Repair fixes the problems found by Verify by regenerating only the
+// affected entries. A broken provider file is regenerated from its input,
+// a broken package file is updated from its input, dangling providers are
+// removed from packages.json, and files whose hash matches but whose
+// signature is missing or by an old key are signed again.
+//
+// packages.json is never signed again on its own: if its signature is
+// invalid, it may have been tampered with and the repository has to be
+// generated again.
+func Repair(conf *Config, problems []*Problem) error {
+	rebuild := make(map[string]bool)
+	dangling := make([]string, 0)
+	updates := make([]*PackageInfo, 0)
+	resign := make([]*Problem, 0)
+
+	for _, problem := range problems {
+		if problem.InputID == "" {
+			return errors.New("packages.json isn't signed by a trusted key, so it can't be repaired. Generate the repository again")
+		}
+
+		switch {
+		case problem.Kind == ProblemDangling:
+			dangling = append(dangling, providerPrefix+problem.InputID+providerSuffix)
+		case problem.Kind == ProblemSignature:
+			resign = append(resign, problem)
+		case problem.PackageName == "":
+			rebuild[problem.InputID] = true
+		default:
+			updates = append(updates, &PackageInfo{InputID: problem.InputID, PackageName: problem.PackageName})
+		}
+	}
+
+	if len(rebuild) > 0 || len(dangling) > 0 {
+		if err := rebuildProviders(conf, rebuild, dangling); err != nil {
+			return err
+		}
+	}
+
+	// Rebuilt providers already rewrote their packages
+	pending := make([]*PackageInfo, 0, len(updates))
+	for _, update := range updates {
+		if !rebuild[update.InputID] {
+			conf.Log.Info("Repairing package", "input", update.InputID, "package", update.PackageName)
+			pending = append(pending, update)
+		}
+	}
+
+	if len(pending) > 0 {
+		if err := Update(conf, pending); err != nil {
+			return err
+		}
+	}
+
+	for _, problem := range resign {
+		if rebuild[problem.InputID] {
+			continue
+		}
+
+		conf.Log.Info("Signing file", "file", problem.File)
+
+		data, err := conf.Output.Get(conf.Context(), problem.File)
+		if err != nil {
+			return err
+		}
+
+		if err := writeSignature(conf, problem.File, data); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// rebuildProviders regenerates the providers of the inputs in rebuild, and
+// removes the dangling provider-includes from packages.json.
+func rebuildProviders(conf *Config, rebuild map[string]bool, dangling []string) error {
+	inputIDs := make([]string, 0, len(rebuild))
+	for inputID := range rebuild {
+		inputIDs = append(inputIDs, inputID)
+	}
+	sort.Strings(inputIDs)
+
+	includes := make(map[string]*Reference, len(inputIDs))
+	generated := make(Packages)
+
+	for _, inputID := range inputIDs {
+		conf.Log.Info("Regenerating the provider of input", "input", inputID)
+
+		pkgs, err := transformedPackages(conf, conf.Inputs[inputID])
+		if err != nil {
+			return err
+		}
+
+		providerPath, ref, err := writeProvider(conf, inputID, pkgs)
+		if err != nil {
+			return err
+		}
+
+		includes[providerPath] = ref
+		for name, versions := range pkgs {
+			generated[name] = versions
+		}
+	}
+
+	for attempt := 1; ; attempt++ {
+		err := replaceProviders(conf, includes, dangling)
+		if err == nil {
+			break
+		} else if err != ErrConflict || attempt == maxUpdateAttempts {
+			return err
+		}
+
+		conf.Log.Warn("packages.json was modified during repair, retrying", "attempt", attempt+1, "maxAttempts", maxUpdateAttempts)
+	}
+
+	if !usesIndex(conf) || len(generated) == 0 {
+		return nil
+	}
+
+	index, err := updateIndex(conf, generated, nil)
+	if err != nil {
+		return err
+	}
+
+	if conf.Site != nil {
+		return writeSite(conf, generated, index)
+	}
+
+	return nil
+}
+
+// replaceProviders sets the provider-includes of packages.json to includes,
+// and removes those in dangling.
+func replaceProviders(conf *Config, includes map[string]*Reference, dangling []string) error {
+	repo := Repository{}
+
+	repoData, etag, err := GetWithETag(conf.Context(), conf.Output, "packages.json")
+	if err != nil {
+		return err
+	}
+
+	if err := json.Unmarshal(repoData, &repo); err != nil {
+		return err
+	}
+
+	for _, include := range dangling {
+		conf.Log.Info("Removing dangling provider", "provider", include)
+		delete(repo.ProviderIncludes, include)
+	}
+
+	for include, ref := range includes {
+		repo.ProviderIncludes[include] = ref
+	}
+
+	if err := transformRepository(conf, &repo); err != nil {
+		return err
+	}
+
+	contents, _, err := generateContentsAndHash(repo)
+	if err != nil {
+		return err
+	}
+
+	if err := WriteIfMatch(conf.Context(), conf.Output, "packages.json", contents, etag); err != nil {
+		return err
+	}
+
+	return signRepository(conf, contents)
+}
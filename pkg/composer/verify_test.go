@@ -0,0 +1,196 @@
+package composer
+
+import (
+	"strings"
+	"testing"
+)
+
+// generateTestRepository generates the repository of newTestConfig,
+// returning the config and its output.
+func generateTestRepository(t *testing.T) (*Config, *memoryOutput) {
+	conf := newTestConfig(t, newSigningKey(t, "a"))
+	if err := Generate(conf); err != nil {
+		t.Fatalf("Generate returned error: %v", err)
+	}
+
+	return conf, conf.Output.(*memoryOutput)
+}
+
+// fileOf returns the name of the only file of output starting with prefix
+// which isn't a signature.
+func fileOf(t *testing.T, output *memoryOutput, prefix string) string {
+	for _, name := range output.names(prefix) {
+		if !strings.HasSuffix(name, SignatureSuffix) {
+			return name
+		}
+	}
+
+	t.Fatalf("No file starting with %q", prefix)
+	return ""
+}
+
+func TestVerifyAndRepair(t *testing.T) {
+	tests := []struct {
+		name string
+		// damage changes the repository, returning the file expected to
+		// be reported.
+		damage func(t *testing.T, conf *Config, output *memoryOutput) string
+		kind   ProblemKind
+	}{
+		{"missing package", func(t *testing.T, conf *Config, output *memoryOutput) string {
+			name := fileOf(t, output, "p/acme/widget$")
+			delete(output.files, name)
+			return name
+		}, ProblemMissing},
+		{"changed package", func(t *testing.T, conf *Config, output *memoryOutput) string {
+			name := fileOf(t, output, "p/acme/widget$")
+			output.files[name] = []byte(`{"packages":{}}`)
+			return name
+		}, ProblemMismatch},
+		{"changed provider", func(t *testing.T, conf *Config, output *memoryOutput) string {
+			name := fileOf(t, output, "p/provider-acme$")
+			output.files[name] = []byte(`{"providers":{}}`)
+			return name
+		}, ProblemMismatch},
+		{"missing signature", func(t *testing.T, conf *Config, output *memoryOutput) string {
+			name := fileOf(t, output, "p/acme/gadget$")
+			delete(output.files, name+SignatureSuffix)
+			return name
+		}, ProblemSignature},
+		{"forged signature", func(t *testing.T, conf *Config, output *memoryOutput) string {
+			name := fileOf(t, output, "p/acme/gadget$")
+			forger := &Signing{Keys: []*SigningKey{newSigningKey(t, "a")}}
+			sig, err := forger.Sign(output.files[name])
+			if err != nil {
+				t.Fatal(err)
+			}
+			output.files[name+SignatureSuffix] = sig
+			return name
+		}, ProblemSignature},
+		{"retired key", func(t *testing.T, conf *Config, output *memoryOutput) string {
+			// The key is replaced, and packages.json signed with the new
+			// key, but the other files are still signed by the old one.
+			// The packages are checked, and signed again, along with
+			// their provider
+			conf.Signing = &Signing{Keys: []*SigningKey{newSigningKey(t, "b")}}
+			if err := writeSignature(conf, "packages.json", output.files["packages.json"]); err != nil {
+				t.Fatal(err)
+			}
+			return fileOf(t, output, "p/acme/gadget$")
+		}, ProblemSignature},
+		{"dangling provider", func(t *testing.T, conf *Config, output *memoryOutput) string {
+			delete(conf.Inputs, "acme")
+			return fileOf(t, output, "p/provider-acme$")
+		}, ProblemDangling},
+	}
+
+	for _, test := range tests {
+		conf, output := generateTestRepository(t)
+
+		if problems, err := Verify(conf); err != nil || len(problems) != 0 {
+			t.Errorf("%s: Verify of the generated repository = %v, %v, want no problems", test.name, problems, err)
+			continue
+		}
+
+		file := test.damage(t, conf, output)
+
+		problems, err := Verify(conf)
+		if err != nil {
+			t.Errorf("%s: Verify returned error: %v", test.name, err)
+			continue
+		}
+
+		found := false
+		for _, problem := range problems {
+			if problem.File == file && problem.Kind == test.kind {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("%s: Verify = %v, want a %s problem with %s", test.name, problems, test.kind, file)
+			continue
+		}
+
+		if err := Repair(conf, problems); err != nil {
+			t.Errorf("%s: Repair returned error: %v", test.name, err)
+			continue
+		}
+
+		if problems, err := Verify(conf); err != nil || len(problems) != 0 {
+			t.Errorf("%s: Verify of the repaired repository = %v, %v, want no problems", test.name, problems, err)
+		}
+	}
+}
+
+func TestRepairKeepsPackages(t *testing.T) {
+	conf, output := generateTestRepository(t)
+
+	delete(output.files, fileOf(t, output, "p/provider-acme$"))
+
+	problems, err := Verify(conf)
+	if err != nil {
+		t.Fatalf("Verify returned error: %v", err)
+	}
+
+	if err := Repair(conf, problems); err != nil {
+		t.Fatalf("Repair returned error: %v", err)
+	}
+
+	for _, prefix := range []string{"p/acme/widget$", "p/acme/gadget$"} {
+		if len(output.names(prefix)) == 0 {
+			t.Errorf("Repair dropped the files of %s", prefix)
+		}
+	}
+}
+
+func TestRepairRefusesForgedRepository(t *testing.T) {
+	conf, output := generateTestRepository(t)
+
+	// packages.json is changed and signed by a key which isn't trusted
+	output.files["packages.json"] = []byte(`{"packages":{"evil/package":{}}}`)
+	forger := &Signing{Keys: []*SigningKey{newSigningKey(t, "a")}}
+	sig, err := forger.Sign(output.files["packages.json"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	output.files["packages.json"+SignatureSuffix] = sig
+
+	problems, err := Verify(conf)
+	if err != nil {
+		t.Fatalf("Verify returned error: %v", err)
+	}
+
+	if len(problems) != 1 || problems[0].File != "packages.json" || problems[0].Kind != ProblemSignature {
+		t.Fatalf("Verify = %v, want a signature problem with packages.json", problems)
+	}
+
+	if err := Repair(conf, problems); err == nil {
+		t.Errorf("Repair of a forged packages.json returned no error")
+	}
+
+	// packages.json is left as it was, unsigned by a trusted key
+	if err := conf.Signing.Verify(output.files["packages.json"], output.files["packages.json"+SignatureSuffix]); err == nil {
+		t.Errorf("Repair signed the forged packages.json")
+	}
+}
+
+func TestVerifyWithoutSigning(t *testing.T) {
+	conf, output := generateTestRepository(t)
+	conf.Signing = nil
+
+	// Signatures aren't checked
+	for _, name := range output.names("p/") {
+		if strings.HasSuffix(name, SignatureSuffix) {
+			delete(output.files, name)
+		}
+	}
+
+	if problems, err := Verify(conf); err != nil || len(problems) != 0 {
+		t.Errorf("Verify = %v, %v, want no problems", problems, err)
+	}
+
+	delete(output.files, "packages.json")
+	if _, err := Verify(conf); err == nil {
+		t.Errorf("Verify without packages.json returned no error")
+	}
+}
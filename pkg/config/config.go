@@ -1,97 +1,681 @@
 package config
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"reflect"
+	"sort"
+	"time"
 
+	"github.com/zachomedia/composerrepo/pkg/auth"
 	"github.com/zachomedia/composerrepo/pkg/composer"
+	"github.com/zachomedia/composerrepo/pkg/config/schema"
+	"github.com/zachomedia/composerrepo/pkg/input/artifact"
 	"github.com/zachomedia/composerrepo/pkg/input/gitlab"
+	"github.com/zachomedia/composerrepo/pkg/input/repository"
+	"github.com/zachomedia/composerrepo/pkg/logging"
 	"github.com/zachomedia/composerrepo/pkg/output/azure"
 	"github.com/zachomedia/composerrepo/pkg/output/file"
+	"github.com/zachomedia/composerrepo/pkg/output/multi"
+	"github.com/zachomedia/composerrepo/pkg/site"
+	"github.com/zachomedia/composerrepo/pkg/stats"
+	"github.com/zachomedia/composerrepo/pkg/transformer/jsonpatch"
+	"github.com/zachomedia/composerrepo/pkg/transformer/rewrite"
+	"github.com/zachomedia/composerrepo/pkg/transformer/scope"
+	"github.com/zachomedia/composerrepo/pkg/transformer/script"
 	"github.com/zachomedia/composerrepo/pkg/transformer/static"
+	"github.com/zachomedia/composerrepo/pkg/transformer/versionmap"
+	"github.com/zachomedia/composerrepo/pkg/webhook"
 	yaml "gopkg.in/yaml.v2"
+	yaml3 "gopkg.in/yaml.v3"
 )
 
 var InputTypes = map[string]composer.Input{
-	"static": &static.StaticInput{},
-	"gitlab": &gitlab.GitLabInput{},
+	"static":              &static.StaticInput{},
+	"gitlab":              &gitlab.GitLabInput{},
+	"composer-repository": &repository.ComposerRepositoryInput{},
+	"artifact":            &artifact.ArtifactInput{},
 }
 
 var TransformerTypes = map[string]composer.Transformer{
-	"static": &static.StaticTransformer{},
+	"static":       &static.StaticTransformer{},
+	"jsonpatch":    &jsonpatch.JSONPatchTransformer{},
+	"script":       &script.ScriptTransformer{},
+	"rewrite-urls": &rewrite.RewriteURLsTransformer{},
+	"version-map":  &versionmap.VersionMapTransformer{},
 }
 
 var OutputTypes = map[string]composer.Output{
 	"azure": &azure.AzureOutput{},
 	"file":  &file.FileOutput{},
+	"multi": &multi.MultiOutput{},
 }
 
-func ConfigFromYAML(reader io.Reader) (*composer.Config, error) {
-	type config struct {
-		UseProviders bool                              `yaml:"providers"`
-		Inputs       map[string]map[string]interface{} `yaml:"inputs"`
-		Transformers []map[string]interface{}          `yaml:"transformers"`
-		Output       map[string]interface{}            `yaml:"output"`
+// NewOutput creates and initializes the output described by conf.
+func NewOutput(conf map[string]interface{}) (composer.Output, error) {
+	typ, err := getType(conf)
+	if err != nil {
+		return nil, err
+	}
+
+	outputType, ok := OutputTypes[typ]
+	if !ok {
+		return nil, &schema.FieldError{Path: "type", Message: fmt.Sprintf("unknown output type %q", typ)}
+	}
+
+	output := reflect.New(reflect.ValueOf(outputType).Elem().Type()).Interface().(composer.Output)
+	setLogger(output, logging.Default().With("output", typ))
+	setOutputFactory(output)
+	err = output.Init(conf)
+	if err != nil {
+		return nil, err
+	}
+
+	return output, nil
+}
+
+// setLogger gives the logger to the input, transformer or output if it logs.
+func setLogger(v interface{}, log *logging.Logger) {
+	if logged, ok := v.(composer.Logged); ok {
+		logged.SetLogger(log)
+	}
+}
+
+// setOutputFactory lets the input or output create the outputs it uses.
+func setOutputFactory(v interface{}) {
+	if builder, ok := v.(composer.OutputBuilder); ok {
+		builder.SetOutputFactory(NewOutput)
+	}
+}
+
+// getType returns the type of an input, transformer or output.
+func getType(conf map[string]interface{}) (string, error) {
+	typInt, ok := conf["type"]
+	if !ok {
+		return "", &schema.FieldError{Path: "type", Message: "is required"}
+	}
+
+	typ, ok := typInt.(string)
+	if !ok {
+		return "", &schema.FieldError{Path: "type", Message: "expected a string"}
+	}
+
+	return typ, nil
+}
+
+// RawConfig is the configuration file before inputs, transformers and
+// outputs are initialized.
+type RawConfig struct {
+	UseProviders bool                              `yaml:"providers"`
+	Inputs       map[string]map[string]interface{} `yaml:"inputs"`
+	Transformers []map[string]interface{}          `yaml:"transformers"`
+	Output       map[string]interface{}            `yaml:"output"`
+
+	CheckDependencies  map[string]interface{}   `yaml:"checkDependencies"`
+	SecurityAdvisories map[string]interface{}   `yaml:"securityAdvisories"`
+	Search             map[string]interface{}   `yaml:"search"`
+	HTML               map[string]interface{}   `yaml:"html"`
+	Stats              map[string]interface{}   `yaml:"stats"`
+	Signing            map[string]interface{}   `yaml:"signing"`
+	Webhooks           []map[string]interface{} `yaml:"webhooks"`
+	Auth               map[string]interface{}   `yaml:"auth"`
+}
+
+type DependencyCheckConfig struct {
+	Allow []string `config:"allow"`
+	Dev   bool     `config:"dev"`
+	Fail  bool     `config:"fail"`
+}
+
+type SearchConfig struct {
+	URL    string `config:"url"`
+	Static bool   `config:"static"`
+}
+
+type StatsConfig struct {
+	URL           string        `config:"url,required"`
+	File          string        `config:"file,required"`
+	FlushInterval time.Duration `config:"flushInterval"`
+}
+
+type SigningConfig struct {
+	Keys        []SigningKeyConfig `config:"keys"`
+	TrustedKeys []TrustedKeyConfig `config:"trustedKeys"`
+}
+
+type SigningKeyConfig struct {
+	ID         string `config:"id,required"`
+	PrivateKey string `config:"privateKey,required,secret"`
+}
+
+type TrustedKeyConfig struct {
+	ID        string `config:"id,required"`
+	PublicKey string `config:"publicKey,required"`
+}
+
+type WebhookConfig struct {
+	Path     string            `config:"path"`
+	Secret   string            `config:"secret,required,secret"`
+	Input    string            `config:"input,required"`
+	Packages map[string]string `config:"packages"`
+}
+
+type AuthConfig struct {
+	Clients        []AuthClientConfig `config:"clients"`
+	AllowAnonymous bool               `config:"allowAnonymous"`
+	RateLimit      RateLimitConfig    `config:"rateLimit"`
+	AuditLog       string             `config:"auditLog"`
+}
+
+type AuthClientConfig struct {
+	Name   string   `config:"name,required"`
+	Token  string   `config:"token,secret"`
+	Secret string   `config:"secret,secret"`
+	Inputs []string `config:"inputs"`
+}
+
+type RateLimitConfig struct {
+	Requests int           `config:"requests"`
+	Interval time.Duration `config:"interval"`
+}
+
+type HTMLConfig struct {
+	Title     string `config:"title"`
+	Templates string `config:"templates"`
+}
+
+type SecurityAdvisoriesConfig struct {
+	APIURL         string                 `config:"apiURL,required"`
+	Sources        []AdvisorySourceConfig `config:"sources,required"`
+	ReloadInterval time.Duration          `config:"reloadInterval"`
+}
+
+type AdvisorySourceConfig struct {
+	Name string `config:"name,required"`
+	Dir  string `config:"dir,required"`
+}
+
+// ReadYAML reads the configuration file, expanding environment variable and
+// secret file references in string values.
+func ReadYAML(reader io.Reader) (*RawConfig, error) {
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	// Resolve !file tags in the document, then decode it strictly
+	doc := yaml3.Node{}
+	if err := yaml3.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	if doc.Kind != 0 {
+		if err := resolveFileTags(&doc); err != nil {
+			return nil, err
+		}
+
+		if data, err = yaml3.Marshal(&doc); err != nil {
+			return nil, err
+		}
 	}
 
-	rawConfig := config{}
+	rawConfig := &RawConfig{}
 
-	// Open the YAML file and decode it
-	decoder := yaml.NewDecoder(reader)
+	// Decode the YAML file
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
 	decoder.SetStrict(true)
-	err := decoder.Decode(&rawConfig)
+	err = decoder.Decode(rawConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	errs := schema.Errors{}
+	for k, raw := range rawConfig.Inputs {
+		interpolate(schema.JoinPath("inputs", k), raw, &errs)
+	}
+	for k, raw := range rawConfig.Transformers {
+		interpolate(fmt.Sprintf("transformers[%d]", k), raw, &errs)
+	}
+	interpolate("output", rawConfig.Output, &errs)
+	interpolate("checkDependencies", rawConfig.CheckDependencies, &errs)
+	interpolate("securityAdvisories", rawConfig.SecurityAdvisories, &errs)
+	interpolate("search", rawConfig.Search, &errs)
+	interpolate("html", rawConfig.HTML, &errs)
+	interpolate("stats", rawConfig.Stats, &errs)
+	interpolate("signing", rawConfig.Signing, &errs)
+	for k, raw := range rawConfig.Webhooks {
+		interpolate(fmt.Sprintf("webhooks[%d]", k), raw, &errs)
+	}
+	interpolate("auth", rawConfig.Auth, &errs)
+
+	if err := errs.Err(); err != nil {
+		return nil, err
+	}
+
+	return rawConfig, nil
+}
+
+// ConfigFromYAML loads the configuration and initializes each input, transformer
+// and output. Problems with the configuration are returned together as schema.Errors.
+func ConfigFromYAML(reader io.Reader) (*composer.Config, error) {
+	rawConfig, err := ReadYAML(reader)
 	if err != nil {
 		return nil, err
 	}
 
+	return ConfigFromRaw(rawConfig)
+}
+
+// ConfigFromRaw initializes each input, transformer and output of rawConfig.
+func ConfigFromRaw(rawConfig *RawConfig) (*composer.Config, error) {
 	// Initialize each config item
 	conf := &composer.Config{
+		Log:          logging.Default(),
 		UseProviders: rawConfig.UseProviders,
 		Inputs:       make(map[string]composer.Input),
 		Transformers: make([]composer.Transformer, len(rawConfig.Transformers)),
 	}
+	errs := schema.Errors{}
 
-	for k, raw := range rawConfig.Inputs {
-		inputType, ok := InputTypes[raw["type"].(string)]
+	inputIDs := make([]string, 0, len(rawConfig.Inputs))
+	for k := range rawConfig.Inputs {
+		inputIDs = append(inputIDs, k)
+	}
+	sort.Strings(inputIDs)
+
+	for _, k := range inputIDs {
+		path := schema.JoinPath("inputs", k)
+
+		raw := rawConfig.Inputs[k]
+		typ, err := getType(raw)
+		if err != nil {
+			errs.Add(path, err)
+			continue
+		}
+
+		inputType, ok := InputTypes[typ]
 		if !ok {
-			return nil, fmt.Errorf("Unknown input type %q for %q", raw["type"].(string), k)
+			errs.Add(path+".type", fmt.Errorf("unknown input type %q", typ))
+			continue
 		}
 
 		conf.Inputs[k] = reflect.New(reflect.ValueOf(inputType).Elem().Type()).Interface().(composer.Input)
-		err = conf.Inputs[k].Init(k, raw)
-		if err != nil {
-			return nil, err
+		setLogger(conf.Inputs[k], logging.Default().With("input", k))
+		setOutputFactory(conf.Inputs[k])
+		errs.Add(path, conf.Inputs[k].Init(k, raw))
+	}
+
+	for _, k := range inputIDs {
+		if dependent, ok := conf.Inputs[k].(composer.DependentInput); ok {
+			errs.Add(schema.JoinPath("inputs", k), dependent.SetInputs(conf.Inputs))
 		}
 	}
 
 	for k, raw := range rawConfig.Transformers {
-		transformerType, ok := TransformerTypes[raw["type"].(string)]
+		path := fmt.Sprintf("transformers[%d]", k)
+
+		typ, err := getType(raw)
+		if err != nil {
+			errs.Add(path, err)
+			continue
+		}
+
+		transformerType, ok := TransformerTypes[typ]
 		if !ok {
-			return nil, fmt.Errorf("Unknown transformer type %q (transformer %d)", raw["type"].(string), k)
+			errs.Add(path+".type", fmt.Errorf("unknown transformer type %q", typ))
+			continue
+		}
+
+		// Any transformer can be scoped to certain versions
+		transformerConf := make(map[string]interface{})
+		scopeConf := make(map[string]interface{})
+		for key, v := range raw {
+			transformerConf[key] = v
+		}
+		for _, key := range scope.Keys {
+			if v, ok := raw[key]; ok {
+				scopeConf[key] = v
+				delete(transformerConf, key)
+			}
 		}
 
 		conf.Transformers[k] = reflect.New(reflect.ValueOf(transformerType).Elem().Type()).Interface().(composer.Transformer)
-		err = conf.Transformers[k].Init(k, raw)
-		if err != nil {
-			return nil, err
+		setLogger(conf.Transformers[k], logging.Default().With("transformer", k, "type", typ))
+		if err := conf.Transformers[k].Init(k, transformerConf); err != nil {
+			errs.Add(path, err)
+			continue
+		}
+
+		if len(scopeConf) > 0 {
+			scoped, err := scope.New(conf.Transformers[k], scopeConf)
+			if err != nil {
+				errs.Add(path, err)
+				continue
+			}
+			conf.Transformers[k] = scoped
 		}
 	}
 
 	if rawConfig.Output == nil {
-		return nil, errors.New("Output config cannot be empty")
+		errs.Add("output", errors.New("is required"))
+	} else {
+		output, err := NewOutput(rawConfig.Output)
+		conf.Output = output
+		errs.Add("output", err)
 	}
-	outputType, ok := OutputTypes[rawConfig.Output["type"].(string)]
-	if !ok {
-		return nil, fmt.Errorf("Unknown output type %q", rawConfig.Output["type"].(string))
+
+	if rawConfig.CheckDependencies != nil {
+		checkConfig := DependencyCheckConfig{}
+		if err := schema.Decode(rawConfig.CheckDependencies, &checkConfig); err != nil {
+			errs.Add("checkDependencies", err)
+		} else {
+			conf.CheckDependencies = &composer.DependencyCheck{
+				Allow: checkConfig.Allow,
+				Dev:   checkConfig.Dev,
+				Fail:  checkConfig.Fail,
+			}
+		}
 	}
 
-	conf.Output = reflect.New(reflect.ValueOf(outputType).Elem().Type()).Interface().(composer.Output)
-	err = conf.Output.Init(rawConfig.Output)
-	if err != nil {
+	if rawConfig.SecurityAdvisories != nil {
+		advisoriesConfig := SecurityAdvisoriesConfig{
+			ReloadInterval: time.Minute,
+		}
+		if err := schema.Decode(rawConfig.SecurityAdvisories, &advisoriesConfig); err != nil {
+			errs.Add("securityAdvisories", err)
+		} else {
+			db := &composer.AdvisoryDatabase{
+				APIURL:         advisoriesConfig.APIURL,
+				ReloadInterval: advisoriesConfig.ReloadInterval,
+			}
+			for _, source := range advisoriesConfig.Sources {
+				db.Dirs = append(db.Dirs, &composer.AdvisoryDir{Name: source.Name, Dir: source.Dir})
+			}
+			conf.SecurityAdvisories = db
+		}
+	}
+
+	if rawConfig.Search != nil {
+		searchConfig := SearchConfig{}
+		if err := schema.Decode(rawConfig.Search, &searchConfig); err != nil {
+			errs.Add("search", err)
+		} else if searchConfig.URL == "" && !searchConfig.Static {
+			errs.Add("search.url", errors.New("is required unless static is set"))
+		} else {
+			conf.Search = &composer.Search{
+				URL:    searchConfig.URL,
+				Static: searchConfig.Static,
+			}
+		}
+	}
+
+	if rawConfig.Stats != nil {
+		statsConfig := StatsConfig{
+			FlushInterval: 10 * time.Second,
+		}
+		if err := schema.Decode(rawConfig.Stats, &statsConfig); err != nil {
+			errs.Add("stats", err)
+		} else if statsConfig.FlushInterval <= 0 {
+			errs.Add("stats.flushInterval", fmt.Errorf("must be positive"))
+		} else {
+			conf.Stats = &composer.DownloadStats{
+				URL:           statsConfig.URL,
+				File:          statsConfig.File,
+				FlushInterval: statsConfig.FlushInterval,
+			}
+		}
+	}
+
+	if rawConfig.HTML != nil {
+		htmlConfig := HTMLConfig{
+			Title: "Composer repository",
+		}
+		if err := schema.Decode(rawConfig.HTML, &htmlConfig); err != nil {
+			errs.Add("html", err)
+		} else if htmlSite, err := site.New(htmlConfig.Title, htmlConfig.Templates, conf.Stats); err != nil {
+			errs.Add("html.templates", err)
+		} else {
+			conf.Site = htmlSite
+		}
+	}
+
+	if rawConfig.Signing != nil {
+		signingConfig := SigningConfig{}
+		if err := schema.Decode(rawConfig.Signing, &signingConfig); err != nil {
+			errs.Add("signing", err)
+		} else if len(signingConfig.Keys) == 0 && len(signingConfig.TrustedKeys) == 0 {
+			errs.Add("signing.keys", errors.New("is required unless trustedKeys is set"))
+		} else {
+			conf.Signing = newSigning(signingConfig, &errs)
+		}
+	}
+
+	paths := make(map[string]bool)
+	for k, raw := range rawConfig.Webhooks {
+		path := fmt.Sprintf("webhooks[%d]", k)
+
+		typ, err := getType(raw)
+		if err != nil {
+			errs.Add(path, err)
+			continue
+		}
+
+		provider, ok := webhook.Providers[typ]
+		if !ok {
+			errs.Add(path+".type", fmt.Errorf("unknown webhook type %q", typ))
+			continue
+		}
+
+		hookConfig := WebhookConfig{}
+		if err := schema.Decode(raw, &hookConfig); err != nil {
+			errs.Add(path, err)
+			continue
+		}
+
+		if hookConfig.Secret == "" {
+			errs.Add(path+".secret", errors.New("must not be empty"))
+			continue
+		}
+
+		if _, ok := conf.Inputs[hookConfig.Input]; !ok {
+			errs.Add(path+".input", fmt.Errorf("unknown input %q", hookConfig.Input))
+			continue
+		}
+
+		if hookConfig.Path == "" {
+			hookConfig.Path = "/webhooks/" + typ
+		}
+		if paths[hookConfig.Path] {
+			errs.Add(path+".path", fmt.Errorf("%q is used by another webhook", hookConfig.Path))
+			continue
+		}
+		paths[hookConfig.Path] = true
+
+		conf.Webhooks = append(conf.Webhooks, &webhook.Hook{
+			Type:     typ,
+			Path:     hookConfig.Path,
+			Provider: provider,
+			Secret:   hookConfig.Secret,
+			InputID:  hookConfig.Input,
+			Packages: hookConfig.Packages,
+		})
+	}
+
+	if rawConfig.Auth != nil {
+		authConfig := AuthConfig{
+			RateLimit: RateLimitConfig{Requests: 60, Interval: time.Minute},
+		}
+		if err := schema.Decode(rawConfig.Auth, &authConfig); err != nil {
+			errs.Add("auth", err)
+		} else {
+			conf.Auth = newAuth(authConfig, conf.Inputs, &errs)
+		}
+	}
+
+	if err := errs.Err(); err != nil {
 		return nil, err
 	}
 
 	return conf, nil
 }
+
+// newAuth creates the authentication of the endpoints from authConfig,
+// adding problems to errs.
+func newAuth(authConfig AuthConfig, inputs map[string]composer.Input, errs *schema.Errors) *auth.Auth {
+	a := &auth.Auth{
+		AllowAnonymous: authConfig.AllowAnonymous,
+		Audit:          &auth.AuditLog{Log: logging.Default(), Path: authConfig.AuditLog},
+	}
+	names := make(map[string]bool)
+
+	// Without clients, anyone could update packages, which has to be asked for
+	if len(authConfig.Clients) == 0 && !authConfig.AllowAnonymous {
+		errs.Add("auth.clients", errors.New("is required, unless allowAnonymous is set to let anyone update packages"))
+	} else if len(authConfig.Clients) > 0 && authConfig.AllowAnonymous {
+		errs.Add("auth.allowAnonymous", errors.New("can't be used with clients"))
+	}
+
+	for indx, clientConfig := range authConfig.Clients {
+		path := fmt.Sprintf("auth.clients[%d]", indx)
+
+		if names[clientConfig.Name] {
+			errs.Add(path+".name", fmt.Errorf("client %q is defined more than once", clientConfig.Name))
+		}
+		names[clientConfig.Name] = true
+
+		if (clientConfig.Token == "") == (clientConfig.Secret == "") {
+			errs.Add(path, errors.New("expected either a token or a secret"))
+		}
+
+		for _, inputID := range clientConfig.Inputs {
+			if _, ok := inputs[inputID]; !ok {
+				errs.Add(path+".inputs", fmt.Errorf("unknown input %q", inputID))
+			}
+		}
+
+		a.Clients = append(a.Clients, &auth.Client{
+			Name:   clientConfig.Name,
+			Token:  clientConfig.Token,
+			Secret: clientConfig.Secret,
+			Inputs: clientConfig.Inputs,
+		})
+	}
+
+	if authConfig.RateLimit.Requests > 0 {
+		if authConfig.RateLimit.Interval <= 0 {
+			errs.Add("auth.rateLimit.interval", errors.New("must be positive"))
+		}
+
+		a.Limiter = &auth.RateLimiter{
+			Requests: authConfig.RateLimit.Requests,
+			Interval: authConfig.RateLimit.Interval,
+		}
+	}
+
+	return a
+}
+
+// newSigning parses the keys of signingConfig, adding problems to errs.
+func newSigning(signingConfig SigningConfig, errs *schema.Errors) *composer.Signing {
+	signing := &composer.Signing{}
+	ids := make(map[string]bool)
+
+	for indx, key := range signingConfig.Keys {
+		path := fmt.Sprintf("signing.keys[%d]", indx)
+		if ids[key.ID] {
+			errs.Add(path+".id", fmt.Errorf("key %q is defined more than once", key.ID))
+		}
+		ids[key.ID] = true
+
+		privateKey, err := composer.ParsePrivateKey(key.PrivateKey)
+		if err != nil {
+			errs.Add(path+".privateKey", err)
+			continue
+		}
+
+		signing.Keys = append(signing.Keys, &composer.SigningKey{ID: key.ID, PrivateKey: privateKey})
+	}
+
+	for indx, key := range signingConfig.TrustedKeys {
+		path := fmt.Sprintf("signing.trustedKeys[%d]", indx)
+		if ids[key.ID] {
+			errs.Add(path+".id", fmt.Errorf("key %q is defined more than once", key.ID))
+		}
+		ids[key.ID] = true
+
+		publicKey, err := composer.ParsePublicKey(key.PublicKey)
+		if err != nil {
+			errs.Add(path+".publicKey", err)
+			continue
+		}
+
+		signing.TrustedKeys = append(signing.TrustedKeys, &composer.TrustedKey{ID: key.ID, PublicKey: publicKey})
+	}
+
+	return signing
+}
+
+// Open prepares the inputs and outputs for use. Config decoding has no side
+// effects, so a config can be validated without changing anything, and the
+// commands using it open it first.
+func Open(conf *composer.Config) error {
+	errs := schema.Errors{}
+
+	for _, k := range sortedInputIDs(conf) {
+		if opener, ok := conf.Inputs[k].(composer.Opener); ok {
+			errs.Add(schema.JoinPath("inputs", k), opener.Open())
+		}
+	}
+
+	if opener, ok := conf.Output.(composer.Opener); ok {
+		errs.Add("output", opener.Open())
+	}
+
+	if conf.Auth != nil {
+		errs.Add("auth.auditLog", conf.Auth.Audit.Open())
+	}
+
+	if conf.Stats != nil {
+		store, err := stats.Open(conf.Stats.File)
+		errs.Add("stats.file", err)
+		conf.Stats.Store = store
+	}
+
+	return errs.Err()
+}
+
+// sortedInputIDs returns the IDs of the inputs in order, so problems are
+// reported in a stable order.
+func sortedInputIDs(conf *composer.Config) []string {
+	inputIDs := make([]string, 0, len(conf.Inputs))
+	for k := range conf.Inputs {
+		inputIDs = append(inputIDs, k)
+	}
+	sort.Strings(inputIDs)
+
+	return inputIDs
+}
+
+// Check checks the connectivity and credentials of each input and output
+// which supports it.
+func Check(conf *composer.Config) error {
+	errs := schema.Errors{}
+
+	for _, k := range sortedInputIDs(conf) {
+		if checker, ok := conf.Inputs[k].(composer.Checker); ok {
+			errs.Add(schema.JoinPath("inputs", k), checker.Check())
+		}
+	}
+
+	if checker, ok := conf.Output.(composer.Checker); ok {
+		errs.Add("output", checker.Check())
+	}
+
+	if conf.SecurityAdvisories != nil {
+		errs.Add("securityAdvisories", conf.SecurityAdvisories.Check())
+	}
+
+	return errs.Err()
+}
@@ -0,0 +1,145 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/zachomedia/composerrepo/pkg/config/schema"
+	"github.com/zachomedia/composerrepo/pkg/redact"
+
+	yaml3 "gopkg.in/yaml.v3"
+)
+
+// resolveFileTags replaces `!file /path` scalars with the equivalent
+// `${file:/path}` reference, as the YAML decoder drops unknown tags. Only
+// tags are resolved, so `!file` in comments and strings is left alone.
+func resolveFileTags(node *yaml3.Node) error {
+	if node.Tag == "!file" {
+		if node.Kind != yaml3.ScalarNode || node.Value == "" {
+			return fmt.Errorf("line %d: !file expects a file path", node.Line)
+		}
+		if strings.Contains(node.Value, "}") {
+			return fmt.Errorf("line %d: file path %q can't contain }", node.Line, node.Value)
+		}
+
+		node.Tag = "!!str"
+		node.Style = yaml3.DoubleQuotedStyle
+		node.Value = fmt.Sprintf("${file:%s}", node.Value)
+		return nil
+	}
+
+	for _, child := range node.Content {
+		if err := resolveFileTags(child); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// readSecretFile reads a secret from a file, such as a mounted secret.
+func readSecretFile(filePath string) (string, error) {
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return "", err
+	}
+
+	secret := string(bytes.TrimRight(data, "\r\n"))
+	redact.Add(secret)
+
+	return secret, nil
+}
+
+// expand replaces references in s:
+//
+//	${NAME}          the environment variable NAME, which must be set
+//	${NAME:-default} the environment variable NAME, or default if it is empty
+//	${file:/path}    the contents of the file, which is treated as a secret
+//	$${              a literal ${
+func expand(s string) (string, error) {
+	var out strings.Builder
+
+	for {
+		start := strings.Index(s, "${")
+		if start < 0 {
+			out.WriteString(s)
+			return out.String(), nil
+		}
+
+		// Escaped reference
+		if start > 0 && s[start-1] == '$' {
+			out.WriteString(s[:start])
+			out.WriteString("{")
+			s = s[start+2:]
+			continue
+		}
+
+		end := strings.Index(s[start:], "}")
+		if end < 0 {
+			return "", fmt.Errorf("unterminated reference in %q", s)
+		}
+		end += start
+
+		out.WriteString(s[:start])
+		ref := s[start+2 : end]
+		s = s[end+1:]
+
+		if strings.HasPrefix(ref, "file:") {
+			value, err := readSecretFile(strings.TrimPrefix(ref, "file:"))
+			if err != nil {
+				return "", err
+			}
+
+			out.WriteString(value)
+			continue
+		}
+
+		name, def, hasDefault := ref, "", false
+		if indx := strings.Index(ref, ":-"); indx >= 0 {
+			name, def, hasDefault = ref[:indx], ref[indx+2:], true
+		}
+
+		value, ok := os.LookupEnv(name)
+		if !ok && !hasDefault {
+			return "", fmt.Errorf("environment variable %q is not set", name)
+		}
+		if value == "" {
+			value = def
+		}
+
+		out.WriteString(value)
+	}
+}
+
+// interpolate expands references in all strings within raw.
+func interpolate(path string, raw interface{}, errs *schema.Errors) interface{} {
+	switch raw := raw.(type) {
+	case string:
+		value, err := expand(raw)
+		if err != nil {
+			errs.Add(path, err)
+			return raw
+		}
+		return value
+
+	case []interface{}:
+		for indx, item := range raw {
+			raw[indx] = interpolate(fmt.Sprintf("%s[%d]", path, indx), item, errs)
+		}
+
+	case map[interface{}]interface{}:
+		for k, v := range raw {
+			raw[k] = interpolate(schema.JoinPath(path, fmt.Sprint(k)), v, errs)
+		}
+
+	case map[string]interface{}:
+		for k, v := range raw {
+			raw[k] = interpolate(schema.JoinPath(path, k), v, errs)
+		}
+	}
+
+	return raw
+}
@@ -0,0 +1,194 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/zachomedia/composerrepo/pkg/redact"
+)
+
+// secretFile writes a secret file in a temporary directory, returning its
+// path and a function removing it.
+func secretFile(t *testing.T, content string) (string, func()) {
+	dir, err := ioutil.TempDir("", "interpolate")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	filePath := filepath.Join(dir, "secret")
+	if err := ioutil.WriteFile(filePath, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	return filePath, func() { os.RemoveAll(dir) }
+}
+
+func TestExpand(t *testing.T) {
+	os.Setenv("INTERPOLATE_TOKEN", "abc123")
+	os.Setenv("INTERPOLATE_EMPTY", "")
+	os.Unsetenv("INTERPOLATE_UNSET")
+	defer os.Unsetenv("INTERPOLATE_TOKEN")
+	defer os.Unsetenv("INTERPOLATE_EMPTY")
+
+	filePath, cleanup := secretFile(t, "file-secret\n")
+	defer cleanup()
+
+	tests := []struct {
+		s    string
+		want string
+		err  string
+	}{
+		{"plain", "plain", ""},
+		{"${INTERPOLATE_TOKEN}", "abc123", ""},
+		{"Bearer ${INTERPOLATE_TOKEN}!", "Bearer abc123!", ""},
+		{"${INTERPOLATE_TOKEN}${INTERPOLATE_TOKEN}", "abc123abc123", ""},
+		{"${INTERPOLATE_UNSET:-fallback}", "fallback", ""},
+		{"${INTERPOLATE_EMPTY:-fallback}", "fallback", ""},
+		{"${INTERPOLATE_TOKEN:-fallback}", "abc123", ""},
+		{"${INTERPOLATE_UNSET:-}", "", ""},
+		{"${INTERPOLATE_EMPTY}", "", ""},
+		{"$${INTERPOLATE_TOKEN}", "${INTERPOLATE_TOKEN}", ""},
+		{"cost: $5", "cost: $5", ""},
+		{"${file:" + filePath + "}", "file-secret", ""},
+		{"${INTERPOLATE_UNSET}", "", `environment variable "INTERPOLATE_UNSET" is not set`},
+		{"${INTERPOLATE_TOKEN", "", "unterminated reference"},
+		{"${file:/nonexistent/secret}", "", "no such file"},
+	}
+
+	for _, test := range tests {
+		got, err := expand(test.s)
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("expand(%q) = %q, %v, want error containing %q", test.s, got, err, test.err)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("expand(%q) returned error: %v", test.s, err)
+		} else if got != test.want {
+			t.Errorf("expand(%q) = %q, want %q", test.s, got, test.want)
+		}
+	}
+}
+
+func TestReadYAMLFileTags(t *testing.T) {
+	filePath, cleanup := secretFile(t, "s3cr3t-from-file\n")
+	defer cleanup()
+
+	tests := []struct {
+		name  string
+		input string
+		want  map[string]interface{}
+		err   string
+	}{
+		{
+			"tag",
+			"token: !file " + filePath,
+			map[string]interface{}{"token": "s3cr3t-from-file"},
+			"",
+		},
+		{
+			"quoted tag",
+			`token: !file "` + filePath + `"`,
+			map[string]interface{}{"token": "s3cr3t-from-file"},
+			"",
+		},
+		{
+			"flow list",
+			"tokens: [a, !file " + filePath + "]",
+			map[string]interface{}{"tokens": []interface{}{"a", "s3cr3t-from-file"}},
+			"",
+		},
+		{
+			"comment",
+			"token: abc # !file " + filePath,
+			map[string]interface{}{"token": "abc"},
+			"",
+		},
+		{
+			"commented out line",
+			"# token: !file " + filePath + "\ntoken: abc",
+			map[string]interface{}{"token": "abc"},
+			"",
+		},
+		{
+			"quoted string",
+			`description: "run !file ` + filePath + `"`,
+			map[string]interface{}{"description": "run !file " + filePath},
+			"",
+		},
+		{
+			"block scalar",
+			"script: |\n  cat !file " + filePath + "\n",
+			map[string]interface{}{"script": "cat !file " + filePath + "\n"},
+			"",
+		},
+		{
+			"other values",
+			"enabled: true\ncount: 3\nratio: 1.5\nnothing: ~",
+			map[string]interface{}{"enabled": true, "count": 3, "ratio": 1.5, "nothing": nil},
+			"",
+		},
+		{
+			"missing file",
+			"token: !file /nonexistent/secret",
+			nil,
+			"inputs.s.token:",
+		},
+		{
+			"tag on a map",
+			"token: !file {path: x}",
+			nil,
+			"!file expects a file path",
+		},
+		{
+			"brace in path",
+			"token: !file /run/se}cret",
+			nil,
+			"can't contain }",
+		},
+	}
+
+	for _, test := range tests {
+		// Nest the test document in an input, indenting each line
+		input := "inputs:\n  s:\n    type: static\n    " + strings.Replace(test.input, "\n", "\n    ", -1) + "\n"
+
+		rawConfig, err := ReadYAML(strings.NewReader(input))
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%s: ReadYAML returned %v, want error containing %q", test.name, err, test.err)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%s: ReadYAML returned error: %v", test.name, err)
+			continue
+		}
+
+		got := rawConfig.Inputs["s"]
+		delete(got, "type")
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: ReadYAML = %#v, want %#v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestReadYAMLRedactsSecretFiles(t *testing.T) {
+	filePath, cleanup := secretFile(t, "redact-me-please\n")
+	defer cleanup()
+
+	input := "inputs:\n  s:\n    type: static\n    token: ${file:" + filePath + "}\n"
+	if _, err := ReadYAML(strings.NewReader(input)); err != nil {
+		t.Fatalf("ReadYAML returned error: %v", err)
+	}
+
+	if got := redact.String("token redact-me-please"); strings.Contains(got, "redact-me-please") {
+		t.Errorf("redact.String = %q, want the secret redacted", got)
+	}
+}
@@ -0,0 +1,374 @@
+// Package schema decodes the raw configuration of inputs, transformers and
+// outputs into typed structs, reporting problems with the path of the field.
+//
+// Struct fields are mapped with the `config` tag, for example:
+//
+//	type Config struct {
+//		URL   string `config:"url,required"`
+//		Token string `config:"token,required"`
+//	}
+//
+// String fields tagged with the "secret" option are registered with the
+// redact package so their values never appear in logs.
+//
+// Fields keep their current value when they are missing from the config, so
+// defaults can be set before decoding.
+package schema
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/zachomedia/composerrepo/pkg/redact"
+)
+
+// FieldError is a problem with a single config field.
+type FieldError struct {
+	Path    string
+	Message string
+}
+
+func (e *FieldError) Error() string {
+	if e.Path == "" {
+		return e.Message
+	}
+
+	return fmt.Sprintf("%s: %s", e.Path, e.Message)
+}
+
+// Errors is a list of problems found in a config.
+type Errors []*FieldError
+
+func (e Errors) Error() string {
+	messages := make([]string, len(e))
+	for indx, err := range e {
+		messages[indx] = err.Error()
+	}
+
+	return strings.Join(messages, "\n")
+}
+
+// Add appends err to the list, prefixing its paths with path.
+func (e *Errors) Add(path string, err error) {
+	if err == nil {
+		return
+	}
+
+	switch err := err.(type) {
+	case Errors:
+		for _, ferr := range err {
+			*e = append(*e, &FieldError{Path: JoinPath(path, ferr.Path), Message: ferr.Message})
+		}
+	case *FieldError:
+		*e = append(*e, &FieldError{Path: JoinPath(path, err.Path), Message: err.Message})
+	default:
+		*e = append(*e, &FieldError{Path: path, Message: err.Error()})
+	}
+}
+
+// Err returns the list as an error, or nil if it is empty.
+func (e Errors) Err() error {
+	if len(e) == 0 {
+		return nil
+	}
+
+	return e
+}
+
+// JoinPath joins two field paths.
+func JoinPath(prefix, path string) string {
+	switch {
+	case prefix == "":
+		return path
+	case path == "":
+		return prefix
+	case strings.HasPrefix(path, "["):
+		return prefix + path
+	default:
+		return prefix + "." + path
+	}
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// Decode decodes conf into the struct pointed to by out.
+//
+// The "type" key selects the input, transformer or output and is handled
+// by the config package, so it is ignored.
+func Decode(conf map[string]interface{}, out interface{}) error {
+	v := reflect.ValueOf(out)
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
+		panic("schema: Decode expects a pointer to a struct")
+	}
+
+	raw := make(map[interface{}]interface{}, len(conf))
+	for k, val := range conf {
+		if k != "type" {
+			raw[k] = val
+		}
+	}
+
+	errs := Errors{}
+	decodeValue("", raw, v.Elem(), &errs)
+	return errs.Err()
+}
+
+// describe returns a user friendly name for the type of raw.
+func describe(raw interface{}) string {
+	switch raw.(type) {
+	case nil:
+		return "nothing"
+	case string:
+		return "a string"
+	case bool:
+		return "a boolean"
+	case int, int64, uint64:
+		return "an integer"
+	case float64:
+		return "a number"
+	case []interface{}:
+		return "a list"
+	case map[interface{}]interface{}, map[string]interface{}:
+		return "a map"
+	}
+
+	return fmt.Sprintf("%T", raw)
+}
+
+// toMap converts the map types produced by the YAML decoder to a common type.
+func toMap(raw interface{}) (map[interface{}]interface{}, bool) {
+	switch raw := raw.(type) {
+	case map[interface{}]interface{}:
+		return raw, true
+	case map[string]interface{}:
+		m := make(map[interface{}]interface{}, len(raw))
+		for k, v := range raw {
+			m[k] = v
+		}
+		return m, true
+	}
+
+	return nil, false
+}
+
+type tag struct {
+	Name     string
+	Required bool
+	Secret   bool
+}
+
+// Normalize converts values decoded from YAML into their JSON equivalents.
+// Maps and lists are copied, so the result never shares values with v.
+func Normalize(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, val := range v {
+			m[fmt.Sprint(k)] = Normalize(val)
+		}
+		return m
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, val := range v {
+			m[k] = Normalize(val)
+		}
+		return m
+	case []interface{}:
+		l := make([]interface{}, len(v))
+		for indx, val := range v {
+			l[indx] = Normalize(val)
+		}
+		return l
+	}
+
+	return v
+}
+
+// parseTag returns the name and options of a `config` struct tag.
+func parseTag(field reflect.StructField) (*tag, bool) {
+	value, ok := field.Tag.Lookup("config")
+	if !ok || value == "-" {
+		return nil, false
+	}
+
+	parts := strings.Split(value, ",")
+	t := &tag{Name: parts[0]}
+	for _, opt := range parts[1:] {
+		switch opt {
+		case "required":
+			t.Required = true
+		case "secret":
+			t.Secret = true
+		}
+	}
+
+	return t, true
+}
+
+func decodeValue(path string, raw interface{}, v reflect.Value, errs *Errors) {
+	expected := func(what string) {
+		*errs = append(*errs, &FieldError{Path: path, Message: fmt.Sprintf("expected %s, got %s", what, describe(raw))})
+	}
+
+	if v.Type() == durationType {
+		s, ok := raw.(string)
+		if !ok {
+			expected("a duration such as \"30s\"")
+			return
+		}
+
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			*errs = append(*errs, &FieldError{Path: path, Message: fmt.Sprintf("invalid duration %q", s)})
+			return
+		}
+
+		v.SetInt(int64(d))
+		return
+	}
+
+	switch v.Kind() {
+	case reflect.Interface:
+		if raw != nil {
+			v.Set(reflect.ValueOf(raw))
+		}
+
+	case reflect.Ptr:
+		elem := reflect.New(v.Type().Elem())
+		if !v.IsNil() {
+			elem.Elem().Set(v.Elem())
+		}
+		decodeValue(path, raw, elem.Elem(), errs)
+		v.Set(elem)
+
+	case reflect.String:
+		s, ok := raw.(string)
+		if !ok {
+			expected("a string")
+			return
+		}
+		v.SetString(s)
+
+	case reflect.Bool:
+		b, ok := raw.(bool)
+		if !ok {
+			expected("a boolean")
+			return
+		}
+		v.SetBool(b)
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		switch n := raw.(type) {
+		case int:
+			v.SetInt(int64(n))
+		case int64:
+			v.SetInt(n)
+		default:
+			expected("an integer")
+		}
+
+	case reflect.Float32, reflect.Float64:
+		switch n := raw.(type) {
+		case int:
+			v.SetFloat(float64(n))
+		case int64:
+			v.SetFloat(float64(n))
+		case float64:
+			v.SetFloat(n)
+		default:
+			expected("a number")
+		}
+
+	case reflect.Slice:
+		list, ok := raw.([]interface{})
+		if !ok {
+			expected("a list")
+			return
+		}
+
+		slice := reflect.MakeSlice(v.Type(), len(list), len(list))
+		for indx, item := range list {
+			decodeValue(fmt.Sprintf("%s[%d]", path, indx), item, slice.Index(indx), errs)
+		}
+		v.Set(slice)
+
+	case reflect.Map:
+		m, ok := toMap(raw)
+		if !ok {
+			expected("a map")
+			return
+		}
+
+		if v.IsNil() {
+			v.Set(reflect.MakeMap(v.Type()))
+		}
+
+		for _, key := range sortedKeys(m) {
+			name, ok := key.(string)
+			if !ok {
+				*errs = append(*errs, &FieldError{Path: path, Message: fmt.Sprintf("expected string keys, got %v", key)})
+				continue
+			}
+
+			elem := reflect.New(v.Type().Elem()).Elem()
+			decodeValue(JoinPath(path, name), m[key], elem, errs)
+			v.SetMapIndex(reflect.ValueOf(name), elem)
+		}
+
+	case reflect.Struct:
+		m, ok := toMap(raw)
+		if !ok {
+			expected("a map")
+			return
+		}
+
+		known := make(map[string]bool)
+		for indx := 0; indx < v.NumField(); indx++ {
+			t, ok := parseTag(v.Type().Field(indx))
+			if !ok {
+				continue
+			}
+			known[t.Name] = true
+
+			fieldRaw, ok := m[t.Name]
+			if !ok {
+				if t.Required {
+					*errs = append(*errs, &FieldError{Path: JoinPath(path, t.Name), Message: "is required"})
+				}
+				continue
+			}
+
+			if s, ok := fieldRaw.(string); ok && t.Secret {
+				redact.Add(s)
+			}
+
+			decodeValue(JoinPath(path, t.Name), fieldRaw, v.Field(indx), errs)
+		}
+
+		for _, key := range sortedKeys(m) {
+			if name, ok := key.(string); !ok || !known[name] {
+				*errs = append(*errs, &FieldError{Path: JoinPath(path, fmt.Sprint(key)), Message: "unknown field"})
+			}
+		}
+
+	default:
+		panic(fmt.Sprintf("schema: unsupported type %s", v.Type()))
+	}
+}
+
+// sortedKeys returns the keys of m in a stable order so errors are reported consistently.
+func sortedKeys(m map[interface{}]interface{}) []interface{} {
+	keys := make([]interface{}, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		return fmt.Sprint(keys[i]) < fmt.Sprint(keys[j])
+	})
+
+	return keys
+}
@@ -0,0 +1,179 @@
+package schema
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+type testClient struct {
+	Name   string   `config:"name,required"`
+	Inputs []string `config:"inputs"`
+}
+
+type testConfig struct {
+	URL      string                 `config:"url,required"`
+	Enabled  bool                   `config:"enabled"`
+	Limit    int                    `config:"limit"`
+	Ratio    float64                `config:"ratio"`
+	Interval time.Duration          `config:"interval"`
+	Labels   map[string]string      `config:"labels"`
+	Clients  []testClient           `config:"clients"`
+	Primary  *testClient            `config:"primary"`
+	Raw      interface{}            `config:"raw"`
+	Output   map[string]interface{} `config:"output"`
+	Ignored  string                 `config:"-"`
+}
+
+func TestDecode(t *testing.T) {
+	conf := map[string]interface{}{
+		"type":     "test",
+		"url":      "https://example.com",
+		"enabled":  true,
+		"limit":    5,
+		"ratio":    2,
+		"interval": "1m30s",
+		"labels":   map[interface{}]interface{}{"env": "prod"},
+		"clients": []interface{}{
+			map[interface{}]interface{}{"name": "ci", "inputs": []interface{}{"gitlab"}},
+		},
+		"primary": map[interface{}]interface{}{"name": "deploy"},
+		"raw":     []interface{}{1, "two"},
+		"output":  map[interface{}]interface{}{"type": "file", "dir": "out"},
+	}
+
+	got := testConfig{Limit: 10, Ignored: "kept"}
+	if err := Decode(conf, &got); err != nil {
+		t.Fatalf("Decode returned error: %v", err)
+	}
+
+	want := testConfig{
+		URL:      "https://example.com",
+		Enabled:  true,
+		Limit:    5,
+		Ratio:    2,
+		Interval: 90 * time.Second,
+		Labels:   map[string]string{"env": "prod"},
+		Clients:  []testClient{{Name: "ci", Inputs: []string{"gitlab"}}},
+		Primary:  &testClient{Name: "deploy"},
+		Raw:      []interface{}{1, "two"},
+		Output:   map[string]interface{}{"type": "file", "dir": "out"},
+		Ignored:  "kept",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Decode = %+v, want %+v", got, want)
+	}
+}
+
+func TestDecodeKeepsDefaults(t *testing.T) {
+	got := testConfig{Limit: 10, Interval: time.Minute}
+	if err := Decode(map[string]interface{}{"url": "u"}, &got); err != nil {
+		t.Fatalf("Decode returned error: %v", err)
+	}
+
+	if got.Limit != 10 || got.Interval != time.Minute {
+		t.Errorf("Decode replaced defaults: limit %d, interval %s", got.Limit, got.Interval)
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		conf map[string]interface{}
+		want []string
+	}{
+		{"missing required", map[string]interface{}{}, []string{"url: is required"}},
+		{"unknown field", map[string]interface{}{"url": "u", "colour": "red"}, []string{"colour: unknown field"}},
+		{"wrong string", map[string]interface{}{"url": 5}, []string{"url: expected a string, got an integer"}},
+		{"wrong boolean", map[string]interface{}{"url": "u", "enabled": "yes"}, []string{"enabled: expected a boolean, got a string"}},
+		{"wrong integer", map[string]interface{}{"url": "u", "limit": 1.5}, []string{"limit: expected an integer, got a number"}},
+		{"wrong number", map[string]interface{}{"url": "u", "ratio": "2"}, []string{"ratio: expected a number, got a string"}},
+		{"wrong duration", map[string]interface{}{"url": "u", "interval": 30}, []string{`interval: expected a duration such as "30s", got an integer`}},
+		{"invalid duration", map[string]interface{}{"url": "u", "interval": "soon"}, []string{`interval: invalid duration "soon"`}},
+		{"wrong list", map[string]interface{}{"url": "u", "clients": "ci"}, []string{"clients: expected a list, got a string"}},
+		{"wrong map", map[string]interface{}{"url": "u", "labels": []interface{}{}}, []string{"labels: expected a map, got a list"}},
+		{"nested", map[string]interface{}{
+			"url": "u",
+			"clients": []interface{}{
+				map[interface{}]interface{}{"name": "ci"},
+				map[interface{}]interface{}{"inputs": []interface{}{true}},
+			},
+		}, []string{"clients[1].name: is required", "clients[1].inputs[0]: expected a string, got a boolean"}},
+		{"map value", map[string]interface{}{"url": "u", "labels": map[interface{}]interface{}{"env": nil}}, []string{"labels.env: expected a string, got nothing"}},
+		{"map key", map[string]interface{}{"url": "u", "labels": map[interface{}]interface{}{1: "one"}}, []string{"labels: expected string keys, got 1"}},
+		{"several", map[string]interface{}{"enabled": 1, "extra": true}, []string{"url: is required", "enabled: expected a boolean, got an integer", "extra: unknown field"}},
+	}
+
+	for _, test := range tests {
+		err := Decode(test.conf, &testConfig{})
+
+		errs, ok := err.(Errors)
+		if !ok {
+			t.Errorf("%s: Decode = %v, want Errors", test.name, err)
+			continue
+		}
+
+		got := make([]string, len(errs))
+		for indx, ferr := range errs {
+			got[indx] = ferr.Error()
+		}
+
+		if !sameStrings(got, test.want) {
+			t.Errorf("%s: Decode = %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+// sameStrings reports whether a and b have the same strings in any order.
+func sameStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	counts := make(map[string]int)
+	for _, s := range a {
+		counts[s]++
+	}
+	for _, s := range b {
+		counts[s]--
+	}
+	for _, count := range counts {
+		if count != 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+func TestErrorsAdd(t *testing.T) {
+	errs := Errors{}
+	errs.Add("inputs.gitlab", nil)
+	errs.Add("inputs.gitlab", &FieldError{Path: "url", Message: "is required"})
+	errs.Add("transformers", Errors{{Path: "[0].type", Message: "unknown"}})
+	errs.Add("output", &FieldError{Message: "is required"})
+
+	want := "inputs.gitlab.url: is required\ntransformers[0].type: unknown\noutput: is required"
+	if err := errs.Err(); err == nil || err.Error() != want {
+		t.Errorf("Errors = %v, want %q", err, want)
+	}
+
+	if err := (Errors{}).Err(); err != nil {
+		t.Errorf("Err of no errors = %v, want nil", err)
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	raw := map[interface{}]interface{}{
+		"require": map[interface{}]interface{}{"php": ">=7"},
+		"list":    []interface{}{map[interface{}]interface{}{1: true}},
+	}
+
+	want := map[string]interface{}{
+		"require": map[string]interface{}{"php": ">=7"},
+		"list":    []interface{}{map[string]interface{}{"1": true}},
+	}
+	if got := Normalize(raw); !reflect.DeepEqual(got, want) {
+		t.Errorf("Normalize = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,48 @@
+// Package filelock guards files shared by several processes, such as the
+// replicas of `repo serve` writing to the same directory, with lock files.
+package filelock
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// Suffix is the suffix of the lock file guarding a file.
+const Suffix = ".lock"
+
+// pollInterval is how often a lock held by another process is tried again.
+const pollInterval = 100 * time.Millisecond
+
+// Lock acquires the lock file guarding path, waiting up to timeout for other
+// processes to release it, and returns the function releasing it.
+//
+// The lock is held by the open lock file rather than by its existence, so
+// the system releases the lock of a process which crashed, and locks never
+// need to be broken.
+func Lock(path string, timeout time.Duration) (func(), error) {
+	lockPath := path + Suffix
+	deadline := time.Now().Add(timeout)
+
+	for {
+		f, err := tryLock(lockPath)
+		if err != nil {
+			return nil, err
+		}
+
+		if f != nil {
+			// The lock file is removed when it is released, which happens to
+			// whoever locked it in the meantime, so they try again.
+			return func() {
+				os.Remove(lockPath)
+				f.Close()
+			}, nil
+		}
+
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("Timed out waiting for lock %q", lockPath)
+		}
+
+		time.Sleep(pollInterval)
+	}
+}
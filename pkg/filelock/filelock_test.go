@@ -0,0 +1,112 @@
+package filelock
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestLock(t *testing.T) {
+	dir, err := ioutil.TempDir("", "filelock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "packages.json")
+
+	unlock, err := Lock(path, time.Second)
+	if err != nil {
+		t.Fatalf("Lock returned error: %v", err)
+	}
+
+	if _, err := Lock(path, 200*time.Millisecond); err == nil {
+		t.Fatal("Lock of a held lock succeeded, want a timeout")
+	}
+
+	// A waiter gets the lock once it is released
+	acquired := make(chan error)
+	go func() {
+		unlock, err := Lock(path, 5*time.Second)
+		if err == nil {
+			unlock()
+		}
+		acquired <- err
+	}()
+
+	time.Sleep(200 * time.Millisecond)
+	unlock()
+
+	if err := <-acquired; err != nil {
+		t.Fatalf("Lock after release returned error: %v", err)
+	}
+}
+
+func TestLockLeftBehind(t *testing.T) {
+	dir, err := ioutil.TempDir("", "filelock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// A lock file left by a crashed process isn't held by anyone
+	path := filepath.Join(dir, "packages.json")
+	if err := ioutil.WriteFile(path+Suffix, []byte("1234\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	unlock, err := Lock(path, 200*time.Millisecond)
+	if err != nil {
+		t.Fatalf("Lock returned error: %v", err)
+	}
+	unlock()
+}
+
+func TestLockExclusive(t *testing.T) {
+	dir, err := ioutil.TempDir("", "filelock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "count")
+	if err := ioutil.WriteFile(path, []byte("0"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Each increment reads and writes the count while holding the lock, so
+	// none are lost unless two holders overlap.
+	const workers, increments = 8, 25
+	done := make(chan error)
+	for indx := 0; indx < workers; indx++ {
+		go func() {
+			for i := 0; i < increments; i++ {
+				unlock, err := Lock(path, 10*time.Second)
+				if err != nil {
+					done <- err
+					return
+				}
+
+				data, _ := ioutil.ReadFile(path)
+				count, _ := strconv.Atoi(string(data))
+				ioutil.WriteFile(path, []byte(strconv.Itoa(count+1)), 0644)
+				unlock()
+			}
+			done <- nil
+		}()
+	}
+
+	for indx := 0; indx < workers; indx++ {
+		if err := <-done; err != nil {
+			t.Fatalf("Lock returned error: %v", err)
+		}
+	}
+
+	data, _ := ioutil.ReadFile(path)
+	if string(data) != strconv.Itoa(workers*increments) {
+		t.Errorf("count = %s, want %d", data, workers*increments)
+	}
+}
@@ -0,0 +1,38 @@
+//go:build !windows
+// +build !windows
+
+package filelock
+
+import (
+	"os"
+	"syscall"
+)
+
+// tryLock locks the lock file, returning nil if another process holds it.
+func tryLock(lockPath string) (*os.File, error) {
+	f, err := os.OpenFile(lockPath, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		f.Close()
+		if err == syscall.EWOULDBLOCK || err == syscall.EINTR {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	// The file was removed by the process which held it before us
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if current, err := os.Stat(lockPath); err != nil || !os.SameFile(info, current) {
+		f.Close()
+		return nil, nil
+	}
+
+	return f, nil
+}
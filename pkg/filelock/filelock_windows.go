@@ -0,0 +1,30 @@
+package filelock
+
+import (
+	"os"
+	"syscall"
+)
+
+// errSharingViolation is returned when opening a file another process has
+// opened without sharing it.
+const errSharingViolation syscall.Errno = 32
+
+// tryLock opens the lock file without sharing it, returning nil if another
+// process has it open. The lock file can't be removed while it is open, so
+// it is left behind and reused.
+func tryLock(lockPath string) (*os.File, error) {
+	name, err := syscall.UTF16PtrFromString(lockPath)
+	if err != nil {
+		return nil, err
+	}
+
+	handle, err := syscall.CreateFile(name, syscall.GENERIC_READ|syscall.GENERIC_WRITE, 0, nil,
+		syscall.OPEN_ALWAYS, syscall.FILE_ATTRIBUTE_NORMAL, 0)
+	if err == errSharingViolation {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	return os.NewFile(uintptr(handle), lockPath), nil
+}
@@ -0,0 +1,136 @@
+package artifact
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path"
+	"strings"
+)
+
+// composerJSONLimit is the largest composer.json read from an archive.
+const composerJSONLimit = 1 << 20
+
+// archiveType returns the dist type of an archive from its name, or an
+// empty string for files which aren't archives.
+func archiveType(name string) string {
+	lower := strings.ToLower(name)
+
+	switch {
+	case strings.HasSuffix(lower, ".zip"):
+		return "zip"
+	case strings.HasSuffix(lower, ".tar"), strings.HasSuffix(lower, ".tar.gz"), strings.HasSuffix(lower, ".tgz"):
+		return "tar"
+	}
+
+	return ""
+}
+
+// isGzip reports whether a tar archive is compressed, from its name.
+func isGzip(name string) bool {
+	lower := strings.ToLower(name)
+	return strings.HasSuffix(lower, ".gz") || strings.HasSuffix(lower, ".tgz")
+}
+
+// composerJSONDepth returns the depth of a composer.json file in an
+// archive, or -1 for other files. Like Composer, the least nested
+// composer.json is used, since archives often wrap the package in a folder.
+func composerJSONDepth(name string) int {
+	name = strings.TrimPrefix(path.Clean("/"+name), "/")
+	if path.Base(name) != "composer.json" || strings.HasPrefix(name, "__MACOSX/") {
+		return -1
+	}
+
+	return strings.Count(name, "/")
+}
+
+// readComposerJSON returns the contents of the composer.json in an archive.
+func readComposerJSON(name string, data []byte) ([]byte, error) {
+	switch archiveType(name) {
+	case "zip":
+		return readZip(data)
+	case "tar":
+		return readTar(name, data)
+	}
+
+	return nil, fmt.Errorf("%q is not a zip or tar archive", name)
+}
+
+func readZip(data []byte) ([]byte, error) {
+	r, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, err
+	}
+
+	var found *zip.File
+	depth := -1
+	for _, f := range r.File {
+		d := composerJSONDepth(f.Name)
+		if d >= 0 && (found == nil || d < depth) {
+			found, depth = f, d
+		}
+	}
+
+	if found == nil {
+		return nil, fmt.Errorf("composer.json not found")
+	}
+
+	rc, err := found.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	return ioutil.ReadAll(io.LimitReader(rc, composerJSONLimit))
+}
+
+func readTar(name string, data []byte) ([]byte, error) {
+	var reader io.Reader = bytes.NewReader(data)
+	if isGzip(name) {
+		gz, err := gzip.NewReader(reader)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+
+		reader = gz
+	}
+
+	var found []byte
+	depth := -1
+
+	r := tar.NewReader(reader)
+	for {
+		header, err := r.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		if header.Typeflag != tar.TypeReg && header.Typeflag != tar.TypeRegA {
+			continue
+		}
+
+		d := composerJSONDepth(header.Name)
+		if d < 0 || (found != nil && d >= depth) {
+			continue
+		}
+
+		found, err = ioutil.ReadAll(io.LimitReader(r, composerJSONLimit))
+		if err != nil {
+			return nil, err
+		}
+		depth = d
+	}
+
+	if found == nil {
+		return nil, fmt.Errorf("composer.json not found")
+	}
+
+	return found, nil
+}
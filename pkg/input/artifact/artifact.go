@@ -0,0 +1,299 @@
+// Package artifact publishes packages from zip and tar archives, like
+// Composer's artifact repository.
+package artifact
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/zachomedia/composerrepo/pkg/composer"
+	"github.com/zachomedia/composerrepo/pkg/config/schema"
+	"github.com/zachomedia/composerrepo/pkg/logging"
+)
+
+type Config struct {
+	Dir    string                 `config:"dir"`
+	Output map[string]interface{} `config:"output"`
+	Prefix string                 `config:"prefix"`
+	URL    string                 `config:"url"`
+	Name   string                 `config:"name"`
+}
+
+// ArtifactInput reads the composer.json of each archive in a directory, or
+// under a prefix of an output, and publishes it with the archive as its dist.
+type ArtifactInput struct {
+	ID   string
+	Name string
+
+	// Dir is the directory containing the archives, when they're read from disk.
+	Dir string
+
+	// Output contains the archives under Prefix, when they're read from an output.
+	Output composer.Output
+	Prefix string
+
+	// URL is the base URL of the archives.
+	URL string
+
+	log       *logging.Logger
+	newOutput composer.OutputFactory
+}
+
+// SetLogger sets the logger of the input.
+func (input *ArtifactInput) SetLogger(log *logging.Logger) {
+	input.log = log
+}
+
+// SetOutputFactory sets the factory creating the output the archives are
+// read from.
+func (input *ArtifactInput) SetOutputFactory(factory composer.OutputFactory) {
+	input.newOutput = factory
+}
+
+func (input *ArtifactInput) Init(id string, conf map[string]interface{}) error {
+	config := Config{}
+	if err := schema.Decode(conf, &config); err != nil {
+		return err
+	}
+
+	input.ID = id
+	input.Name = config.Name
+	input.URL = strings.TrimSuffix(config.URL, "/")
+
+	switch {
+	case config.Dir != "" && config.Output != nil:
+		return &schema.FieldError{Path: "output", Message: "can't be used with dir"}
+
+	case config.Dir != "":
+		if config.Prefix != "" {
+			return &schema.FieldError{Path: "prefix", Message: "can only be used with output"}
+		}
+
+		dir, err := filepath.Abs(config.Dir)
+		if err != nil {
+			return &schema.FieldError{Path: "dir", Message: err.Error()}
+		}
+		input.Dir = dir
+
+		// Clients can't read the archives from the disk of the server
+		if input.URL == "" {
+			return &schema.FieldError{Path: "url", Message: "is required with dir"}
+		}
+
+	case config.Output != nil:
+		if input.newOutput == nil {
+			return errors.New("Unable to create the output, no output factory was set")
+		}
+
+		output, err := input.newOutput(config.Output)
+		if err != nil {
+			errs := schema.Errors{}
+			errs.Add("output", err)
+			return errs.Err()
+		}
+
+		if _, ok := output.(composer.Lister); !ok {
+			return &schema.FieldError{Path: "output", Message: "can't list files"}
+		}
+
+		input.Output = output
+		input.Prefix = config.Prefix
+
+		if input.URL == "" {
+			input.URL = strings.TrimSuffix(output.GetBasePath(), "/")
+		}
+		if input.URL == "" {
+			return &schema.FieldError{Path: "url", Message: "is required when the output has no basePath"}
+		}
+
+	default:
+		return &schema.FieldError{Path: "dir", Message: "one of dir or output is required"}
+	}
+
+	return nil
+}
+
+// Check confirms the archives can be listed.
+func (input *ArtifactInput) Check() error {
+	_, err := input.listArchives(context.Background())
+	return err
+}
+
+func (input *ArtifactInput) GetID() string {
+	return input.ID
+}
+
+func (input *ArtifactInput) GetName() string {
+	if input.Name != "" {
+		return input.Name
+	}
+
+	if input.Dir != "" {
+		return input.Dir
+	}
+
+	return input.URL
+}
+
+// listArchives returns the names of the archives, relative to Dir or the
+// root of Output, in sorted order.
+func (input *ArtifactInput) listArchives(ctx context.Context) ([]string, error) {
+	var names []string
+
+	if input.Output != nil {
+		var err error
+		names, err = input.Output.(composer.Lister).List(ctx, input.Prefix)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		err := filepath.Walk(input.Dir, func(fPath string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+
+			if info.IsDir() {
+				return nil
+			}
+
+			rel, err := filepath.Rel(input.Dir, fPath)
+			if err != nil {
+				return err
+			}
+
+			names = append(names, filepath.ToSlash(rel))
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	archives := make([]string, 0, len(names))
+	for _, name := range names {
+		if archiveType(name) != "" {
+			archives = append(archives, name)
+		}
+	}
+	sort.Strings(archives)
+
+	return archives, nil
+}
+
+// readArchive returns the contents of an archive.
+func (input *ArtifactInput) readArchive(ctx context.Context, name string) ([]byte, error) {
+	if input.Output != nil {
+		return input.Output.Get(ctx, name)
+	}
+
+	return ioutil.ReadFile(filepath.Join(input.Dir, filepath.FromSlash(name)))
+}
+
+// distURL returns the URL an archive is downloaded from, escaping each
+// segment of its name.
+func (input *ArtifactInput) distURL(name string) string {
+	segments := strings.Split(name, "/")
+	for indx, segment := range segments {
+		segments[indx] = url.PathEscape(segment)
+	}
+
+	return input.URL + "/" + strings.Join(segments, "/")
+}
+
+// loadArchive builds the package published by an archive.
+func (input *ArtifactInput) loadArchive(ctx context.Context, name string) (*composer.Package, error) {
+	data, err := input.readArchive(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	composerJSON, err := readComposerJSON(name, data)
+	if err != nil {
+		return nil, err
+	}
+
+	pkg := &composer.Package{}
+	if err := json.Unmarshal(composerJSON, pkg); err != nil {
+		return nil, fmt.Errorf("Unable to read composer.json: %v", err)
+	}
+
+	if pkg.Name == "" {
+		return nil, fmt.Errorf("composer.json has no name")
+	}
+
+	if pkg.Version == "" {
+		return nil, fmt.Errorf("composer.json has no version")
+	}
+
+	shasum := fmt.Sprintf("%x", sha1.Sum(data))
+	pkg.Dist = &composer.Dist{
+		URL:       input.distURL(name),
+		Type:      archiveType(name),
+		Reference: shasum,
+		Shasum:    shasum,
+	}
+	pkg.Source = nil
+
+	return pkg, nil
+}
+
+// GetPackages reads every archive. Archives which can't be read are
+// skipped, and when two archives contain the same version, the first in
+// sorted order is used.
+func (input *ArtifactInput) GetPackages(ctx context.Context) (composer.Packages, error) {
+	log := input.log.WithContext(ctx)
+
+	archives, err := input.listArchives(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	packages := make(composer.Packages)
+	for _, name := range archives {
+		log.Debug("Reading archive", "archive", name)
+
+		pkg, err := input.loadArchive(ctx, name)
+		if err != nil {
+			log.Warn("Skipping archive", "archive", name, "error", err)
+			continue
+		}
+
+		if packages[pkg.Name] == nil {
+			packages[pkg.Name] = make(composer.PackageVersions)
+		}
+
+		if existing, ok := packages[pkg.Name][pkg.Version]; ok {
+			log.Warn("Skipping archive, as the version is in another archive", "archive", name, "package", pkg.Name, "version", pkg.Version, "existing", path.Base(existing.Dist.URL))
+			continue
+		}
+
+		packages[pkg.Name][pkg.Version] = pkg
+	}
+
+	return packages, nil
+}
+
+// GetPackage returns the versions of a package found in the archives.
+func (input *ArtifactInput) GetPackage(ctx context.Context, packageName string) (composer.PackageVersions, error) {
+	pkgs, err := input.GetPackages(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	versions, ok := pkgs[packageName]
+	if !ok {
+		return nil, fmt.Errorf("Package %q is not in the archives of input %q", packageName, input.ID)
+	}
+
+	return versions, nil
+}
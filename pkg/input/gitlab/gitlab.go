@@ -1,37 +1,82 @@
 package gitlab
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
 	"net/url"
 	"regexp"
 	"strings"
 
 	gogitlab "github.com/xanzy/go-gitlab"
 	"github.com/zachomedia/composerrepo/pkg/composer"
+	"github.com/zachomedia/composerrepo/pkg/config/schema"
+	"github.com/zachomedia/composerrepo/pkg/logging"
+	"github.com/zachomedia/composerrepo/pkg/transformer/versionmap"
 )
 
+// validVersion matches the tags published as versions.
+var validVersion = regexp.MustCompile(`^v?\d+\.\d+(\.\d+)?(-(dev|p|patch|a|alpha|b|beta|RC|rc)\d*)?$`)
+
+type Config struct {
+	URL            string `config:"url,required"`
+	Token          string `config:"token,required,secret"`
+	Group          string `config:"group,required"`
+	DrupalVersions bool   `config:"drupalVersions"`
+}
+
 type GitLabInput struct {
-	ID     string
-	Client *gogitlab.Client
-	Group  *gogitlab.Group
+	ID        string
+	Client    *gogitlab.Client
+	GroupPath string
+	Group     *gogitlab.Group
+
+	// DrupalVersions converts Drupal contrib tags, such as 7.x-1.2, to
+	// the versions they release.
+	DrupalVersions bool
+
+	log *logging.Logger
+}
+
+// SetLogger sets the logger of the input.
+func (input *GitLabInput) SetLogger(log *logging.Logger) {
+	input.log = log
 }
 
 func (input *GitLabInput) Init(id string, conf map[string]interface{}) error {
+	config := Config{DrupalVersions: true}
+	if err := schema.Decode(conf, &config); err != nil {
+		return err
+	}
+
 	input.ID = id
-	input.Client = gogitlab.NewClient(nil, conf["token"].(string))
-	input.Client.SetBaseURL(conf["url"].(string))
+	input.Client = gogitlab.NewClient(nil, config.Token)
+	input.GroupPath = config.Group
+	input.DrupalVersions = config.DrupalVersions
+
+	return input.Client.SetBaseURL(config.URL)
+}
 
-	group, _, err := input.Client.Groups.GetGroup(conf["group"].(string))
+// getGroup loads the group on first use.
+func (input *GitLabInput) getGroup() (*gogitlab.Group, error) {
+	if input.Group != nil {
+		return input.Group, nil
+	}
+
+	group, _, err := input.Client.Groups.GetGroup(input.GroupPath)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	input.Group = group
+	return group, nil
+}
 
-	return nil
+// Check confirms the group can be loaded with the configured token.
+func (input *GitLabInput) Check() error {
+	_, err := input.getGroup()
+	return err
 }
 
 func (input *GitLabInput) GetID() string {
@@ -39,12 +84,22 @@ func (input *GitLabInput) GetID() string {
 }
 
 func (input *GitLabInput) GetName() string {
-	return strings.Replace(strings.ToLower(input.Group.FullPath), "/", "-", -1)
+	group, err := input.getGroup()
+	if err != nil {
+		return strings.Replace(strings.ToLower(input.GroupPath), "/", "-", -1)
+	}
+
+	return strings.Replace(strings.ToLower(group.FullPath), "/", "-", -1)
 }
 
 func (input *GitLabInput) getProjects() ([]*gogitlab.Project, error) {
 	projects := make([]*gogitlab.Project, 0)
 
+	group, err := input.getGroup()
+	if err != nil {
+		return nil, err
+	}
+
 	simple := true
 	opts := &gogitlab.ListGroupProjectsOptions{
 		Simple: &simple,
@@ -54,14 +109,14 @@ func (input *GitLabInput) getProjects() ([]*gogitlab.Project, error) {
 		},
 	}
 
-	inProjects, res, err := input.Client.Groups.ListGroupProjects(input.Group.ID, opts)
+	inProjects, res, err := input.Client.Groups.ListGroupProjects(group.ID, opts)
 	projects = append(projects, inProjects...)
 	if err != nil {
 		return nil, err
 	}
 	for page := 2; page <= res.TotalPages; page++ {
 		opts.ListOptions.Page = page
-		inProjects, _, err := input.Client.Groups.ListGroupProjects(input.Group.ID, opts)
+		inProjects, _, err := input.Client.Groups.ListGroupProjects(group.ID, opts)
 		if err != nil {
 			return nil, err
 		}
@@ -151,7 +206,8 @@ func (input *GitLabInput) getRefPackage(project *gogitlab.Project, ref string) (
 	return &pkg, nil
 }
 
-func (input *GitLabInput) getProjectVersions(project *gogitlab.Project) (map[string]*composer.Package, error) {
+func (input *GitLabInput) getProjectVersions(ctx context.Context, project *gogitlab.Project) (map[string]*composer.Package, error) {
+	log := input.log.WithContext(ctx)
 	versions := make(map[string]*composer.Package)
 
 	refs, err := input.getProjectRefs(project)
@@ -221,28 +277,18 @@ func (input *GitLabInput) getProjectVersions(project *gogitlab.Project) (map[str
 				Type: "tar",
 			}
 
-			// Convert Drupal version number to valid version number
-			r, err := regexp.Compile("\\d+\\.x-(\\d+\\.\\d+(-.*)?)")
-			if err != nil {
-				return nil, err
-			}
-
-			versionMatch := r.FindStringSubmatch(pkg.Version)
-			if len(versionMatch) > 0 {
-				log.Printf("Changing version %q to %q", pkg.Version, versionMatch[1])
-				pkg.Version = versionMatch[1]
+			if input.DrupalVersions {
+				if version, ok := (&versionmap.DrupalMapper{}).Map(pkg.Version); ok {
+					log.Debug("Converting Drupal version", "tag", pkg.Version, "version", version)
+					pkg.Version = version
+				}
 			}
 
 			// Confirm we have a valid version
-			match, err := regexp.MatchString("v?\\d+\\.\\d+(\\.\\d+)?(-(dev|p|patch|a|alpha|b|beta|RC|rc)\\d*)?", pkg.Version)
-			if err != nil {
-				return nil, err
-			}
-
-			if match {
+			if validVersion.MatchString(pkg.Version) {
 				versions[pkg.Version] = pkg
 			} else {
-				log.Printf("Skipping tag %q as it is not a valid version number", pkg.Version)
+				log.Warn("Skipping tag, as it is not a valid version number", "tag", pkg.Version)
 			}
 		} else {
 			return nil, errors.New("Unknown ref type")
@@ -252,7 +298,7 @@ func (input *GitLabInput) getProjectVersions(project *gogitlab.Project) (map[str
 	return versions, nil
 }
 
-func (input *GitLabInput) GetPackages() (composer.Packages, error) {
+func (input *GitLabInput) GetPackages(ctx context.Context) (composer.Packages, error) {
 	packages := make(composer.Packages)
 
 	projects, err := input.getProjects()
@@ -261,9 +307,9 @@ func (input *GitLabInput) GetPackages() (composer.Packages, error) {
 	}
 
 	for _, project := range projects {
-		log.Printf("Loading %q", getComposerName(project))
+		input.log.WithContext(ctx).Info("Loading package", "package", getComposerName(project))
 
-		versions, err := input.getProjectVersions(project)
+		versions, err := input.getProjectVersions(ctx, project)
 		if err != nil {
 			return nil, err
 		}
@@ -274,11 +320,11 @@ func (input *GitLabInput) GetPackages() (composer.Packages, error) {
 	return packages, nil
 }
 
-func (input *GitLabInput) GetPackage(packageName string) (composer.PackageVersions, error) {
+func (input *GitLabInput) GetPackage(ctx context.Context, packageName string) (composer.PackageVersions, error) {
 	project, _, err := input.Client.Projects.GetProject(packageName)
 	if err != nil {
 		return nil, err
 	}
 
-	return input.getProjectVersions(project)
+	return input.getProjectVersions(ctx, project)
 }
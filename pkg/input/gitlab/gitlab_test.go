@@ -0,0 +1,27 @@
+package gitlab
+
+import "testing"
+
+func TestValidVersion(t *testing.T) {
+	tests := []struct {
+		version string
+		valid   bool
+	}{
+		{"1.2", true},
+		{"v1.2.3", true},
+		{"1.2.3-beta2", true},
+		{"1.2-RC1", true},
+		{"2.0.0-p1", true},
+		{"7.x-1.2", false},
+		{"foo1.2bar", false},
+		{"1.2.3.4", false},
+		{"1.2-feature", false},
+		{"release-1.2", false},
+	}
+
+	for _, test := range tests {
+		if got := validVersion.MatchString(test.version); got != test.valid {
+			t.Errorf("validVersion matches %q = %t, want %t", test.version, got, test.valid)
+		}
+	}
+}
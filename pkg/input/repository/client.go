@@ -0,0 +1,304 @@
+package repository
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/zachomedia/composerrepo/pkg/composer"
+)
+
+// errNotFound is returned for packages the upstream repository doesn't have.
+var errNotFound = fmt.Errorf("not found")
+
+// upstreamRepository is the packages.json of an upstream repository.
+type upstreamRepository struct {
+	Packages         packageList                    `json:"packages"`
+	MetadataURL      string                         `json:"metadata-url"`
+	ProvidersURL     string                         `json:"providers-url"`
+	ProviderIncludes map[string]*composer.Reference `json:"provider-includes"`
+	Providers        map[string]*composer.Reference `json:"providers"`
+}
+
+// packageList is the packages of a repository. Repositories without any,
+// such as Packagist, list them as an empty array.
+type packageList composer.Packages
+
+func (list *packageList) UnmarshalJSON(data []byte) error {
+	if strings.HasPrefix(strings.TrimSpace(string(data)), "[") {
+		*list = make(packageList)
+		return nil
+	}
+
+	return json.Unmarshal(data, (*composer.Packages)(list))
+}
+
+// client reads packages from an upstream Composer repository, using the
+// Composer 2 metadata-url when available, and otherwise the providers or
+// packages of packages.json.
+type client struct {
+	BaseURL *url.URL
+	HTTP    *http.Client
+
+	repo      *upstreamRepository
+	providers map[string]*composer.Reference
+}
+
+func newClient(baseURL string) (*client, error) {
+	u, err := url.Parse(strings.TrimSuffix(baseURL, "/") + "/")
+	if err != nil {
+		return nil, err
+	}
+
+	// file:// URLs read a repository from disk, such as a local stand-in.
+	// Only a repository configured as one may read files, so a remote
+	// repository can't publish local files by referencing them.
+	transport := &http.Transport{Proxy: http.ProxyFromEnvironment}
+	if u.Scheme == "file" {
+		transport.RegisterProtocol("file", http.NewFileTransport(http.Dir("/")))
+	}
+
+	return &client{
+		BaseURL: u,
+		HTTP: &http.Client{
+			Transport:     transport,
+			CheckRedirect: checkRedirect,
+			Timeout:       60 * time.Second,
+		},
+	}, nil
+}
+
+// checkRedirect refuses redirects which change the scheme, such as from
+// https:// to file://, and stops after 10 redirects like the default.
+func checkRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= 10 {
+		return fmt.Errorf("stopped after 10 redirects")
+	}
+
+	if from := via[len(via)-1].URL.Scheme; req.URL.Scheme != from {
+		return fmt.Errorf("refusing redirect from %s:// to %s://", from, req.URL.Scheme)
+	}
+
+	return nil
+}
+
+// resolve returns the URL of ref. Like Composer, paths starting with a
+// slash are relative to the host, or to the repository for file:// URLs.
+func (c *client) resolve(ref string) (string, error) {
+	if c.BaseURL.Scheme == "file" && strings.HasPrefix(ref, "/") {
+		u := *c.BaseURL
+		u.Path = path.Join(u.Path, ref)
+		return u.String(), nil
+	}
+
+	u, err := c.BaseURL.Parse(ref)
+	if err != nil {
+		return "", err
+	}
+
+	return u.String(), nil
+}
+
+// getJSON fetches ref and decodes it into v.
+func (c *client) getJSON(ref string, v interface{}) error {
+	u, err := c.resolve(ref)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.HTTP.Get(u)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return errNotFound
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Unable to fetch %q: %s", u, resp.Status)
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if err := json.Unmarshal(body, v); err != nil {
+		return fmt.Errorf("Unable to read %q: %v", u, err)
+	}
+
+	return nil
+}
+
+// getRepository loads packages.json on first use.
+func (c *client) getRepository() (*upstreamRepository, error) {
+	if c.repo != nil {
+		return c.repo, nil
+	}
+
+	repo := &upstreamRepository{}
+	if err := c.getJSON("packages.json", repo); err != nil {
+		return nil, err
+	}
+
+	c.repo = repo
+	return repo, nil
+}
+
+// getProviders loads the provider hashes of a Composer 1 repository on first use.
+func (c *client) getProviders(repo *upstreamRepository) (map[string]*composer.Reference, error) {
+	if c.providers != nil {
+		return c.providers, nil
+	}
+
+	providers := make(map[string]*composer.Reference)
+	for name, ref := range repo.Providers {
+		providers[name] = ref
+	}
+
+	for include, ref := range repo.ProviderIncludes {
+		provider := &upstreamRepository{}
+		if err := c.getJSON(strings.Replace(include, "%hash%", ref.SHA256, -1), provider); err != nil {
+			return nil, err
+		}
+
+		for name, ref := range provider.Providers {
+			providers[name] = ref
+		}
+	}
+
+	c.providers = providers
+	return providers, nil
+}
+
+// GetPackage returns the versions of a package, or errNotFound.
+func (c *client) GetPackage(name string, dev bool) (composer.PackageVersions, error) {
+	repo, err := c.getRepository()
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case repo.MetadataURL != "":
+		versions, err := c.getMetadata(repo.MetadataURL, name)
+		if err != nil {
+			return nil, err
+		}
+
+		if dev {
+			devVersions, err := c.getMetadata(repo.MetadataURL, name+"~dev")
+			if err != nil && err != errNotFound {
+				return nil, err
+			}
+
+			for version, pkg := range devVersions {
+				versions[version] = pkg
+			}
+		}
+
+		return versions, nil
+
+	case repo.ProvidersURL != "":
+		providers, err := c.getProviders(repo)
+		if err != nil {
+			return nil, err
+		}
+
+		ref, ok := providers[name]
+		if !ok {
+			return nil, errNotFound
+		}
+
+		providerURL := strings.Replace(strings.Replace(repo.ProvidersURL, "%package%", name, -1), "%hash%", ref.SHA256, -1)
+		pkgRepo := &upstreamRepository{}
+		if err := c.getJSON(providerURL, pkgRepo); err != nil {
+			return nil, err
+		}
+
+		versions, ok := pkgRepo.Packages[name]
+		if !ok {
+			return nil, errNotFound
+		}
+		return versions, nil
+	}
+
+	versions, ok := repo.Packages[name]
+	if !ok {
+		return nil, errNotFound
+	}
+
+	return versions, nil
+}
+
+// getMetadata reads a Composer 2 metadata file, expanding minified versions.
+func (c *client) getMetadata(metadataURL string, name string) (composer.PackageVersions, error) {
+	metadata := struct {
+		Packages map[string][]map[string]interface{} `json:"packages"`
+		Minified string                              `json:"minified"`
+	}{}
+
+	if err := c.getJSON(strings.Replace(metadataURL, "%package%", name, -1), &metadata); err != nil {
+		return nil, err
+	}
+
+	pkgName := strings.TrimSuffix(name, "~dev")
+	entries, ok := metadata.Packages[pkgName]
+	if !ok {
+		return nil, errNotFound
+	}
+
+	if metadata.Minified == "composer/2.0" {
+		entries = expandMinified(entries)
+	}
+
+	versions := make(composer.PackageVersions)
+	for _, entry := range entries {
+		b, err := json.Marshal(entry)
+		if err != nil {
+			return nil, err
+		}
+
+		pkg := &composer.Package{}
+		if err := json.Unmarshal(b, pkg); err != nil {
+			return nil, fmt.Errorf("Unable to read %s: %v", pkgName, err)
+		}
+
+		versions[pkg.Version] = pkg
+	}
+
+	return versions, nil
+}
+
+// expandMinified expands the versions of a minified metadata file, where
+// each version only lists the fields which differ from the previous one.
+func expandMinified(entries []map[string]interface{}) []map[string]interface{} {
+	expanded := make([]map[string]interface{}, len(entries))
+
+	var previous map[string]interface{}
+	for indx, entry := range entries {
+		current := make(map[string]interface{}, len(previous)+len(entry))
+		for k, v := range previous {
+			current[k] = v
+		}
+
+		for k, v := range entry {
+			if v == "__unset" {
+				delete(current, k)
+			} else {
+				current[k] = v
+			}
+		}
+
+		expanded[indx] = current
+		previous = current
+	}
+
+	return expanded
+}
@@ -0,0 +1,393 @@
+// Package repository mirrors packages from an upstream Composer repository,
+// such as Packagist.
+package repository
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/zachomedia/composerrepo/pkg/composer"
+	"github.com/zachomedia/composerrepo/pkg/config/schema"
+	"github.com/zachomedia/composerrepo/pkg/logging"
+	"github.com/zachomedia/composerrepo/pkg/semver"
+)
+
+type Config struct {
+	URL              string            `config:"url,required"`
+	Name             string            `config:"name"`
+	Packages         map[string]string `config:"packages"`
+	DependenciesOf   []string          `config:"dependenciesOf"`
+	Dependencies     bool              `config:"dependencies"`
+	MinimumStability string            `config:"minimumStability"`
+}
+
+// ComposerRepositoryInput mirrors an explicit list of packages, and the
+// requirements of the packages of other inputs, from an upstream repository.
+type ComposerRepositoryInput struct {
+	ID     string
+	Name   string
+	Client *client
+
+	// Packages are the constraints of the packages to mirror.
+	Packages map[string]string
+
+	// DependenciesOf are the IDs of inputs whose requirements are mirrored.
+	DependenciesOf []string
+	Inputs         []composer.Input
+
+	// Dependencies also mirrors the requirements of mirrored packages.
+	Dependencies bool
+
+	MinimumStability semver.Stability
+
+	log *logging.Logger
+
+	// constraints are the constraints of every requirement found by the
+	// last GetPackages, so GetPackage can resolve a single package.
+	mux         sync.Mutex
+	constraints map[string][]semver.Constraint
+}
+
+// SetLogger sets the logger of the input.
+func (input *ComposerRepositoryInput) SetLogger(log *logging.Logger) {
+	input.log = log
+}
+
+func (input *ComposerRepositoryInput) Init(id string, conf map[string]interface{}) error {
+	config := Config{
+		MinimumStability: "stable",
+	}
+	if err := schema.Decode(conf, &config); err != nil {
+		return err
+	}
+
+	client, err := newClient(config.URL)
+	if err != nil {
+		return &schema.FieldError{Path: "url", Message: err.Error()}
+	}
+
+	stability, err := semver.ParseStability(config.MinimumStability)
+	if err != nil {
+		return &schema.FieldError{Path: "minimumStability", Message: err.Error()}
+	}
+
+	errs := schema.Errors{}
+	for _, name := range sortedKeys(config.Packages) {
+		constraint := config.Packages[name]
+		if constraint == "" {
+			config.Packages[name] = "*"
+		} else if _, err := semver.ParseConstraint(constraint); err != nil {
+			errs.Add(schema.JoinPath("packages", name), err)
+		}
+	}
+	if err := errs.Err(); err != nil {
+		return err
+	}
+
+	if len(config.Packages) == 0 && len(config.DependenciesOf) == 0 {
+		return &schema.FieldError{Path: "packages", Message: "one of packages or dependenciesOf is required"}
+	}
+
+	input.ID = id
+	input.Name = config.Name
+	input.Client = client
+	input.Packages = config.Packages
+	input.DependenciesOf = config.DependenciesOf
+	input.Dependencies = config.Dependencies
+	input.MinimumStability = stability
+
+	return nil
+}
+
+// SetInputs finds the inputs whose requirements are mirrored.
+func (input *ComposerRepositoryInput) SetInputs(inputs map[string]composer.Input) error {
+	errs := schema.Errors{}
+
+	input.Inputs = make([]composer.Input, 0, len(input.DependenciesOf))
+	for indx, id := range input.DependenciesOf {
+		other, ok := inputs[id]
+		if !ok || id == input.ID {
+			errs.Add(fmt.Sprintf("dependenciesOf[%d]", indx), fmt.Errorf("unknown input %q", id))
+			continue
+		}
+
+		input.Inputs = append(input.Inputs, other)
+	}
+
+	return errs.Err()
+}
+
+// Check confirms the upstream packages.json can be read.
+func (input *ComposerRepositoryInput) Check() error {
+	_, err := input.Client.getRepository()
+	return err
+}
+
+func (input *ComposerRepositoryInput) GetID() string {
+	return input.ID
+}
+
+func (input *ComposerRepositoryInput) GetName() string {
+	if input.Name != "" {
+		return input.Name
+	}
+
+	return input.Client.BaseURL.String()
+}
+
+// requirement is a package to mirror and the constraints its versions must match.
+type requirement struct {
+	name        string
+	constraints []semver.Constraint
+}
+
+// GetPackages returns the mirrored packages.
+//
+// The requirements of the dependent inputs, and of mirrored packages if
+// Dependencies is set, are followed until every requirement is mirrored.
+// Packages provided by the dependent inputs aren't mirrored.
+func (input *ComposerRepositoryInput) GetPackages(ctx context.Context) (composer.Packages, error) {
+	log := input.log.WithContext(ctx)
+
+	own := make(map[string]bool)
+	queue := make([]*requirement, 0)
+	pending := make(map[string]*requirement)
+	constraints := make(map[string][]semver.Constraint)
+
+	require := func(name string, constraint string) {
+		name = strings.ToLower(name)
+		if composer.IsPlatformPackage(name) || own[name] {
+			return
+		}
+
+		c, err := semver.ParseConstraint(constraint)
+		if err != nil {
+			log.Warn("Skipping requirement", "package", name, "constraint", constraint, "error", err)
+			return
+		}
+		constraints[name] = append(constraints[name], c)
+
+		if req, ok := pending[name]; ok {
+			req.constraints = append(req.constraints, c)
+			return
+		}
+
+		req := &requirement{name: name, constraints: []semver.Constraint{c}}
+		pending[name] = req
+		queue = append(queue, req)
+	}
+
+	// Packages of the dependent inputs are ours, so they're never mirrored
+	dependentPackages := make([]composer.Packages, 0, len(input.Inputs))
+	for _, other := range input.Inputs {
+		log.Info("Loading the requirements of a dependent input", "dependent", other.GetID())
+
+		pkgs, err := other.GetPackages(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		for name := range pkgs {
+			own[strings.ToLower(name)] = true
+		}
+		dependentPackages = append(dependentPackages, pkgs)
+	}
+
+	for _, name := range sortedKeys(input.Packages) {
+		require(name, input.Packages[name])
+	}
+
+	for _, pkgs := range dependentPackages {
+		for _, name := range sortedPackageNames(pkgs) {
+			for _, version := range pkgs[name] {
+				for _, target := range sortedKeys(version.Require) {
+					require(target, version.Require[target])
+				}
+			}
+		}
+	}
+
+	upstream := make(map[string]composer.PackageVersions)
+	selected := make(map[string]map[string]bool)
+
+	// Requirements are processed until no new versions are selected, since a
+	// package may be required again with a different constraint.
+	for len(queue) > 0 {
+		req := queue[0]
+		queue = queue[1:]
+		delete(pending, req.name)
+
+		versions, ok := upstream[req.name]
+		if !ok {
+			log.Info("Loading package", "package", req.name)
+
+			var err error
+			versions, err = input.Client.GetPackage(req.name, input.MinimumStability == semver.StabilityDev)
+			if err == errNotFound {
+				log.Warn("Skipping package, as it is not in the upstream repository", "package", req.name)
+				versions = make(composer.PackageVersions)
+			} else if err != nil {
+				return nil, err
+			}
+			upstream[req.name] = versions
+		}
+
+		if selected[req.name] == nil {
+			selected[req.name] = make(map[string]bool)
+		}
+
+		for _, version := range sortedVersions(versions) {
+			if selected[req.name][version] || !input.matches(version, req.constraints) {
+				continue
+			}
+			selected[req.name][version] = true
+
+			if input.Dependencies {
+				pkg := versions[version]
+				for _, target := range sortedKeys(pkg.Require) {
+					require(target, pkg.Require[target])
+				}
+			}
+		}
+	}
+
+	input.mux.Lock()
+	input.constraints = constraints
+	input.mux.Unlock()
+
+	packages := make(composer.Packages)
+	for name, versions := range selected {
+		if len(versions) == 0 {
+			continue
+		}
+
+		packages[name] = make(composer.PackageVersions)
+		for version := range versions {
+			packages[name][version] = upstream[name][version]
+		}
+	}
+
+	return packages, nil
+}
+
+// matches reports whether version is stable enough and matches any of constraints.
+func (input *ComposerRepositoryInput) matches(version string, constraints []semver.Constraint) bool {
+	v, err := semver.Parse(version)
+	if err != nil || v.Stability < input.MinimumStability {
+		return false
+	}
+
+	for _, constraint := range constraints {
+		if constraint.Matches(v) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// GetPackage returns the mirrored versions of a package, fetching only that
+// package from upstream. New requirements of the versions are mirrored by
+// the next generation.
+func (input *ComposerRepositoryInput) GetPackage(ctx context.Context, packageName string) (composer.PackageVersions, error) {
+	name := strings.ToLower(packageName)
+
+	constraints, err := input.requirementConstraints(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if len(constraints) == 0 {
+		return nil, fmt.Errorf("Package %q is not mirrored by input %q", packageName, input.ID)
+	}
+
+	input.log.WithContext(ctx).Info("Loading package", "package", name)
+
+	upstream, err := input.Client.GetPackage(name, input.MinimumStability == semver.StabilityDev)
+	if err == errNotFound {
+		return nil, fmt.Errorf("Package %q is not in the upstream repository of input %q", packageName, input.ID)
+	} else if err != nil {
+		return nil, err
+	}
+
+	versions := make(composer.PackageVersions)
+	for version, pkg := range upstream {
+		if input.matches(version, constraints) {
+			versions[version] = pkg
+		}
+	}
+
+	if len(versions) == 0 {
+		return nil, fmt.Errorf("Package %q has no versions matching the requirements of input %q", packageName, input.ID)
+	}
+
+	return versions, nil
+}
+
+// requirementConstraints returns the constraints the versions of a package
+// must match. Unless the package is only required by the packages
+// listed in the config, these come from the requirements found by the last
+// GetPackages, which is run first if there was none.
+func (input *ComposerRepositoryInput) requirementConstraints(ctx context.Context, name string) ([]semver.Constraint, error) {
+	if len(input.DependenciesOf) == 0 && !input.Dependencies {
+		for pkgName, constraint := range input.Packages {
+			if strings.ToLower(pkgName) == name {
+				c, err := semver.ParseConstraint(constraint)
+				if err != nil {
+					return nil, err
+				}
+				return []semver.Constraint{c}, nil
+			}
+		}
+
+		return nil, nil
+	}
+
+	input.mux.Lock()
+	constraints := input.constraints
+	input.mux.Unlock()
+
+	if constraints == nil {
+		if _, err := input.GetPackages(ctx); err != nil {
+			return nil, err
+		}
+
+		input.mux.Lock()
+		constraints = input.constraints
+		input.mux.Unlock()
+	}
+
+	return constraints[name], nil
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+func sortedPackageNames(pkgs composer.Packages) []string {
+	names := make([]string, 0, len(pkgs))
+	for name := range pkgs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+func sortedVersions(versions composer.PackageVersions) []string {
+	result := make([]string, 0, len(versions))
+	for version := range versions {
+		result = append(result, version)
+	}
+	sort.Strings(result)
+
+	return result
+}
@@ -0,0 +1,301 @@
+// Package logging writes leveled, structured logs as logfmt or JSON lines.
+//
+// A Logger carries fields, such as the input a message is about or the
+// correlation ID of the job or request it belongs to:
+//
+//	log := logging.Default().With("input", "gitlab")
+//	log.Info("Loading package", "package", name)
+//
+// writes
+//
+//	time=2019-06-01T12:00:00Z level=info msg="Loading package" input=gitlab package=acme/widget
+//
+// Secret config values are redacted from every line.
+package logging
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zachomedia/composerrepo/pkg/redact"
+)
+
+// Level is the severity of a message.
+type Level int
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var levelNames = []string{"debug", "info", "warn", "error"}
+
+func (level Level) String() string {
+	if level < LevelDebug || level > LevelError {
+		return fmt.Sprintf("level(%d)", int(level))
+	}
+
+	return levelNames[level]
+}
+
+// ParseLevel parses the name of a level, such as "info".
+func ParseLevel(s string) (Level, error) {
+	for indx, name := range levelNames {
+		if strings.EqualFold(s, name) || (name == "warn" && strings.EqualFold(s, "warning")) {
+			return Level(indx), nil
+		}
+	}
+
+	return LevelInfo, fmt.Errorf("unknown log level %q, expected one of %s", s, strings.Join(levelNames, ", "))
+}
+
+// The formats lines can be written in.
+const (
+	FormatLogfmt = "logfmt"
+	FormatJSON   = "json"
+)
+
+// sink is where the loggers derived from one another write.
+type sink struct {
+	mux    sync.Mutex
+	w      io.Writer
+	level  Level
+	format string
+}
+
+// Logger writes messages at or above its level, with its fields.
+type Logger struct {
+	sink   *sink
+	fields []interface{}
+}
+
+// New returns a logger writing messages at or above level to w, in format.
+func New(w io.Writer, level Level, format string) (*Logger, error) {
+	if format != FormatLogfmt && format != FormatJSON {
+		return nil, fmt.Errorf("unknown log format %q, expected %s or %s", format, FormatLogfmt, FormatJSON)
+	}
+
+	return &Logger{sink: &sink{w: w, level: level, format: format}}, nil
+}
+
+var (
+	defaultMux    sync.RWMutex
+	defaultLogger = &Logger{sink: &sink{w: os.Stderr, level: LevelInfo, format: FormatLogfmt}}
+)
+
+// Default returns the logger configured with SetDefault, which writes info
+// messages to stderr as logfmt until then.
+func Default() *Logger {
+	defaultMux.RLock()
+	defer defaultMux.RUnlock()
+
+	return defaultLogger
+}
+
+// SetDefault replaces the logger returned by Default.
+func SetDefault(logger *Logger) {
+	defaultMux.Lock()
+	defer defaultMux.Unlock()
+
+	defaultLogger = logger
+}
+
+// orDefault lets methods be called on a nil logger, such as the logger of
+// a component which was never given one.
+func (logger *Logger) orDefault() *Logger {
+	if logger == nil {
+		return Default()
+	}
+
+	return logger
+}
+
+// With returns a logger which adds fields, given as alternating keys and
+// values, to every message.
+func (logger *Logger) With(keyvals ...interface{}) *Logger {
+	logger = logger.orDefault()
+
+	fields := make([]interface{}, 0, len(logger.fields)+len(keyvals))
+	fields = append(fields, logger.fields...)
+	fields = append(fields, keyvals...)
+
+	return &Logger{sink: logger.sink, fields: fields}
+}
+
+// Enabled reports whether messages at level are written.
+func (logger *Logger) Enabled(level Level) bool {
+	return level >= logger.orDefault().sink.level
+}
+
+func (logger *Logger) Debug(msg string, keyvals ...interface{}) {
+	logger.orDefault().write(LevelDebug, msg, keyvals)
+}
+
+func (logger *Logger) Info(msg string, keyvals ...interface{}) {
+	logger.orDefault().write(LevelInfo, msg, keyvals)
+}
+
+func (logger *Logger) Warn(msg string, keyvals ...interface{}) {
+	logger.orDefault().write(LevelWarn, msg, keyvals)
+}
+
+func (logger *Logger) Error(msg string, keyvals ...interface{}) {
+	logger.orDefault().write(LevelError, msg, keyvals)
+}
+
+func (logger *Logger) write(level Level, msg string, keyvals []interface{}) {
+	if level < logger.sink.level {
+		return
+	}
+
+	all := make([]interface{}, 0, 6+len(logger.fields)+len(keyvals))
+	all = append(all, "time", time.Now().UTC().Format(time.RFC3339Nano), "level", level.String(), "msg", msg)
+	all = append(all, logger.fields...)
+	all = append(all, keyvals...)
+	if len(all)%2 != 0 {
+		all = append(all, "(missing)")
+	}
+
+	var line string
+	if logger.sink.format == FormatJSON {
+		line = formatJSON(all)
+	} else {
+		line = formatLogfmt(all)
+	}
+
+	logger.sink.mux.Lock()
+	defer logger.sink.mux.Unlock()
+
+	io.WriteString(logger.sink.w, redact.String(line)+"\n")
+}
+
+// value returns v as it is written, so errors and Stringers are readable.
+func value(v interface{}) interface{} {
+	switch v := v.(type) {
+	case nil:
+		return nil
+	case error:
+		return v.Error()
+	case fmt.Stringer:
+		return v.String()
+	case string, bool, int, int64, float64:
+		return v
+	}
+
+	return fmt.Sprint(v)
+}
+
+func formatJSON(keyvals []interface{}) string {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+
+	for i := 0; i < len(keyvals); i += 2 {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+
+		key, _ := json.Marshal(fmt.Sprint(keyvals[i]))
+		val, err := json.Marshal(value(keyvals[i+1]))
+		if err != nil {
+			val, _ = json.Marshal(fmt.Sprint(keyvals[i+1]))
+		}
+
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(val)
+	}
+
+	buf.WriteByte('}')
+	return buf.String()
+}
+
+func formatLogfmt(keyvals []interface{}) string {
+	var b strings.Builder
+
+	for i := 0; i < len(keyvals); i += 2 {
+		if i > 0 {
+			b.WriteByte(' ')
+		}
+
+		b.WriteString(fmt.Sprint(keyvals[i]))
+		b.WriteByte('=')
+
+		s := fmt.Sprint(value(keyvals[i+1]))
+		if s == "" || strings.ContainsAny(s, " =\"\t\r\n") {
+			s = fmt.Sprintf("%q", s)
+		}
+		b.WriteString(s)
+	}
+
+	return b.String()
+}
+
+// Writer returns a writer logging each line written to it as a message at
+// level, for libraries using the standard log package.
+func (logger *Logger) Writer(level Level) io.Writer {
+	return &lineWriter{logger: logger, level: level}
+}
+
+type lineWriter struct {
+	logger *Logger
+	level  Level
+}
+
+func (lw *lineWriter) Write(p []byte) (int, error) {
+	for _, line := range strings.Split(strings.TrimRight(string(p), "\n"), "\n") {
+		lw.logger.orDefault().write(lw.level, line, nil)
+	}
+
+	return len(p), nil
+}
+
+// NewID returns a random correlation ID for a job or request.
+func NewID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+
+	return hex.EncodeToString(b)
+}
+
+type contextKey struct{}
+
+// NewContext returns a context carrying logger, such as the logger of an
+// HTTP request with its correlation ID.
+func NewContext(ctx context.Context, logger *Logger) context.Context {
+	return context.WithValue(ctx, contextKey{}, logger)
+}
+
+// FromContext returns the logger of the context, or the default logger.
+func FromContext(ctx context.Context) *Logger {
+	if logger, ok := ctx.Value(contextKey{}).(*Logger); ok {
+		return logger
+	}
+
+	return Default()
+}
+
+// WithContext returns the logger of ctx with the fields of logger, so the
+// messages of an input, transformer or output carry the correlation IDs of
+// the job or request it works for. Without a logger in ctx, it returns
+// logger.
+func (logger *Logger) WithContext(ctx context.Context) *Logger {
+	job, ok := ctx.Value(contextKey{}).(*Logger)
+	if !ok {
+		return logger
+	}
+
+	return job.With(logger.orDefault().fields...)
+}
@@ -2,107 +2,505 @@ package azure
 
 import (
 	"bytes"
+	"compress/gzip"
 	"context"
+	"encoding/base64"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
-	"log"
+	"mime"
+	"net/http"
 	"net/url"
 	"path"
 	"strings"
+	"time"
 
 	"github.com/Azure/azure-storage-blob-go/2018-03-28/azblob"
+	"github.com/zachomedia/composerrepo/pkg/composer"
+	"github.com/zachomedia/composerrepo/pkg/config/schema"
+	"github.com/zachomedia/composerrepo/pkg/logging"
+	"github.com/zachomedia/composerrepo/pkg/redact"
 )
 
+const (
+	// DefaultHashedCacheControl is used for files whose name contains their hash,
+	// which never change once written.
+	DefaultHashedCacheControl = "public, max-age=31536000, immutable"
+
+	// DefaultCacheControl is used for all other files, such as packages.json.
+	DefaultCacheControl = "no-cache"
+
+	// azuriteAccountName and azuriteAccountKey are the well-known development
+	// storage credentials used by Azurite.
+	azuriteAccountName = "devstoreaccount1"
+	azuriteAccountKey  = "Eby8vdM02xNOcqFlqUwJPLlmEtlCDXJ1OUzFT50uSRZ6IFsuFq2UVErCz4I6tq/K1SZFPTOtr/KBHBeksoGMGw=="
+	azuriteEndpoint    = "http://127.0.0.1:10000/devstoreaccount1"
+
+	managedIdentityURL = "http://169.254.169.254/metadata/identity/oauth2/token"
+	storageResource    = "https://storage.azure.com/"
+)
+
+type CacheControlConfig struct {
+	Hashed  string `config:"hashed"`
+	Default string `config:"default"`
+}
+
+type Config struct {
+	ConnectionString        string             `config:"connectionString,secret"`
+	AccountName             string             `config:"accountName"`
+	AccountKey              string             `config:"accountKey,secret"`
+	SASToken                string             `config:"sasToken,secret"`
+	Endpoint                string             `config:"endpoint"`
+	ManagedIdentity         bool               `config:"managedIdentity"`
+	ManagedIdentityClientID string             `config:"managedIdentityClientID"`
+	Container               string             `config:"container,required"`
+	BasePath                string             `config:"basePath"`
+	CreateContainer         bool               `config:"createContainer"`
+	Gzip                    bool               `config:"gzip"`
+	CacheControl            CacheControlConfig `config:"cacheControl"`
+}
+
 type AzureOutput struct {
 	AccountName string
-	Credentials *azblob.SharedKeyCredential
+	Endpoint    string
+	SASToken    string
+	Credentials azblob.Credential
 	Container   string
+	BasePath    string
+
+	ManagedIdentityClientID string
+
+	CreateContainer    bool
+	Gzip               bool
+	HashedCacheControl string
+	CacheControl       string
+
+	containerReady bool
+
+	log *logging.Logger
+}
+
+// SetLogger sets the logger of the output.
+func (ao *AzureOutput) SetLogger(log *logging.Logger) {
+	ao.log = log
 }
 
 func (ao *AzureOutput) getContainerURL() (*azblob.ContainerURL, error) {
+	// Managed identity tokens are requested on first use
+	if ao.Credentials == nil {
+		credentials, err := newManagedIdentityCredential(ao.ManagedIdentityClientID, ao.log)
+		if err != nil {
+			return nil, err
+		}
+		ao.Credentials = credentials
+	}
+
 	pipeline := azblob.NewPipeline(ao.Credentials, azblob.PipelineOptions{})
-	u, err := url.Parse(fmt.Sprintf("https://%s.blob.core.windows.net", ao.AccountName))
+	u, err := url.Parse(ao.Endpoint)
 	if err != nil {
 		return nil, err
 	}
 
+	if ao.SASToken != "" {
+		u.RawQuery = strings.TrimPrefix(ao.SASToken, "?")
+	}
+
 	serviceURL := azblob.NewServiceURL(*u, pipeline)
 	containerURL := serviceURL.NewContainerURL(ao.Container)
 	return &containerURL, nil
 }
 
-func (ao *AzureOutput) Init(conf map[string]interface{}) error {
-	var accountName, accountKey string
-	if accountNameInt, ok := conf["accountName"]; ok {
-		if accountName, ok = accountNameInt.(string); !ok {
-			return errors.New("Expected Azure account name as a string")
+// parseConnectionString parses an Azure Storage connection string into its settings.
+func parseConnectionString(connectionString string) (map[string]string, error) {
+	settings := make(map[string]string)
+
+	for _, part := range strings.Split(connectionString, ";") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
 		}
-	} else {
-		return errors.New("Expected Azure account name")
+
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 {
+			return nil, errors.New("Invalid Azure connection string")
+		}
+
+		settings[kv[0]] = kv[1]
 	}
 
-	if accountKeyInt, ok := conf["accountKey"]; ok {
-		if accountKey, ok = accountKeyInt.(string); !ok {
-			return errors.New("Expected Azure account key as a string")
+	if settings["UseDevelopmentStorage"] == "true" {
+		settings["AccountName"] = azuriteAccountName
+		settings["AccountKey"] = azuriteAccountKey
+		settings["BlobEndpoint"] = azuriteEndpoint
+	}
+
+	if _, ok := settings["BlobEndpoint"]; !ok && settings["AccountName"] != "" {
+		protocol := settings["DefaultEndpointsProtocol"]
+		if protocol == "" {
+			protocol = "https"
 		}
-	} else {
-		return errors.New("Expected Azure account key")
+
+		suffix := settings["EndpointSuffix"]
+		if suffix == "" {
+			suffix = "core.windows.net"
+		}
+
+		settings["BlobEndpoint"] = fmt.Sprintf("%s://%s.blob.%s", protocol, settings["AccountName"], suffix)
+	}
+
+	return settings, nil
+}
+
+// getManagedIdentityToken requests a storage access token from the instance metadata service.
+func getManagedIdentityToken(clientID string) (string, time.Duration, error) {
+	u, err := url.Parse(managedIdentityURL)
+	if err != nil {
+		return "", 0, err
+	}
+
+	q := u.Query()
+	q.Set("api-version", "2018-02-01")
+	q.Set("resource", storageResource)
+	if clientID != "" {
+		q.Set("client_id", clientID)
+	}
+	u.RawQuery = q.Encode()
+
+	req, err := http.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		return "", 0, err
+	}
+	req.Header.Set("Metadata", "true")
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", 0, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return "", 0, fmt.Errorf("Unable to get managed identity token: %s", res.Status)
+	}
+
+	token := struct {
+		AccessToken string      `json:"access_token"`
+		ExpiresIn   json.Number `json:"expires_in"`
+	}{}
+	err = json.NewDecoder(res.Body).Decode(&token)
+	if err != nil {
+		return "", 0, err
+	}
+
+	expiresIn, err := token.ExpiresIn.Int64()
+	if err != nil {
+		return "", 0, err
+	}
+
+	return token.AccessToken, time.Duration(expiresIn) * time.Second, nil
+}
+
+// newManagedIdentityCredential returns a token credential which is refreshed
+// from the instance metadata service before it expires.
+func newManagedIdentityCredential(clientID string, log *logging.Logger) (azblob.Credential, error) {
+	token, _, err := getManagedIdentityToken(clientID)
+	if err != nil {
+		return nil, err
+	}
+
+	return azblob.NewTokenCredential(token, func(credential azblob.TokenCredential) time.Duration {
+		token, expiresIn, err := getManagedIdentityToken(clientID)
+		if err != nil {
+			log.Warn("Unable to refresh the managed identity token, retrying", "error", err)
+			return time.Minute
+		}
+
+		credential.SetToken(token)
+
+		// Refresh ahead of the expiry
+		if expiresIn > 10*time.Minute {
+			return expiresIn - 5*time.Minute
+		}
+		return expiresIn / 2
+	}), nil
+}
+
+func (ao *AzureOutput) Init(conf map[string]interface{}) error {
+	config := Config{
+		CacheControl: CacheControlConfig{
+			Hashed:  DefaultHashedCacheControl,
+			Default: DefaultCacheControl,
+		},
+	}
+	if err := schema.Decode(conf, &config); err != nil {
+		return err
+	}
+
+	settings, err := parseConnectionString(config.ConnectionString)
+	if err != nil {
+		return &schema.FieldError{Path: "connectionString", Message: err.Error()}
+	}
+
+	accountName := config.AccountName
+	if accountName == "" {
+		accountName = settings["AccountName"]
+	}
+
+	accountKey := config.AccountKey
+	if accountKey == "" {
+		accountKey = settings["AccountKey"]
+	}
+	redact.Add(accountKey)
+
+	sasToken := config.SASToken
+	if sasToken == "" {
+		sasToken = settings["SharedAccessSignature"]
+	}
+	redact.Add(sasToken)
+
+	endpoint := config.Endpoint
+	if endpoint == "" {
+		endpoint = settings["BlobEndpoint"]
+	}
+
+	if endpoint == "" {
+		if accountName == "" {
+			return &schema.FieldError{Path: "accountName", Message: "is required without an endpoint or connection string"}
+		}
+
+		endpoint = fmt.Sprintf("https://%s.blob.core.windows.net", accountName)
+	}
+
+	if _, err := url.Parse(endpoint); err != nil {
+		return &schema.FieldError{Path: "endpoint", Message: err.Error()}
 	}
 
 	ao.AccountName = accountName
-	ao.Credentials = azblob.NewSharedKeyCredential(accountName, accountKey)
+	ao.Endpoint = strings.TrimSuffix(endpoint, "/")
+	ao.SASToken = sasToken
+
+	switch {
+	case accountKey != "":
+		if accountName == "" {
+			return &schema.FieldError{Path: "accountName", Message: "is required with an account key"}
+		}
+		if _, err := base64.StdEncoding.DecodeString(accountKey); err != nil {
+			return &schema.FieldError{Path: "accountKey", Message: "expected a base64 encoded key"}
+		}
+		ao.Credentials = azblob.NewSharedKeyCredential(accountName, accountKey)
+	case sasToken != "":
+		ao.Credentials = azblob.NewAnonymousCredential()
+	case config.ManagedIdentity:
+		ao.ManagedIdentityClientID = config.ManagedIdentityClientID
+	default:
+		return errors.New("expected an account key, SAS token, connection string or managed identity")
+	}
+
+	ao.Container = config.Container
+	ao.BasePath = config.BasePath
+	if ao.BasePath == "" {
+		ao.BasePath = fmt.Sprintf("/%s", ao.Container)
+	}
+
+	ao.CreateContainer = config.CreateContainer
+	ao.Gzip = config.Gzip
+	ao.HashedCacheControl = config.CacheControl.Hashed
+	ao.CacheControl = config.CacheControl.Default
+
+	return nil
+}
+
+// Check confirms the container can be reached with the configured credentials.
+func (ao *AzureOutput) Check() error {
+	containerURL, err := ao.getContainerURL()
+	if err != nil {
+		return err
+	}
+
+	_, err = containerURL.GetProperties(context.Background(), azblob.LeaseAccessConditions{})
+	if serr, ok := err.(azblob.StorageError); ok && serr.ServiceCode() == azblob.ServiceCodeContainerNotFound && ao.CreateContainer {
+		return nil
+	}
 
-	if containerInt, ok := conf["container"]; ok {
-		if container, ok := containerInt.(string); ok {
-			ao.Container = container
-		} else {
-			return errors.New("Expected Azure container as a string")
+	return err
+}
+
+// ensureContainer creates the container if requested and it doesn't exist yet.
+func (ao *AzureOutput) ensureContainer(containerURL *azblob.ContainerURL) error {
+	if !ao.CreateContainer || ao.containerReady {
+		return nil
+	}
+
+	_, err := containerURL.Create(context.Background(), azblob.Metadata{}, azblob.PublicAccessNone)
+	if err != nil {
+		if serr, ok := err.(azblob.StorageError); !ok || serr.ServiceCode() != azblob.ServiceCodeContainerAlreadyExists {
+			return err
 		}
 	} else {
-		return errors.New("Expected Azure container")
+		ao.log.Info("Created container", "container", ao.Container)
 	}
 
+	ao.containerReady = true
 	return nil
 }
 
+// getCacheControl returns the Cache-Control header for the named file.
+// Files named after their hash are immutable and can be cached for a long time,
+// but not their signatures, which are written again when keys are rotated.
+func (ao *AzureOutput) getCacheControl(name string) string {
+	if strings.HasPrefix(name, "p/") && strings.Contains(name, "$") && !strings.HasSuffix(name, composer.SignatureSuffix) {
+		return ao.HashedCacheControl
+	}
+
+	return ao.CacheControl
+}
+
 func (ao *AzureOutput) GetBasePath() string {
-	return fmt.Sprintf("/%s", ao.Container)
+	return ao.BasePath
+}
+
+func (ao *AzureOutput) Get(ctx context.Context, name string) ([]byte, error) {
+	data, _, err := ao.GetWithETag(ctx, name)
+	return data, err
 }
 
-func (ao *AzureOutput) Get(name string) ([]byte, error) {
-	log.Printf("Loading %q", name)
+func (ao *AzureOutput) GetWithETag(ctx context.Context, name string) ([]byte, string, error) {
+	ao.log.WithContext(ctx).Debug("Reading blob", "blob", name)
 
 	containerURL, err := ao.getContainerURL()
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 
 	blobURL := containerURL.NewBlockBlobURL(name)
-	get, err := blobURL.Download(context.Background(), 0, 0, azblob.BlobAccessConditions{}, false)
+	get, err := blobURL.Download(ctx, 0, 0, azblob.BlobAccessConditions{}, false)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 
 	body := get.Body(azblob.RetryReaderOptions{})
 	defer body.Close()
 
-	return ioutil.ReadAll(body)
+	var data []byte
+	if get.ContentEncoding() == "gzip" {
+		gz, err := gzip.NewReader(body)
+		if err != nil {
+			return nil, "", err
+		}
+		defer gz.Close()
+
+		data, err = ioutil.ReadAll(gz)
+	} else {
+		data, err = ioutil.ReadAll(body)
+	}
+	if err != nil {
+		return nil, "", err
+	}
+
+	return data, string(get.ETag()), nil
 }
 
-func (ao *AzureOutput) Write(name string, data []byte) error {
-	log.Printf("Writing %q", name)
+// List returns the names of the blobs starting with prefix.
+func (ao *AzureOutput) List(ctx context.Context, prefix string) ([]string, error) {
+	containerURL, err := ao.getContainerURL()
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0)
+	for marker := (azblob.Marker{}); marker.NotDone(); {
+		list, err := containerURL.ListBlobsFlatSegment(ctx, marker, azblob.ListBlobsSegmentOptions{Prefix: prefix})
+		if err != nil {
+			return nil, err
+		}
+
+		for _, blob := range list.Segment.BlobItems {
+			names = append(names, blob.Name)
+		}
+		marker = list.NextMarker
+	}
+
+	return names, nil
+}
+
+// Delete deletes a blob and its snapshots, if it exists.
+func (ao *AzureOutput) Delete(ctx context.Context, name string) error {
+	ao.log.WithContext(ctx).Info("Deleting blob", "blob", name)
 
 	containerURL, err := ao.getContainerURL()
 	if err != nil {
 		return err
 	}
 
+	blobURL := containerURL.NewBlockBlobURL(path.Join(strings.Split(name, "/")...))
+	_, err = blobURL.Delete(ctx, azblob.DeleteSnapshotsOptionInclude, azblob.BlobAccessConditions{})
+	if serr, ok := err.(azblob.StorageError); ok && serr.ServiceCode() == azblob.ServiceCodeBlobNotFound {
+		return nil
+	}
+
+	return err
+}
+
+// contentType returns the type of a file from its extension, such as
+// text/html for the pages of the site. The repository's files, including
+// the signatures, are JSON.
+func contentType(name string) string {
+	if strings.HasSuffix(name, composer.SignatureSuffix) {
+		return "application/json"
+	}
+
+	if typ := mime.TypeByExtension(path.Ext(name)); typ != "" {
+		return typ
+	}
+
+	return "application/json"
+}
+
+func (ao *AzureOutput) Write(ctx context.Context, name string, data []byte) error {
+	return ao.WriteIfMatch(ctx, name, data, "")
+}
+
+func (ao *AzureOutput) WriteIfMatch(ctx context.Context, name string, data []byte, etag string) error {
+	ao.log.WithContext(ctx).Debug("Writing blob", "blob", name)
+
+	containerURL, err := ao.getContainerURL()
+	if err != nil {
+		return err
+	}
+
+	if err := ao.ensureContainer(containerURL); err != nil {
+		return err
+	}
+
+	headers := azblob.BlobHTTPHeaders{
+		ContentType:  contentType(name),
+		CacheControl: ao.getCacheControl(name),
+	}
+
+	if ao.Gzip {
+		var buf bytes.Buffer
+		gz := gzip.NewWriter(&buf)
+		if _, err := gz.Write(data); err != nil {
+			return err
+		}
+		if err := gz.Close(); err != nil {
+			return err
+		}
+
+		data = buf.Bytes()
+		headers.ContentEncoding = "gzip"
+	}
+
 	fPath := path.Join(strings.Split(name, "/")...)
 	blobURL := containerURL.NewBlockBlobURL(fPath)
 
-	_, err = blobURL.Upload(context.Background(), bytes.NewReader(data), azblob.BlobHTTPHeaders{ContentType: "application/json"}, azblob.Metadata{}, azblob.BlobAccessConditions{})
-	if err != nil {
+	conditions := azblob.BlobAccessConditions{}
+	conditions.IfMatch = azblob.ETag(etag)
+
+	_, err = blobURL.Upload(ctx, bytes.NewReader(data), headers, azblob.Metadata{}, conditions)
+	if serr, ok := err.(azblob.StorageError); ok && serr.ServiceCode() == azblob.ServiceCodeConditionNotMet {
+		return composer.ErrConflict
+	} else if err != nil {
 		return err
 	}
 
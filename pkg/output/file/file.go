@@ -1,37 +1,139 @@
 package file
 
 import (
+	"context"
 	"crypto/sha256"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
-	"log"
 	"os"
 	"path"
+	"path/filepath"
 	"strings"
+	"time"
+
+	"github.com/zachomedia/composerrepo/pkg/composer"
+	"github.com/zachomedia/composerrepo/pkg/config/schema"
+	"github.com/zachomedia/composerrepo/pkg/filelock"
+	"github.com/zachomedia/composerrepo/pkg/logging"
+)
+
+const (
+	// tmpSuffix is the suffix of files being staged before they are renamed into place.
+	tmpSuffix = ".tmp"
+
+	// lockTimeout is how long to wait for the lock guarding conditional writes.
+	lockTimeout = 30 * time.Second
+
+	// staleAge is the age after which a temporary file was left behind by
+	// an interrupted run, rather than being written by another process.
+	staleAge = 10 * time.Minute
 )
 
+type Config struct {
+	Dir      string `config:"dir"`
+	BasePath string `config:"basePath"`
+}
+
 type FileOutput struct {
 	Out      string
 	BasePath string
+
+	log *logging.Logger
+}
+
+// SetLogger sets the logger of the output.
+func (fo *FileOutput) SetLogger(log *logging.Logger) {
+	fo.log = log
 }
 
 func (fo *FileOutput) Init(conf map[string]interface{}) error {
-	if dir, ok := conf["dir"]; ok {
-		fo.Out = dir.(string)
+	config := Config{}
+	if err := schema.Decode(conf, &config); err != nil {
+		return err
 	}
 
-	if basePath, ok := conf["basePath"]; ok {
-		fo.BasePath = basePath.(string)
-	}
+	fo.Out = config.Dir
+	fo.BasePath = config.BasePath
 
 	return nil
 }
 
-func (fo *FileOutput) ensureFolder(f string) error {
+// Open removes temporary files left behind by an interrupted run.
+func (fo *FileOutput) Open() error {
+	return fo.cleanup()
+}
+
+// Check confirms files can be written to the output directory.
+func (fo *FileOutput) Check() error {
+	if err := fo.ensureFolder(fo.log, path.Join(fo.Out, ".")); err != nil {
+		return err
+	}
+
+	f, err := ioutil.TempFile(path.Join(fo.Out, "."), fmt.Sprintf(".check.*%s", tmpSuffix))
+	if err != nil {
+		return err
+	}
+	f.Close()
+
+	return os.Remove(f.Name())
+}
+
+// isTempFile reports whether name is a file staged by Write.
+func isTempFile(name string) bool {
+	return strings.HasPrefix(name, ".") && strings.HasSuffix(name, tmpSuffix)
+}
+
+// isStale reports whether info is a temporary file left behind by an
+// interrupted run.
+func isStale(info os.FileInfo) bool {
+	return isTempFile(info.Name()) && time.Since(info.ModTime()) > staleAge
+}
+
+// cleanup removes temporary files left behind by an interrupted run. Recent
+// ones may belong to another process sharing the directory, so they stay.
+func (fo *FileOutput) cleanup() error {
+	root := path.Join(fo.Out, ".")
+
+	files, err := ioutil.ReadDir(root)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	for _, f := range files {
+		if !f.IsDir() && isStale(f) {
+			fo.log.Info("Removing stale file", "path", path.Join(root, f.Name()))
+			if err := os.Remove(path.Join(root, f.Name())); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Package files are all stored under p/
+	err = filepath.Walk(path.Join(root, "p"), func(fPath string, info os.FileInfo, err error) error {
+		if os.IsNotExist(err) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		if !info.IsDir() && isStale(info) {
+			fo.log.Info("Removing stale file", "path", fPath)
+			return os.Remove(fPath)
+		}
+
+		return nil
+	})
+
+	return err
+}
+
+func (fo *FileOutput) ensureFolder(log *logging.Logger, f string) error {
 	_, err := os.Stat(f)
 	if os.IsNotExist(err) {
-		log.Printf("Directory doesn't exist, creating %q", f)
+		log.Debug("Creating directory", "path", f)
 
 		merr := os.MkdirAll(f, os.ModePerm)
 
@@ -58,9 +160,13 @@ func (fo *FileOutput) GetBasePath() string {
 	return fo.BasePath
 }
 
-func (fo *FileOutput) Get(name string) ([]byte, error) {
-	fPath := path.Join(fo.Out, path.Join(strings.Split(name, "/")...))
-	log.Printf("Reading package %q", fPath)
+func (fo *FileOutput) getPath(name string) string {
+	return path.Join(fo.Out, path.Join(strings.Split(name, "/")...))
+}
+
+func (fo *FileOutput) Get(ctx context.Context, name string) ([]byte, error) {
+	fPath := fo.getPath(name)
+	fo.log.WithContext(ctx).Debug("Reading file", "path", fPath)
 
 	// Write packages.json
 	f, err := os.Open(fPath)
@@ -72,24 +178,158 @@ func (fo *FileOutput) Get(name string) ([]byte, error) {
 	return ioutil.ReadAll(f)
 }
 
-func (fo *FileOutput) Write(name string, data []byte) error {
+// List returns the names of the files starting with prefix, skipping
+// files being staged by Write.
+func (fo *FileOutput) List(ctx context.Context, prefix string) ([]string, error) {
+	root := path.Join(fo.Out, ".")
+
+	// Only walk the directory containing the prefix
+	dir := "."
+	if i := strings.LastIndex(prefix, "/"); i >= 0 {
+		dir = prefix[:i]
+	}
+
+	names := make([]string, 0)
+	err := filepath.Walk(path.Join(root, dir), func(fPath string, info os.FileInfo, err error) error {
+		if os.IsNotExist(err) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		if info.IsDir() || isTempFile(info.Name()) || strings.HasSuffix(info.Name(), filelock.Suffix) {
+			return nil
+		}
+
+		rel, err := filepath.Rel(root, fPath)
+		if err != nil {
+			return err
+		}
+
+		name := filepath.ToSlash(rel)
+		if strings.HasPrefix(name, prefix) {
+			names = append(names, name)
+		}
+
+		return nil
+	})
+
+	return names, err
+}
+
+// Delete deletes a file, if it exists.
+func (fo *FileOutput) Delete(ctx context.Context, name string) error {
+	fPath := fo.getPath(name)
+	fo.log.WithContext(ctx).Info("Deleting file", "path", fPath)
+
+	if err := os.Remove(fPath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
+
+func (fo *FileOutput) Write(ctx context.Context, name string, data []byte) error {
+	log := fo.log.WithContext(ctx)
 	components := strings.Split(name, "/")
 
 	// Ensure the directory structure is correct.
-	if err := fo.ensureFolder(path.Join(fo.Out, path.Join(components[:len(components)-1]...))); err != nil {
+	if err := fo.ensureFolder(log, path.Join(fo.Out, path.Join(components[:len(components)-1]...))); err != nil {
 		return err
 	}
 
-	fPath := path.Join(fo.Out, path.Join(strings.Split(name, "/")...))
-	log.Printf("Writing package %q", fPath)
+	fPath := fo.getPath(name)
+	log.Debug("Writing file", "path", fPath)
 
-	// Write packages.json
-	f, err := os.Create(fPath)
+	// Stage the contents in a temporary file next to the destination so
+	// readers never see a partially written file.
+	dir, base := path.Split(fPath)
+	if dir == "" {
+		dir = "."
+	}
+
+	f, err := ioutil.TempFile(dir, fmt.Sprintf(".%s.*%s", base, tmpSuffix))
 	if err != nil {
 		return err
 	}
-	defer f.Close()
 
-	_, err = f.Write(data)
+	tmpPath := f.Name()
+	err = fo.writeFile(f, data)
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	err = os.Rename(tmpPath, fPath)
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	return syncDir(dir)
+}
+
+// writeFile writes data to f, flushes it to disk and closes it.
+func (fo *FileOutput) writeFile(f *os.File, data []byte) error {
+	_, err := f.Write(data)
+	if err == nil {
+		err = f.Chmod(0644)
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+
 	return err
 }
+
+// syncDir flushes the directory entry so a rename survives a crash.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	return d.Sync()
+}
+
+func (fo *FileOutput) GetWithETag(ctx context.Context, name string) ([]byte, string, error) {
+	data, err := fo.Get(ctx, name)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return data, fmt.Sprintf("%x", sha256.Sum256(data)), nil
+}
+
+func (fo *FileOutput) WriteIfMatch(ctx context.Context, name string, data []byte, etag string) error {
+	if etag == "" {
+		return fo.Write(ctx, name, data)
+	}
+
+	fPath := fo.getPath(name)
+	if err := fo.ensureFolder(fo.log.WithContext(ctx), path.Dir(fPath)); err != nil {
+		return err
+	}
+
+	unlock, err := filelock.Lock(fPath, lockTimeout)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	current, err := ioutil.ReadFile(fPath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	if os.IsNotExist(err) || fmt.Sprintf("%x", sha256.Sum256(current)) != etag {
+		return composer.ErrConflict
+	}
+
+	return fo.Write(ctx, name, data)
+}
@@ -0,0 +1,248 @@
+package multi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/zachomedia/composerrepo/pkg/composer"
+	"github.com/zachomedia/composerrepo/pkg/config/schema"
+	"github.com/zachomedia/composerrepo/pkg/logging"
+)
+
+const (
+	// OnErrorFail stops the run when a write to the target fails.
+	OnErrorFail = "fail"
+
+	// OnErrorWarn logs a warning and continues when a write to the target fails.
+	OnErrorWarn = "warn"
+)
+
+type Config struct {
+	Outputs []map[string]interface{} `config:"outputs,required"`
+	Primary string                   `config:"primary"`
+}
+
+type TargetConfig struct {
+	Name    string `config:"name"`
+	OnError string `config:"onError"`
+}
+
+type Target struct {
+	Name    string
+	OnError string
+	Output  composer.Output
+}
+
+// MultiOutput writes to several outputs and reads from a primary output.
+type MultiOutput struct {
+	Targets []*Target
+	Primary *Target
+
+	log       *logging.Logger
+	newOutput composer.OutputFactory
+}
+
+// SetLogger sets the logger of the output.
+func (mo *MultiOutput) SetLogger(log *logging.Logger) {
+	mo.log = log
+}
+
+// SetOutputFactory sets the factory creating the outputs written to.
+func (mo *MultiOutput) SetOutputFactory(factory composer.OutputFactory) {
+	mo.newOutput = factory
+}
+
+func (mo *MultiOutput) Init(conf map[string]interface{}) error {
+	config := Config{}
+	if err := schema.Decode(conf, &config); err != nil {
+		return err
+	}
+
+	if len(config.Outputs) == 0 {
+		return &schema.FieldError{Path: "outputs", Message: "expected at least one output"}
+	}
+
+	if mo.newOutput == nil {
+		return errors.New("Unable to create the outputs, no output factory was set")
+	}
+
+	mo.Targets = make([]*Target, 0)
+	errs := schema.Errors{}
+	names := make(map[string]bool)
+
+	for indx, rawConf := range config.Outputs {
+		path := fmt.Sprintf("outputs[%d]", indx)
+
+		// Split the target options from the output's own config
+		targetConf := make(map[string]interface{})
+		outputConf := make(map[string]interface{})
+		for k, v := range rawConf {
+			switch k {
+			case "name", "onError":
+				targetConf[k] = v
+			default:
+				outputConf[k] = v
+			}
+		}
+
+		targetConfig := TargetConfig{
+			Name:    fmt.Sprintf("%d", indx),
+			OnError: OnErrorFail,
+		}
+		if err := schema.Decode(targetConf, &targetConfig); err != nil {
+			errs.Add(path, err)
+			continue
+		}
+
+		if targetConfig.OnError != OnErrorFail && targetConfig.OnError != OnErrorWarn {
+			errs.Add(path+".onError", fmt.Errorf("unknown policy %q, expected %q or %q", targetConfig.OnError, OnErrorFail, OnErrorWarn))
+			continue
+		}
+
+		if names[targetConfig.Name] {
+			errs.Add(path+".name", fmt.Errorf("duplicate output name %q", targetConfig.Name))
+			continue
+		}
+		names[targetConfig.Name] = true
+
+		output, err := mo.newOutput(outputConf)
+		if err != nil {
+			errs.Add(path, err)
+			continue
+		}
+
+		mo.Targets = append(mo.Targets, &Target{
+			Name:    targetConfig.Name,
+			OnError: targetConfig.OnError,
+			Output:  output,
+		})
+	}
+
+	if err := errs.Err(); err != nil {
+		return err
+	}
+
+	// The first output is the primary unless one is named.
+	mo.Primary = mo.Targets[0]
+	if config.Primary != "" {
+		mo.Primary = nil
+		for _, target := range mo.Targets {
+			if target.Name == config.Primary {
+				mo.Primary = target
+				break
+			}
+		}
+
+		if mo.Primary == nil {
+			return &schema.FieldError{Path: "primary", Message: fmt.Sprintf("unknown output %q", config.Primary)}
+		}
+	}
+
+	return nil
+}
+
+// Open opens each of the outputs.
+func (mo *MultiOutput) Open() error {
+	errs := schema.Errors{}
+
+	for indx, target := range mo.Targets {
+		if opener, ok := target.Output.(composer.Opener); ok {
+			errs.Add(fmt.Sprintf("outputs[%d]", indx), opener.Open())
+		}
+	}
+
+	return errs.Err()
+}
+
+// Check checks each of the outputs.
+func (mo *MultiOutput) Check() error {
+	errs := schema.Errors{}
+
+	for indx, target := range mo.Targets {
+		if checker, ok := target.Output.(composer.Checker); ok {
+			errs.Add(fmt.Sprintf("outputs[%d]", indx), checker.Check())
+		}
+	}
+
+	return errs.Err()
+}
+
+func (mo *MultiOutput) GetBasePath() string {
+	return mo.Primary.Output.GetBasePath()
+}
+
+func (mo *MultiOutput) Get(ctx context.Context, name string) ([]byte, error) {
+	return mo.Primary.Output.Get(ctx, name)
+}
+
+func (mo *MultiOutput) GetWithETag(ctx context.Context, name string) ([]byte, string, error) {
+	return composer.GetWithETag(ctx, mo.Primary.Output, name)
+}
+
+// List lists the files of the primary output.
+func (mo *MultiOutput) List(ctx context.Context, prefix string) ([]string, error) {
+	lister, ok := mo.Primary.Output.(composer.Lister)
+	if !ok {
+		return nil, fmt.Errorf("The primary output %q can't list files", mo.Primary.Name)
+	}
+
+	return lister.List(ctx, prefix)
+}
+
+func (mo *MultiOutput) Write(ctx context.Context, name string, data []byte) error {
+	return mo.WriteIfMatch(ctx, name, data, "")
+}
+
+// WriteIfMatch writes to the primary output conditionally, then to the other outputs.
+func (mo *MultiOutput) WriteIfMatch(ctx context.Context, name string, data []byte, etag string) error {
+	err := composer.WriteIfMatch(ctx, mo.Primary.Output, name, data, etag)
+	if err == composer.ErrConflict {
+		return err
+	} else if err := mo.handleError(ctx, mo.Primary, name, err); err != nil {
+		return err
+	}
+
+	for _, target := range mo.Targets {
+		if target == mo.Primary {
+			continue
+		}
+
+		err := mo.handleError(ctx, target, name, target.Output.Write(ctx, name, data))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Delete deletes a file from every output which can delete files.
+func (mo *MultiOutput) Delete(ctx context.Context, name string) error {
+	for _, target := range mo.Targets {
+		deleter, ok := target.Output.(composer.Deleter)
+		if !ok {
+			continue
+		}
+
+		if err := mo.handleError(ctx, target, name, deleter.Delete(ctx, name)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// handleError applies the target's failure policy to err.
+func (mo *MultiOutput) handleError(ctx context.Context, target *Target, name string, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	if target.OnError == OnErrorWarn {
+		mo.log.WithContext(ctx).Warn("Failed writing to an output, continuing", "file", name, "target", target.Name, "error", err)
+		return nil
+	}
+
+	return fmt.Errorf("Output %q: %v", target.Name, err)
+}
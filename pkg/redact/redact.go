@@ -0,0 +1,81 @@
+// Package redact keeps track of secret config values and removes them from
+// logs and other output.
+package redact
+
+import (
+	"io"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Placeholder replaces secret values.
+const Placeholder = "[REDACTED]"
+
+// minLength is the shortest value treated as a secret, so that trivial
+// values such as "1" don't redact unrelated output.
+const minLength = 4
+
+var (
+	mu       sync.RWMutex
+	secrets  = make(map[string]bool)
+	replacer = strings.NewReplacer()
+)
+
+// Add registers a secret value to be redacted.
+func Add(secret string) {
+	if len(secret) < minLength {
+		return
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if secrets[secret] {
+		return
+	}
+	secrets[secret] = true
+
+	// Replace longer secrets first in case one contains another
+	values := make([]string, 0, len(secrets))
+	for s := range secrets {
+		values = append(values, s)
+	}
+	sort.Slice(values, func(i, j int) bool {
+		return len(values[i]) > len(values[j])
+	})
+
+	oldnew := make([]string, 0, len(values)*2)
+	for _, s := range values {
+		oldnew = append(oldnew, s, Placeholder)
+	}
+	replacer = strings.NewReplacer(oldnew...)
+}
+
+// String returns s with all secret values replaced.
+func String(s string) string {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	return replacer.Replace(s)
+}
+
+type writer struct {
+	w io.Writer
+}
+
+// NewWriter returns a writer which redacts secret values before writing to w.
+// Each write is redacted on its own, which suits the log package as it
+// writes each line in a single call.
+func NewWriter(w io.Writer) io.Writer {
+	return &writer{w: w}
+}
+
+func (rw *writer) Write(p []byte) (int, error) {
+	_, err := io.WriteString(rw.w, String(string(p)))
+	if err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
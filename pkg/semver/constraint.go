@@ -0,0 +1,369 @@
+package semver
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Constraint matches versions, such as "^1.0 || dev-main".
+type Constraint interface {
+	Matches(v *Version) bool
+	String() string
+}
+
+type operator string
+
+const (
+	opEQ operator = "=="
+	opNE operator = "!="
+	opLT operator = "<"
+	opLE operator = "<="
+	opGT operator = ">"
+	opGE operator = ">="
+)
+
+// single compares versions to one version.
+type single struct {
+	op      operator
+	version *Version
+}
+
+func (c *single) Matches(v *Version) bool {
+	// Named branches can only be compared for equality
+	if c.version.IsBranch() || v.IsBranch() {
+		equal := c.version.IsBranch() && v.IsBranch() && c.version.Branch == v.Branch
+		switch c.op {
+		case opEQ:
+			return equal
+		case opNE:
+			return !equal
+		}
+		return false
+	}
+
+	cmp := Compare(v, c.version)
+	switch c.op {
+	case opEQ:
+		return cmp == 0
+	case opNE:
+		return cmp != 0
+	case opLT:
+		return cmp < 0
+	case opLE:
+		return cmp <= 0
+	case opGT:
+		return cmp > 0
+	case opGE:
+		return cmp >= 0
+	}
+
+	return false
+}
+
+func (c *single) String() string {
+	return fmt.Sprintf("%s %s", c.op, c.version)
+}
+
+// anyVersion matches every version.
+type anyVersion struct{}
+
+func (anyVersion) Matches(v *Version) bool { return true }
+func (anyVersion) String() string          { return "*" }
+
+// and matches versions matching all of its constraints.
+type and []Constraint
+
+func (c and) Matches(v *Version) bool {
+	for _, constraint := range c {
+		if !constraint.Matches(v) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (c and) String() string {
+	parts := make([]string, len(c))
+	for indx, constraint := range c {
+		parts[indx] = constraint.String()
+	}
+
+	return "[" + strings.Join(parts, " ") + "]"
+}
+
+// or matches versions matching any of its constraints.
+type or []Constraint
+
+func (c or) Matches(v *Version) bool {
+	for _, constraint := range c {
+		if constraint.Matches(v) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (c or) String() string {
+	parts := make([]string, len(c))
+	for indx, constraint := range c {
+		parts[indx] = constraint.String()
+	}
+
+	return "[" + strings.Join(parts, " || ") + "]"
+}
+
+var (
+	orPattern        = regexp.MustCompile(`\s*\|\|?\s*`)
+	hyphenPattern    = regexp.MustCompile(`^(\S+)\s+-\s+(\S+)$`)
+	operatorPattern  = regexp.MustCompile(`^(<>|!=|>=|<=|==|<|>|=)\s*(.*)$`)
+	stabilityPattern = regexp.MustCompile(`(?i)@(stable|RC|beta|alpha|dev)$`)
+	wildcardPattern  = regexp.MustCompile(`^v?(\d+)(?:\.(\d+))?(?:\.(\d+))?\.[*x]$`)
+	partsPattern     = regexp.MustCompile(`^v?(\d+)(?:\.(\d+))?(?:\.(\d+))?(?:\.(\d+))?`)
+)
+
+// ParseConstraint parses a Composer version constraint.
+func ParseConstraint(constraint string) (Constraint, error) {
+	s := strings.TrimSpace(constraint)
+	if s == "" {
+		return nil, fmt.Errorf("Empty version constraint")
+	}
+
+	groups := orPattern.Split(s, -1)
+	result := make(or, 0, len(groups))
+
+	for _, group := range groups {
+		c, err := parseAnd(group)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid version constraint %q: %v", constraint, err)
+		}
+		result = append(result, c)
+	}
+
+	if len(result) == 1 {
+		return result[0], nil
+	}
+
+	return result, nil
+}
+
+// parseAnd parses constraints separated by commas or spaces.
+func parseAnd(group string) (Constraint, error) {
+	group = strings.TrimSpace(group)
+
+	if m := hyphenPattern.FindStringSubmatch(group); m != nil {
+		return parseHyphen(m[1], m[2])
+	}
+
+	// Join operators separated from their version by spaces, such as ">= 1.0"
+	fields := strings.FieldsFunc(group, func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t'
+	})
+	tokens := make([]string, 0, len(fields))
+	for indx := 0; indx < len(fields); indx++ {
+		token := fields[indx]
+		if operatorPattern.MatchString(token) && operatorPattern.FindStringSubmatch(token)[2] == "" && indx+1 < len(fields) {
+			indx++
+			token += fields[indx]
+		}
+		tokens = append(tokens, token)
+	}
+
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("empty constraint")
+	}
+
+	result := make(and, 0, len(tokens))
+	for indx := 0; indx < len(tokens); indx++ {
+		c, err := parseSingle(tokens[indx])
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, c)
+
+		// Inline aliases, such as "dev-main as 1.0.x-dev", match the real version
+		if indx+2 < len(tokens) && strings.EqualFold(tokens[indx+1], "as") {
+			indx += 2
+		}
+	}
+
+	if len(result) == 1 {
+		return result[0], nil
+	}
+
+	return result, nil
+}
+
+// countParts returns the number of numeric parts given in a version.
+func countParts(version string) int {
+	m := partsPattern.FindStringSubmatch(strings.ToLower(version))
+	if m == nil {
+		return 0
+	}
+
+	count := 0
+	for _, part := range m[1:] {
+		if part != "" {
+			count++
+		}
+	}
+
+	return count
+}
+
+// bump returns the version with the part at position incremented and
+// the following parts reset, as the lowest dev version.
+func bump(v *Version, position int) *Version {
+	bumped := &Version{Stability: StabilityDev}
+	for indx := 0; indx < position-1; indx++ {
+		bumped.Parts[indx] = v.Parts[indx]
+	}
+	bumped.Parts[position-1] = v.Parts[position-1] + 1
+
+	return bumped
+}
+
+// lowerBound returns the version as a lower bound. Without an explicit
+// stability, pre-releases of the version are included.
+func lowerBound(version string) (*Version, error) {
+	v, err := Parse(version)
+	if err != nil {
+		return nil, err
+	}
+
+	if !v.IsBranch() && v.Stability == StabilityStable && !v.Patch && !hasModifier(version) {
+		v.Stability = StabilityDev
+	}
+
+	return v, nil
+}
+
+// hasModifier reports whether the version has an explicit stability.
+func hasModifier(version string) bool {
+	m := numericPattern.FindStringSubmatch(strings.ToLower(version))
+	return m != nil && (m[5] != "" || m[7] != "")
+}
+
+func parseHyphen(from, to string) (Constraint, error) {
+	lower, err := lowerBound(from)
+	if err != nil {
+		return nil, err
+	}
+
+	upper, err := Parse(to)
+	if err != nil {
+		return nil, err
+	}
+
+	// A partial upper version includes all of its releases, so 1.0 - 2.0 is <2.1
+	parts := countParts(to)
+	if parts < 3 && !hasModifier(to) {
+		return and{&single{opGE, lower}, &single{opLT, bump(upper, parts)}}, nil
+	}
+
+	return and{&single{opGE, lower}, &single{opLE, upper}}, nil
+}
+
+func parseSingle(s string) (Constraint, error) {
+	s = stabilityPattern.ReplaceAllString(s, "")
+	if s == "" {
+		return anyVersion{}, nil
+	}
+
+	switch s {
+	case "*", "x", "*.*", "x.x", "*.*.*":
+		return anyVersion{}, nil
+	}
+
+	// Caret: allow changes that don't modify the left-most non-zero part
+	if strings.HasPrefix(s, "^") {
+		version := s[1:]
+		lower, err := lowerBound(version)
+		if err != nil {
+			return nil, err
+		}
+
+		parts := countParts(version)
+		position := 3
+		switch {
+		case lower.Parts[0] != 0 || parts == 1:
+			position = 1
+		case lower.Parts[1] != 0 || parts == 2:
+			position = 2
+		}
+
+		return and{&single{opGE, lower}, &single{opLT, bump(lower, position)}}, nil
+	}
+
+	// Tilde: allow the last given part to increase
+	if strings.HasPrefix(s, "~") {
+		version := s[1:]
+		lower, err := lowerBound(version)
+		if err != nil {
+			return nil, err
+		}
+
+		position := countParts(version) - 1
+		if position < 1 {
+			position = 1
+		}
+
+		return and{&single{opGE, lower}, &single{opLT, bump(lower, position)}}, nil
+	}
+
+	// Wildcards, such as 1.2.*
+	if m := wildcardPattern.FindStringSubmatch(strings.ToLower(s)); m != nil {
+		lower, err := Parse(strings.TrimSuffix(strings.TrimSuffix(s, ".*"), ".x"))
+		if err != nil {
+			return nil, err
+		}
+		lower.Stability = StabilityDev
+
+		parts := 0
+		for _, part := range m[1:] {
+			if part != "" {
+				parts++
+			}
+		}
+
+		return and{&single{opGE, lower}, &single{opLT, bump(lower, parts)}}, nil
+	}
+
+	op := opEQ
+	version := s
+	if m := operatorPattern.FindStringSubmatch(s); m != nil {
+		version = m[2]
+		switch m[1] {
+		case "<>", "!=":
+			op = opNE
+		case ">=":
+			op = opGE
+		case "<=":
+			op = opLE
+		case "<":
+			op = opLT
+		case ">":
+			op = opGT
+		}
+	}
+
+	// Bounds below a version exclude its pre-releases, and bounds from a
+	// version include them, so <2.0 excludes 2.0.0-beta1.
+	if op == opLT || op == opGE {
+		v, err := lowerBound(version)
+		if err != nil {
+			return nil, err
+		}
+
+		return &single{op, v}, nil
+	}
+
+	v, err := Parse(version)
+	if err != nil {
+		return nil, err
+	}
+
+	return &single{op, v}, nil
+}
@@ -0,0 +1,108 @@
+package semver
+
+import "testing"
+
+func TestConstraintMatches(t *testing.T) {
+	tests := []struct {
+		constraint string
+		version    string
+		matches    bool
+	}{
+		{"1.0.0", "1.0.0", true},
+		{"1.0.0", "1.0.1", false},
+		{"=1.0", "1.0.0", true},
+		{"!=1.0", "1.0.0", false},
+		{"<>1.0", "1.1.0", true},
+
+		{">=1.0", "1.0.0", true},
+		{">=1.0", "1.0.0-beta1", true},
+		{">= 1.0", "0.9.9", false},
+		{">1.0", "1.0.0", false},
+		{">1.0", "1.0.1", true},
+		{"<2.0", "1.9.9", true},
+		{"<2.0", "2.0.0-beta1", false},
+		{"<=2.0", "2.0.0", true},
+		{"<=2.0", "2.0.1", false},
+		{">=1.0 <2.0", "1.5.0", true},
+		{">=1.0, <2.0", "2.0.0", false},
+
+		{"^1.2.3", "1.2.3", true},
+		{"^1.2.3", "1.9.0", true},
+		{"^1.2.3", "1.2.2", false},
+		{"^1.2.3", "2.0.0", false},
+		{"^0.3", "0.3.9", true},
+		{"^0.3", "0.4.0", false},
+		{"^0.0.3", "0.0.3", true},
+		{"^0.0.3", "0.0.4", false},
+		{"^1", "1.99.0", true},
+
+		{"~1.2", "1.2.0", true},
+		{"~1.2", "1.9.0", true},
+		{"~1.2", "2.0.0", false},
+		{"~1.2.3", "1.2.9", true},
+		{"~1.2.3", "1.3.0", false},
+		{"~1", "1.5.0", true},
+		{"~1", "2.0.0", false},
+
+		{"1.2.*", "1.2.5", true},
+		{"1.2.*", "1.3.0", false},
+		{"1.x", "1.9.9", true},
+		{"1.x", "2.0.0", false},
+		{"*", "3.1.4", true},
+		{"*", "dev-main", true},
+
+		{"1.0 - 2.0", "2.0.9", true},
+		{"1.0 - 2.0", "2.1.0", false},
+		{"1.0.0 - 2.0.0", "2.0.0", true},
+		{"1.0.0 - 2.0.0", "2.0.1", false},
+
+		{"^1.0 || ^2.0", "2.5.0", true},
+		{"^1.0 | ^2.0", "1.5.0", true},
+		{"^1.0 || ^2.0", "3.0.0", false},
+
+		{"dev-main", "dev-main", true},
+		{"dev-main", "dev-feature", false},
+		{"dev-main", "1.0.0", false},
+		{"^1.0", "dev-main", false},
+		{"dev-main as 1.0.x-dev", "dev-main", true},
+		{"1.x-dev", "1.x-dev", true},
+
+		{"^1.0@dev", "1.1.0", true},
+		{"v1.0.0", "1.0.0", true},
+		{"1.0.0", "v1.0.0", true},
+	}
+
+	for _, test := range tests {
+		c, err := ParseConstraint(test.constraint)
+		if err != nil {
+			t.Errorf("ParseConstraint(%q) returned error: %v", test.constraint, err)
+			continue
+		}
+
+		v, err := Parse(test.version)
+		if err != nil {
+			t.Errorf("Parse(%q) returned error: %v", test.version, err)
+			continue
+		}
+
+		if got := c.Matches(v); got != test.matches {
+			t.Errorf("%q (%s) matches %q = %t, want %t", test.constraint, c, test.version, got, test.matches)
+		}
+	}
+}
+
+func TestParseConstraintInvalid(t *testing.T) {
+	tests := []string{
+		"",
+		"   ",
+		"^",
+		">=abc",
+		"1.0 || foo",
+	}
+
+	for _, test := range tests {
+		if c, err := ParseConstraint(test); err == nil {
+			t.Errorf("ParseConstraint(%q) = %s, want error", test, c)
+		}
+	}
+}
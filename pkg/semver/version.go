@@ -0,0 +1,213 @@
+// Package semver parses and compares versions and constraints the way
+// Composer does.
+package semver
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+type Stability int
+
+const (
+	StabilityDev Stability = iota
+	StabilityAlpha
+	StabilityBeta
+	StabilityRC
+	StabilityStable
+)
+
+var stabilityNames = map[Stability]string{
+	StabilityDev:    "dev",
+	StabilityAlpha:  "alpha",
+	StabilityBeta:   "beta",
+	StabilityRC:     "RC",
+	StabilityStable: "stable",
+}
+
+func (s Stability) String() string {
+	return stabilityNames[s]
+}
+
+// ParseStability parses a stability name, such as "beta" or "RC".
+func ParseStability(s string) (Stability, error) {
+	for stability, name := range stabilityNames {
+		if strings.EqualFold(name, s) {
+			return stability, nil
+		}
+	}
+
+	return StabilityStable, fmt.Errorf("Unknown stability %q", s)
+}
+
+// branchVersion is used for the wildcard parts of branches such as 1.x-dev.
+const branchVersion = 9999999
+
+// Version is a normalized Composer version.
+type Version struct {
+	Original string
+
+	// Branch is set for named branches, such as dev-main.
+	Branch string
+
+	Parts     [4]int
+	Stability Stability
+
+	// Patch is set for patch releases, which sort after the release.
+	Patch bool
+
+	// Pre is the number of a pre-release or patch, such as the 2 in 1.0.0-beta2.
+	Pre int
+}
+
+var (
+	numericPattern  = regexp.MustCompile(`^v?(\d+)(?:\.(\d+))?(?:\.(\d+))?(?:\.(\d+))?(?:[._-]?(stable|beta|b|rc|alpha|a|patch|pl|p)((?:[.-]?\d+)*))?([.-]?dev)?$`)
+	branchPattern   = regexp.MustCompile(`^v?(\d+)(?:\.(\d+|[x*]))?(?:\.(\d+|[x*]))?(?:\.(\d+|[x*]))?[._-]?dev$`)
+	preDigitPattern = regexp.MustCompile(`\d+`)
+)
+
+// Parse normalizes a version, such as "v1.2", "1.0.0-beta2", "1.x-dev" or "dev-main".
+func Parse(version string) (*Version, error) {
+	v := &Version{Original: version}
+
+	s := strings.ToLower(strings.TrimSpace(version))
+
+	// Build metadata and references are ignored
+	if indx := strings.IndexAny(s, "+#"); indx >= 0 {
+		s = s[:indx]
+	}
+
+	if strings.HasPrefix(s, "dev-") {
+		v.Branch = strings.TrimSpace(version)[4:]
+		if indx := strings.IndexAny(v.Branch, "+#"); indx >= 0 {
+			v.Branch = v.Branch[:indx]
+		}
+		v.Stability = StabilityDev
+		return v, nil
+	}
+
+	if m := numericPattern.FindStringSubmatch(s); m != nil {
+		for indx := 0; indx < 4; indx++ {
+			if m[indx+1] != "" {
+				v.Parts[indx], _ = strconv.Atoi(m[indx+1])
+			}
+		}
+
+		v.Stability = StabilityStable
+		switch m[5] {
+		case "beta", "b":
+			v.Stability = StabilityBeta
+		case "rc":
+			v.Stability = StabilityRC
+		case "alpha", "a":
+			v.Stability = StabilityAlpha
+		case "patch", "pl", "p":
+			v.Patch = true
+		}
+
+		if digits := preDigitPattern.FindString(m[6]); digits != "" {
+			v.Pre, _ = strconv.Atoi(digits)
+		}
+
+		if m[7] != "" {
+			v.Stability = StabilityDev
+			v.Patch = false
+		}
+
+		return v, nil
+	}
+
+	// Numbered branches, such as 1.x-dev
+	if m := branchPattern.FindStringSubmatch(s); m != nil {
+		wildcard := false
+		for indx := 0; indx < 4; indx++ {
+			part := m[indx+1]
+			if part == "" || part == "x" || part == "*" {
+				wildcard = true
+			}
+
+			if wildcard {
+				v.Parts[indx] = branchVersion
+			} else {
+				v.Parts[indx], _ = strconv.Atoi(part)
+			}
+		}
+
+		v.Stability = StabilityDev
+		return v, nil
+	}
+
+	return nil, fmt.Errorf("Invalid version %q", version)
+}
+
+// IsBranch reports whether the version is a named branch, such as dev-main.
+func (v *Version) IsBranch() bool {
+	return v.Branch != ""
+}
+
+// String returns the normalized version.
+func (v *Version) String() string {
+	if v.IsBranch() {
+		return "dev-" + v.Branch
+	}
+
+	s := fmt.Sprintf("%d.%d.%d.%d", v.Parts[0], v.Parts[1], v.Parts[2], v.Parts[3])
+
+	switch {
+	case v.Patch:
+		s += fmt.Sprintf("-patch%d", v.Pre)
+	case v.Stability == StabilityDev:
+		s += "-dev"
+	case v.Stability != StabilityStable:
+		s += fmt.Sprintf("-%s%d", v.Stability, v.Pre)
+	}
+
+	return s
+}
+
+// rank orders stabilities, with patch releases after stable releases.
+func (v *Version) rank() int {
+	if v.Patch {
+		return int(StabilityStable) + 1
+	}
+
+	return int(v.Stability)
+}
+
+// Compare returns -1, 0 or 1 if a is older than, the same as or newer than b.
+// Named branches are only equal to themselves and sort before other versions.
+func Compare(a, b *Version) int {
+	switch {
+	case a.IsBranch() && b.IsBranch():
+		return strings.Compare(a.Branch, b.Branch)
+	case a.IsBranch():
+		return -1
+	case b.IsBranch():
+		return 1
+	}
+
+	for indx := 0; indx < 4; indx++ {
+		if a.Parts[indx] != b.Parts[indx] {
+			return compareInt(a.Parts[indx], b.Parts[indx])
+		}
+	}
+
+	if a.rank() != b.rank() {
+		return compareInt(a.rank(), b.rank())
+	}
+
+	return compareInt(a.Pre, b.Pre)
+}
+
+func compareInt(a, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+
+	return 0
+}
@@ -0,0 +1,230 @@
+// Package site generates a browsable HTML index of the repository, similar
+// to the one generated by Satis.
+package site
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"html/template"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/zachomedia/composerrepo/pkg/composer"
+	"github.com/zachomedia/composerrepo/pkg/semver"
+)
+
+// IndexPage is the page listing every package.
+const IndexPage = "index.html"
+
+// PackagePage returns the page of a package, such as packages/vendor/name.html.
+func PackagePage(name string) string {
+	return fmt.Sprintf("packages/%s.html", strings.ToLower(name))
+}
+
+// HTMLSite renders the pages of the repository with html/template. The
+// default templates can be overridden by files of the same name in a
+// directory: layout.html, index.html and package.html.
+type HTMLSite struct {
+	Title     string
+	templates *template.Template
+}
+
+// indexData is passed to the index.html template. Name is always empty,
+// so the pages can share templates.
+type indexData struct {
+	Title    string
+	BaseURL  string
+	Root     string
+	Updated  time.Time
+	Name     string
+	Packages []*composer.IndexEntry
+}
+
+// packageData is passed to the package.html template.
+type packageData struct {
+	Title    string
+	BaseURL  string
+	Root     string
+	Updated  time.Time
+	Name     string
+	Latest   *composer.Package
+	Versions []*composer.Package
+}
+
+// New returns a site using the templates in templatesDir, if set, instead
+// of the defaults. Pages show the download counts of downloads, if set,
+// once its store is opened.
+func New(title string, templatesDir string, downloads *composer.DownloadStats) (*HTMLSite, error) {
+	tmpl := template.New("site").Funcs(template.FuncMap{
+		"page":  PackagePage,
+		"join":  strings.Join,
+		"list":  list,
+		"links": links,
+		"stats": func() bool {
+			return downloads != nil
+		},
+		"downloads": func(name string, version string) int64 {
+			if downloads == nil || downloads.Store == nil {
+				return 0
+			}
+			return downloads.Store.Downloads(name, version)
+		},
+		"keywords": func(entry *composer.IndexEntry) string {
+			return strings.ToLower(strings.Join(append([]string{entry.Name, entry.Description, entry.Type}, entry.Keywords...), " "))
+		},
+	})
+
+	tmpl, err := tmpl.Parse(defaultTemplates)
+	if err != nil {
+		return nil, err
+	}
+
+	if templatesDir != "" {
+		files, err := filepath.Glob(filepath.Join(templatesDir, "*.html"))
+		if err != nil {
+			return nil, err
+		}
+
+		for _, file := range files {
+			if _, err := tmpl.New(filepath.Base(file)).ParseFiles(file); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	for _, name := range []string{IndexPage, "package.html"} {
+		if tmpl.Lookup(name) == nil {
+			return nil, fmt.Errorf("template %q is not defined", name)
+		}
+	}
+
+	return &HTMLSite{Title: title, templates: tmpl}, nil
+}
+
+func (site *HTMLSite) render(ctx context.Context, output composer.Output, page string, name string, data interface{}) error {
+	var buf bytes.Buffer
+	if err := site.templates.ExecuteTemplate(&buf, name, data); err != nil {
+		return fmt.Errorf("Unable to render %q: %v", page, err)
+	}
+
+	return output.Write(ctx, page, buf.Bytes())
+}
+
+// WriteIndex writes index.html, listing every package in index.
+func (site *HTMLSite) WriteIndex(ctx context.Context, output composer.Output, index composer.Index) error {
+	return site.render(ctx, output, IndexPage, IndexPage, &indexData{
+		Title:    site.Title,
+		BaseURL:  output.GetBasePath(),
+		Updated:  time.Now().UTC(),
+		Packages: index.Entries(),
+	})
+}
+
+// WritePackage writes the page of a package, with the details of each version.
+func (site *HTMLSite) WritePackage(ctx context.Context, output composer.Output, name string, versions composer.PackageVersions) error {
+	sorted := sortVersions(versions)
+
+	latest := versions[composer.NewIndexEntry(name, versions).Version]
+	if latest == nil && len(sorted) > 0 {
+		latest = sorted[0]
+	}
+
+	return site.render(ctx, output, PackagePage(name), "package.html", &packageData{
+		Title:    site.Title,
+		BaseURL:  output.GetBasePath(),
+		Root:     strings.Repeat("../", strings.Count(PackagePage(name), "/")),
+		Updated:  time.Now().UTC(),
+		Name:     name,
+		Latest:   latest,
+		Versions: sorted,
+	})
+}
+
+// RemovePackage deletes the page of a package, if the output can delete files.
+func (site *HTMLSite) RemovePackage(ctx context.Context, output composer.Output, name string) error {
+	deleter, ok := output.(composer.Deleter)
+	if !ok {
+		return nil
+	}
+
+	return deleter.Delete(ctx, PackagePage(name))
+}
+
+// sortVersions returns the versions from newest to oldest, followed by the
+// versions which can't be parsed.
+func sortVersions(versions composer.PackageVersions) []*composer.Package {
+	type parsed struct {
+		pkg     *composer.Package
+		version *semver.Version
+	}
+
+	all := make([]*parsed, 0, len(versions))
+	for version, pkg := range versions {
+		v, _ := semver.Parse(version)
+		all = append(all, &parsed{pkg: pkg, version: v})
+	}
+
+	sort.Slice(all, func(i, j int) bool {
+		a, b := all[i], all[j]
+		if (a.version == nil) != (b.version == nil) {
+			return a.version != nil
+		}
+
+		if a.version != nil {
+			if c := semver.Compare(a.version, b.version); c != 0 {
+				return c > 0
+			}
+		}
+
+		return a.pkg.Version < b.pkg.Version
+	})
+
+	result := make([]*composer.Package, len(all))
+	for indx, p := range all {
+		result[indx] = p.pkg
+	}
+
+	return result
+}
+
+// list returns a value which is a string or a list of strings, such as a
+// license, as a list.
+func list(v interface{}) []string {
+	switch v := v.(type) {
+	case string:
+		return []string{v}
+	case []string:
+		return v
+	case []interface{}:
+		result := make([]string, 0, len(v))
+		for _, item := range v {
+			result = append(result, fmt.Sprint(item))
+		}
+		return result
+	}
+
+	return nil
+}
+
+// link is a requirement of a package, such as php >=7.1.
+type link struct {
+	Name       string
+	Constraint string
+}
+
+// links returns the links of a package sorted by name.
+func links(l composer.PackageLink) []*link {
+	result := make([]*link, 0, len(l))
+	for name, constraint := range l {
+		result = append(result, &link{Name: name, Constraint: constraint})
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+
+	return result
+}
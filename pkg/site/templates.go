@@ -0,0 +1,104 @@
+package site
+
+// defaultTemplates are the templates used unless they are overridden.
+// "head" and "foot" are shared by every page.
+const defaultTemplates = `
+{{define "head"}}<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{if .Name}}{{.Name}} - {{end}}{{.Title}}</title>
+<style>
+body { font-family: -apple-system, "Segoe UI", Helvetica, Arial, sans-serif; margin: 0 auto; max-width: 960px; padding: 0 1em; color: #222; }
+header { border-bottom: 1px solid #ddd; margin-bottom: 1em; }
+a { color: #0b5fad; }
+input[type=search] { width: 100%; font-size: 1.1em; padding: .4em; box-sizing: border-box; }
+.package { border-bottom: 1px solid #eee; padding: .6em 0; }
+.package h2, .version h3 { margin: 0 0 .2em; font-size: 1.1em; }
+.meta { color: #666; font-size: .9em; }
+pre { background: #f6f8fa; padding: .8em; overflow-x: auto; }
+.version { border-top: 1px solid #eee; padding: .6em 0; }
+dl { display: grid; grid-template-columns: max-content auto; gap: .2em 1em; margin: .4em 0; }
+dt { color: #666; }
+dd { margin: 0; }
+footer { color: #666; font-size: .8em; margin: 2em 0; }
+</style>
+</head>
+<body>
+<header><h1><a href="{{.Root}}index.html">{{.Title}}</a></h1></header>
+{{end}}
+
+{{define "foot"}}<footer>Last updated {{.Updated.Format "2006-01-02 15:04:05 MST"}}</footer>
+</body>
+</html>
+{{end}}
+
+{{define "index.html"}}{{template "head" .}}
+<p><input type="search" id="filter" placeholder="Filter packages" autofocus></p>
+<p class="meta"><span id="count">{{len .Packages}}</span> packages</p>
+<div id="packages">
+{{range .Packages}}<div class="package" data-search="{{keywords .}}">
+<h2><a href="{{page .Name}}">{{.Name}}</a>{{if .Version}} <span class="meta">{{.Version}}</span>{{end}}{{if stats}} <span class="meta">&middot; {{downloads .Name ""}} downloads</span>{{end}}</h2>
+{{if .Description}}<div>{{.Description}}</div>{{end}}
+{{if or .Type .Keywords}}<div class="meta">{{.Type}}{{if .Keywords}} &middot; {{join .Keywords ", "}}{{end}}</div>{{end}}
+{{if .Abandoned}}<div class="meta">Abandoned</div>{{end}}
+</div>
+{{end}}</div>
+<script>
+(function () {
+  var input = document.getElementById('filter');
+  var count = document.getElementById('count');
+  var packages = document.querySelectorAll('#packages .package');
+
+  input.addEventListener('input', function () {
+    var words = input.value.toLowerCase().split(/\s+/).filter(Boolean);
+    var shown = 0;
+
+    for (var i = 0; i < packages.length; i++) {
+      var text = packages[i].getAttribute('data-search');
+      var match = words.every(function (word) { return text.indexOf(word) !== -1; });
+
+      packages[i].style.display = match ? '' : 'none';
+      if (match) { shown++; }
+    }
+
+    count.textContent = shown;
+  });
+})();
+</script>
+{{template "foot" .}}{{end}}
+
+{{define "package.html"}}{{template "head" .}}
+<h2>{{.Name}}</h2>
+{{with .Latest}}
+{{if .Description}}<p>{{.Description}}</p>{{end}}
+<dl>
+{{if .Type}}<dt>Type</dt><dd>{{.Type}}</dd>{{end}}
+{{with list .License}}<dt>License</dt><dd>{{join . ", "}}</dd>{{end}}
+{{if .Homepage}}<dt>Homepage</dt><dd><a href="{{.Homepage}}">{{.Homepage}}</a></dd>{{end}}
+{{with .Source}}<dt>Source</dt><dd><a href="{{.URL}}">{{.URL}}</a></dd>{{end}}
+{{if .Authors}}<dt>Authors</dt><dd>{{range $i, $a := .Authors}}{{if $i}}, {{end}}{{if $a.Homepage}}<a href="{{$a.Homepage}}">{{$a.Name}}</a>{{else}}{{$a.Name}}{{end}}{{end}}</dd>{{end}}
+{{if .Keywords}}<dt>Keywords</dt><dd>{{join .Keywords ", "}}</dd>{{end}}
+{{if stats}}<dt>Downloads</dt><dd>{{downloads .Name ""}}</dd>{{end}}
+</dl>
+{{end}}
+<h3>Install</h3>
+<pre>composer config repositories.private composer {{.BaseURL}}
+composer require {{.Name}}</pre>
+<h3>Versions</h3>
+{{range .Versions}}<div class="version">
+<h3>{{.Version}}{{if .Time}} <span class="meta">{{.Time.Format "2006-01-02"}}</span>{{end}}{{if stats}} <span class="meta">&middot; {{downloads .Name .Version}} downloads</span>{{end}}</h3>
+<dl>
+{{with .Source}}<dt>Source</dt><dd><a href="{{.URL}}">{{.URL}}</a>{{if .Reference}} <span class="meta">{{.Reference}}</span>{{end}}</dd>{{end}}
+{{with .Dist}}<dt>Dist</dt><dd><a href="{{.URL}}">{{.Type}}</a>{{if .Shasum}} <span class="meta">sha1 {{.Shasum}}</span>{{end}}</dd>{{end}}
+{{with links .Require}}<dt>Requires</dt><dd>{{range $i, $l := .}}{{if $i}}, {{end}}{{$l.Name}} {{$l.Constraint}}{{end}}</dd>{{end}}
+{{with links .RequireDev}}<dt>Requires (dev)</dt><dd>{{range $i, $l := .}}{{if $i}}, {{end}}{{$l.Name}} {{$l.Constraint}}{{end}}</dd>{{end}}
+{{with links .Provide}}<dt>Provides</dt><dd>{{range $i, $l := .}}{{if $i}}, {{end}}{{$l.Name}} {{$l.Constraint}}{{end}}</dd>{{end}}
+{{with links .Replace}}<dt>Replaces</dt><dd>{{range $i, $l := .}}{{if $i}}, {{end}}{{$l.Name}} {{$l.Constraint}}{{end}}</dd>{{end}}
+{{with links .Conflict}}<dt>Conflicts</dt><dd>{{range $i, $l := .}}{{if $i}}, {{end}}{{$l.Name}} {{$l.Constraint}}{{end}}</dd>{{end}}
+</dl>
+</div>
+{{end}}
+{{template "foot" .}}{{end}}
+`
@@ -0,0 +1,379 @@
+// Package stats records the package downloads Composer reports to the
+// notify-batch URL of the repository, in a JSON file on disk which several
+// servers can share.
+package stats
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zachomedia/composerrepo/pkg/filelock"
+	"github.com/zachomedia/composerrepo/pkg/semver"
+)
+
+// Download is a package version Composer installed.
+type Download struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+// VersionStats are the downloads of a version of a package.
+type VersionStats struct {
+	Downloads    int64     `json:"downloads"`
+	LastDownload time.Time `json:"lastDownload"`
+}
+
+// PackageStats are the downloads of a package.
+type PackageStats struct {
+	Downloads    int64                    `json:"downloads"`
+	LastDownload time.Time                `json:"lastDownload"`
+	Versions     map[string]*VersionStats `json:"versions"`
+}
+
+// lockTimeout is how long to wait for the lock guarding Path while counts
+// are merged into it.
+const lockTimeout = 30 * time.Second
+
+// Store keeps the download counts in memory. Downloads are recorded in
+// memory, and Flush adds them to the counts in Path, so several servers can
+// share the file.
+type Store struct {
+	Path string
+
+	mux      sync.RWMutex
+	packages map[string]*PackageStats
+
+	// pending are the downloads recorded since the last flush.
+	pending  map[string]*PackageStats
+	flushMux sync.Mutex
+}
+
+// Open reads the counts stored at path, if it exists.
+func Open(path string) (*Store, error) {
+	packages, err := load(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Store{
+		Path:     path,
+		packages: packages,
+		pending:  make(map[string]*PackageStats),
+	}, nil
+}
+
+// load reads the counts stored at path, or none if it doesn't exist.
+func load(path string) (map[string]*PackageStats, error) {
+	packages := make(map[string]*PackageStats)
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return packages, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, &packages); err != nil {
+		return nil, fmt.Errorf("Unable to read %q: %v", path, err)
+	}
+
+	return packages, nil
+}
+
+// NormalizeVersion returns the version as Composer reports it, such as
+// 1.0.0.0 for 1.0.0, so versions are counted the same however they're written.
+func NormalizeVersion(version string) string {
+	v, err := semver.Parse(version)
+	if err != nil {
+		return version
+	}
+
+	return v.String()
+}
+
+// Record counts downloads. They are saved by the next Flush.
+func (store *Store) Record(downloads []*Download) {
+	store.mux.Lock()
+	defer store.mux.Unlock()
+
+	now := time.Now().UTC()
+	for _, download := range downloads {
+		name := strings.ToLower(download.Name)
+		version := NormalizeVersion(download.Version)
+
+		count(store.packages, name, version, now)
+		count(store.pending, name, version, now)
+	}
+}
+
+// count adds a download of a version to packages.
+func count(packages map[string]*PackageStats, name string, version string, now time.Time) {
+	pkg, ok := packages[name]
+	if !ok {
+		pkg = &PackageStats{Versions: make(map[string]*VersionStats)}
+		packages[name] = pkg
+	}
+
+	v, ok := pkg.Versions[version]
+	if !ok {
+		v = &VersionStats{}
+		pkg.Versions[version] = v
+	}
+
+	pkg.Downloads++
+	v.Downloads++
+	if now.After(pkg.LastDownload) {
+		pkg.LastDownload = now
+	}
+	if now.After(v.LastDownload) {
+		v.LastDownload = now
+	}
+}
+
+// merge adds the counts of added to packages.
+func merge(packages map[string]*PackageStats, added map[string]*PackageStats) {
+	for name, stats := range added {
+		pkg, ok := packages[name]
+		if !ok {
+			pkg = &PackageStats{Versions: make(map[string]*VersionStats)}
+			packages[name] = pkg
+		}
+
+		pkg.Downloads += stats.Downloads
+		if stats.LastDownload.After(pkg.LastDownload) {
+			pkg.LastDownload = stats.LastDownload
+		}
+
+		for version, vStats := range stats.Versions {
+			v, ok := pkg.Versions[version]
+			if !ok {
+				v = &VersionStats{}
+				pkg.Versions[version] = v
+			}
+
+			v.Downloads += vStats.Downloads
+			if vStats.LastDownload.After(v.LastDownload) {
+				v.LastDownload = vStats.LastDownload
+			}
+		}
+	}
+}
+
+// Flush adds the downloads recorded since the last flush to the counts in
+// Path, and reads the downloads other servers added to them. It returns
+// the names of the packages whose counts changed.
+func (store *Store) Flush() ([]string, error) {
+	store.flushMux.Lock()
+	defer store.flushMux.Unlock()
+
+	store.mux.Lock()
+	pending := store.pending
+	store.pending = make(map[string]*PackageStats)
+	store.mux.Unlock()
+
+	saved, err := store.save(pending)
+	if err != nil {
+		// Keep the downloads for the next flush
+		store.mux.Lock()
+		merge(store.pending, pending)
+		store.mux.Unlock()
+		return nil, err
+	}
+
+	store.mux.Lock()
+	defer store.mux.Unlock()
+
+	// Downloads recorded during the flush are still pending
+	merge(saved, store.pending)
+
+	changed := make([]string, 0)
+	for name, stats := range saved {
+		if current, ok := store.packages[name]; !ok || current.Downloads != stats.Downloads {
+			changed = append(changed, name)
+		}
+	}
+	sort.Strings(changed)
+
+	store.packages = saved
+	return changed, nil
+}
+
+// save adds pending to the counts in Path, holding its lock file so other
+// servers' downloads aren't lost, and returns the new counts.
+func (store *Store) save(pending map[string]*PackageStats) (map[string]*PackageStats, error) {
+	unlock, err := filelock.Lock(store.Path, lockTimeout)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	packages, err := load(store.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(pending) == 0 {
+		return packages, nil
+	}
+
+	merge(packages, pending)
+	if err := write(store.Path, packages); err != nil {
+		return nil, err
+	}
+
+	return packages, nil
+}
+
+// write writes packages to a temporary file, which replaces path so it is
+// never partially written.
+func write(path string, packages map[string]*PackageStats) error {
+	data, err := json.Marshal(packages)
+	if err != nil {
+		return err
+	}
+
+	dir, base := filepath.Split(path)
+	if dir == "" {
+		dir = "."
+	}
+
+	f, err := ioutil.TempFile(dir, "."+base+".*.tmp")
+	if err != nil {
+		return err
+	}
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+
+	if err := os.Rename(f.Name(), path); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+
+	return nil
+}
+
+// copyPackage returns a copy of stats, so it can be used without the lock.
+func copyPackage(stats *PackageStats) *PackageStats {
+	result := &PackageStats{
+		Downloads:    stats.Downloads,
+		LastDownload: stats.LastDownload,
+		Versions:     make(map[string]*VersionStats, len(stats.Versions)),
+	}
+
+	for version, v := range stats.Versions {
+		copied := *v
+		result.Versions[version] = &copied
+	}
+
+	return result
+}
+
+// Package returns the downloads of a package, or nil if it was never downloaded.
+func (store *Store) Package(name string) *PackageStats {
+	store.mux.RLock()
+	defer store.mux.RUnlock()
+
+	stats, ok := store.packages[strings.ToLower(name)]
+	if !ok {
+		return nil
+	}
+
+	return copyPackage(stats)
+}
+
+// Packages returns the downloads of every package.
+func (store *Store) Packages() map[string]*PackageStats {
+	store.mux.RLock()
+	defer store.mux.RUnlock()
+
+	result := make(map[string]*PackageStats, len(store.packages))
+	for name, stats := range store.packages {
+		result[name] = copyPackage(stats)
+	}
+
+	return result
+}
+
+// Downloads returns the downloads of a package, and of one of its versions
+// if version is set.
+func (store *Store) Downloads(name string, version string) int64 {
+	store.mux.RLock()
+	defer store.mux.RUnlock()
+
+	stats, ok := store.packages[strings.ToLower(name)]
+	if !ok {
+		return 0
+	}
+
+	if version == "" {
+		return stats.Downloads
+	}
+
+	if v, ok := stats.Versions[NormalizeVersion(version)]; ok {
+		return v.Downloads
+	}
+
+	return 0
+}
+
+// WriteMetrics writes the downloads in the Prometheus text format.
+func (store *Store) WriteMetrics(w io.Writer) error {
+	store.mux.RLock()
+	defer store.mux.RUnlock()
+
+	names := make([]string, 0, len(store.packages))
+	for name := range store.packages {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	b.WriteString("# HELP composer_package_downloads_total Downloads of each package reported by Composer.\n")
+	b.WriteString("# TYPE composer_package_downloads_total counter\n")
+	for _, name := range names {
+		fmt.Fprintf(&b, "composer_package_downloads_total{package=\"%s\"} %d\n", escapeLabel(name), store.packages[name].Downloads)
+	}
+
+	b.WriteString("# HELP composer_package_version_downloads_total Downloads of each package version reported by Composer.\n")
+	b.WriteString("# TYPE composer_package_version_downloads_total counter\n")
+	for _, name := range names {
+		versions := store.packages[name].Versions
+
+		sortedVersions := make([]string, 0, len(versions))
+		for version := range versions {
+			sortedVersions = append(sortedVersions, version)
+		}
+		sort.Strings(sortedVersions)
+
+		for _, version := range sortedVersions {
+			fmt.Fprintf(&b, "composer_package_version_downloads_total{package=\"%s\",version=\"%s\"} %d\n", escapeLabel(name), escapeLabel(version), versions[version].Downloads)
+		}
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// escapeLabel escapes a Prometheus label value.
+func escapeLabel(value string) string {
+	return labelEscaper.Replace(value)
+}
@@ -0,0 +1,326 @@
+package jsonpatch
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/zachomedia/composerrepo/pkg/config/schema"
+)
+
+// Operation is a single RFC 6902 JSON Patch operation.
+type Operation struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	From  string      `json:"from,omitempty"`
+	Value interface{} `json:"value,omitempty"`
+
+	// hasValue distinguishes a null value from a missing one.
+	hasValue bool
+}
+
+// Patch is an RFC 6902 JSON Patch document.
+type Patch []*Operation
+
+// ParsePatch parses and validates a JSON Patch document.
+func ParsePatch(data []byte) (Patch, error) {
+	raw := make([]map[string]json.RawMessage, 0)
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+
+	patch := make(Patch, len(raw))
+	for indx, rawOp := range raw {
+		op := &Operation{}
+
+		for key, field := range map[string]*string{"op": &op.Op, "path": &op.Path, "from": &op.From} {
+			if value, ok := rawOp[key]; ok {
+				if err := json.Unmarshal(value, field); err != nil {
+					return nil, fmt.Errorf("operation %d: expected %q to be a string", indx, key)
+				}
+			}
+		}
+
+		if value, ok := rawOp["value"]; ok {
+			op.hasValue = true
+			if err := json.Unmarshal(value, &op.Value); err != nil {
+				return nil, fmt.Errorf("operation %d: %v", indx, err)
+			}
+		}
+
+		if err := op.validate(rawOp); err != nil {
+			return nil, fmt.Errorf("operation %d: %v", indx, err)
+		}
+
+		patch[indx] = op
+	}
+
+	return patch, nil
+}
+
+func (op *Operation) validate(raw map[string]json.RawMessage) error {
+	if _, ok := raw["path"]; !ok {
+		return fmt.Errorf("missing \"path\"")
+	}
+	if _, err := parsePointer(op.Path); err != nil {
+		return err
+	}
+
+	switch op.Op {
+	case "add", "replace", "test":
+		if !op.hasValue {
+			return fmt.Errorf("%q requires a \"value\"", op.Op)
+		}
+	case "move", "copy":
+		if _, ok := raw["from"]; !ok {
+			return fmt.Errorf("%q requires \"from\"", op.Op)
+		}
+		if _, err := parsePointer(op.From); err != nil {
+			return err
+		}
+	case "remove":
+	default:
+		return fmt.Errorf("unknown operation %q", op.Op)
+	}
+
+	return nil
+}
+
+// parsePointer splits an RFC 6901 JSON Pointer into its unescaped tokens.
+func parsePointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return []string{}, nil
+	}
+
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("invalid pointer %q, expected it to start with \"/\"", pointer)
+	}
+
+	tokens := strings.Split(pointer[1:], "/")
+	for indx, token := range tokens {
+		tokens[indx] = strings.Replace(strings.Replace(token, "~1", "/", -1), "~0", "~", -1)
+	}
+
+	return tokens, nil
+}
+
+// arrayIndex parses token as an index into a list of length n.
+// The index n is only valid when appending.
+func arrayIndex(token string, n int, appending bool) (int, error) {
+	if appending && token == "-" {
+		return n, nil
+	}
+
+	indx, err := strconv.Atoi(token)
+	if err != nil || indx < 0 || (token != "0" && strings.HasPrefix(token, "0")) {
+		return 0, fmt.Errorf("invalid list index %q", token)
+	}
+
+	max := n - 1
+	if appending {
+		max = n
+	}
+	if indx > max {
+		return 0, fmt.Errorf("list index %d out of range", indx)
+	}
+
+	return indx, nil
+}
+
+// get returns the value at tokens within doc.
+func get(doc interface{}, tokens []string) (interface{}, error) {
+	current := doc
+
+	for _, token := range tokens {
+		switch node := current.(type) {
+		case map[string]interface{}:
+			value, ok := node[token]
+			if !ok {
+				return nil, fmt.Errorf("field %q not found", token)
+			}
+			current = value
+		case []interface{}:
+			indx, err := arrayIndex(token, len(node), false)
+			if err != nil {
+				return nil, err
+			}
+			current = node[indx]
+		default:
+			return nil, fmt.Errorf("cannot find %q in a scalar value", token)
+		}
+	}
+
+	return current, nil
+}
+
+// update replaces the value at tokens by calling fn with the parent
+// container, returning the new document.
+func update(doc interface{}, tokens []string, fn func(parent interface{}, token string) (interface{}, error)) (interface{}, error) {
+	if len(tokens) == 0 {
+		return fn(nil, "")
+	}
+
+	if len(tokens) == 1 {
+		return fn(doc, tokens[0])
+	}
+
+	switch node := doc.(type) {
+	case map[string]interface{}:
+		child, ok := node[tokens[0]]
+		if !ok {
+			return nil, fmt.Errorf("field %q not found", tokens[0])
+		}
+
+		updated, err := update(child, tokens[1:], fn)
+		if err != nil {
+			return nil, err
+		}
+		node[tokens[0]] = updated
+		return node, nil
+
+	case []interface{}:
+		indx, err := arrayIndex(tokens[0], len(node), false)
+		if err != nil {
+			return nil, err
+		}
+
+		updated, err := update(node[indx], tokens[1:], fn)
+		if err != nil {
+			return nil, err
+		}
+		node[indx] = updated
+		return node, nil
+	}
+
+	return nil, fmt.Errorf("cannot find %q in a scalar value", tokens[0])
+}
+
+func add(doc interface{}, tokens []string, value interface{}) (interface{}, error) {
+	return update(doc, tokens, func(parent interface{}, token string) (interface{}, error) {
+		switch node := parent.(type) {
+		case nil:
+			return value, nil
+		case map[string]interface{}:
+			node[token] = value
+			return node, nil
+		case []interface{}:
+			indx, err := arrayIndex(token, len(node), true)
+			if err != nil {
+				return nil, err
+			}
+
+			node = append(node, nil)
+			copy(node[indx+1:], node[indx:])
+			node[indx] = value
+			return node, nil
+		}
+
+		return nil, fmt.Errorf("cannot add %q to a scalar value", token)
+	})
+}
+
+func remove(doc interface{}, tokens []string) (interface{}, error) {
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("cannot remove the whole document")
+	}
+
+	return update(doc, tokens, func(parent interface{}, token string) (interface{}, error) {
+		switch node := parent.(type) {
+		case map[string]interface{}:
+			if _, ok := node[token]; !ok {
+				return nil, fmt.Errorf("field %q not found", token)
+			}
+			delete(node, token)
+			return node, nil
+		case []interface{}:
+			indx, err := arrayIndex(token, len(node), false)
+			if err != nil {
+				return nil, err
+			}
+			return append(node[:indx], node[indx+1:]...), nil
+		}
+
+		return nil, fmt.Errorf("cannot remove %q from a scalar value", token)
+	})
+}
+
+// Apply applies the patch to doc, returning the patched document. On error,
+// doc may be partially modified and should be discarded.
+func (patch Patch) Apply(doc interface{}) (interface{}, error) {
+	for indx, op := range patch {
+		var err error
+
+		path, _ := parsePointer(op.Path)
+		switch op.Op {
+		case "add":
+			doc, err = add(doc, path, schema.Normalize(op.Value))
+
+		case "remove":
+			doc, err = remove(doc, path)
+
+		case "replace":
+			if _, err = get(doc, path); err == nil {
+				if len(path) == 0 {
+					doc = schema.Normalize(op.Value)
+				} else if doc, err = remove(doc, path); err == nil {
+					doc, err = add(doc, path, schema.Normalize(op.Value))
+				}
+			}
+
+		case "move":
+			var value interface{}
+			from, _ := parsePointer(op.From)
+			if strings.HasPrefix(op.Path+"/", op.From+"/") && op.Path != op.From {
+				err = fmt.Errorf("cannot move %q into itself", op.From)
+			} else if value, err = get(doc, from); err == nil {
+				if doc, err = remove(doc, from); err == nil {
+					doc, err = add(doc, path, value)
+				}
+			}
+
+		case "copy":
+			var value interface{}
+			from, _ := parsePointer(op.From)
+			if value, err = get(doc, from); err == nil {
+				doc, err = add(doc, path, schema.Normalize(value))
+			}
+
+		case "test":
+			var value interface{}
+			if value, err = get(doc, path); err == nil && !reflect.DeepEqual(value, op.Value) {
+				err = fmt.Errorf("test failed")
+			}
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("operation %d (%s %s): %v", indx, op.Op, op.Path, err)
+		}
+	}
+
+	return doc, nil
+}
+
+// MergePatch applies an RFC 7396 JSON Merge Patch to doc.
+func MergePatch(doc interface{}, patch interface{}) interface{} {
+	patchMap, ok := patch.(map[string]interface{})
+	if !ok {
+		return schema.Normalize(patch)
+	}
+
+	docMap, ok := doc.(map[string]interface{})
+	if !ok {
+		docMap = make(map[string]interface{})
+	}
+
+	for k, v := range patchMap {
+		if v == nil {
+			delete(docMap, k)
+		} else {
+			docMap[k] = MergePatch(docMap[k], v)
+		}
+	}
+
+	return docMap
+}
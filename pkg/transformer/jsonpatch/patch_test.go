@@ -0,0 +1,142 @@
+package jsonpatch
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func decode(t *testing.T, s string) interface{} {
+	var v interface{}
+	if err := json.Unmarshal([]byte(s), &v); err != nil {
+		t.Fatalf("Invalid JSON %q: %v", s, err)
+	}
+
+	return v
+}
+
+// The examples of RFC 6902 appendix A.
+func TestPatchApply(t *testing.T) {
+	tests := []struct {
+		name  string
+		doc   string
+		patch string
+		want  string
+	}{
+		{"add field", `{"foo":"bar"}`, `[{"op":"add","path":"/baz","value":"qux"}]`, `{"baz":"qux","foo":"bar"}`},
+		{"add list element", `{"foo":["bar","baz"]}`, `[{"op":"add","path":"/foo/1","value":"qux"}]`, `{"foo":["bar","qux","baz"]}`},
+		{"append list element", `{"foo":["bar"]}`, `[{"op":"add","path":"/foo/-","value":["abc","def"]}]`, `{"foo":["bar",["abc","def"]]}`},
+		{"add null", `{}`, `[{"op":"add","path":"/foo","value":null}]`, `{"foo":null}`},
+		{"add replacing field", `{"foo":1}`, `[{"op":"add","path":"/foo","value":2}]`, `{"foo":2}`},
+		{"add whole document", `{"foo":1}`, `[{"op":"add","path":"","value":[1]}]`, `[1]`},
+		{"remove field", `{"baz":"qux","foo":"bar"}`, `[{"op":"remove","path":"/baz"}]`, `{"foo":"bar"}`},
+		{"remove list element", `{"foo":["bar","qux","baz"]}`, `[{"op":"remove","path":"/foo/1"}]`, `{"foo":["bar","baz"]}`},
+		{"replace", `{"baz":"qux","foo":"bar"}`, `[{"op":"replace","path":"/baz","value":"boo"}]`, `{"baz":"boo","foo":"bar"}`},
+		{"move field", `{"foo":{"bar":"baz","waldo":"fred"},"qux":{"corge":"grault"}}`, `[{"op":"move","from":"/foo/waldo","path":"/qux/thud"}]`, `{"foo":{"bar":"baz"},"qux":{"corge":"grault","thud":"fred"}}`},
+		{"move list element", `{"foo":["all","grass","cows","eat"]}`, `[{"op":"move","from":"/foo/1","path":"/foo/3"}]`, `{"foo":["all","cows","eat","grass"]}`},
+		{"copy", `{"foo":{"bar":1}}`, `[{"op":"copy","from":"/foo","path":"/baz"}]`, `{"foo":{"bar":1},"baz":{"bar":1}}`},
+		{"test", `{"baz":"qux","foo":["a",2,"c"]}`, `[{"op":"test","path":"/baz","value":"qux"},{"op":"test","path":"/foo/1","value":2}]`, `{"baz":"qux","foo":["a",2,"c"]}`},
+		{"escaped pointer", `{"/":9,"~1":10}`, `[{"op":"test","path":"/~01","value":10},{"op":"remove","path":"/~1"}]`, `{"~1":10}`},
+		{"nested", `{"require":{"php":">=5.6"}}`, `[{"op":"add","path":"/require/ext-json","value":"*"},{"op":"remove","path":"/require/php"}]`, `{"require":{"ext-json":"*"}}`},
+	}
+
+	for _, test := range tests {
+		patch, err := ParsePatch([]byte(test.patch))
+		if err != nil {
+			t.Errorf("%s: ParsePatch returned error: %v", test.name, err)
+			continue
+		}
+
+		got, err := patch.Apply(decode(t, test.doc))
+		if err != nil {
+			t.Errorf("%s: Apply returned error: %v", test.name, err)
+			continue
+		}
+
+		if want := decode(t, test.want); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: Apply = %v, want %v", test.name, got, want)
+		}
+	}
+}
+
+func TestPatchApplyErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		doc   string
+		patch string
+	}{
+		{"add to missing parent", `{"foo":"bar"}`, `[{"op":"add","path":"/baz/bat","value":"qux"}]`},
+		{"add past end of list", `{"foo":["bar"]}`, `[{"op":"add","path":"/foo/2","value":"qux"}]`},
+		{"remove missing field", `{"foo":"bar"}`, `[{"op":"remove","path":"/baz"}]`},
+		{"replace missing field", `{"foo":"bar"}`, `[{"op":"replace","path":"/baz","value":1}]`},
+		{"invalid list index", `{"foo":["bar"]}`, `[{"op":"remove","path":"/foo/01"}]`},
+		{"move into itself", `{"foo":{"bar":1}}`, `[{"op":"move","from":"/foo","path":"/foo/bar/baz"}]`},
+		{"failed test", `{"baz":"qux"}`, `[{"op":"test","path":"/baz","value":"bar"}]`},
+		{"test number against string", `{"baz":"1"}`, `[{"op":"test","path":"/baz","value":1}]`},
+	}
+
+	for _, test := range tests {
+		patch, err := ParsePatch([]byte(test.patch))
+		if err != nil {
+			t.Errorf("%s: ParsePatch returned error: %v", test.name, err)
+			continue
+		}
+
+		if got, err := patch.Apply(decode(t, test.doc)); err == nil {
+			t.Errorf("%s: Apply = %v, want error", test.name, got)
+		}
+	}
+}
+
+func TestParsePatchInvalid(t *testing.T) {
+	tests := []struct {
+		name  string
+		patch string
+	}{
+		{"not a list", `{"op":"add","path":"/a","value":1}`},
+		{"unknown operation", `[{"op":"frobnicate","path":"/a"}]`},
+		{"missing path", `[{"op":"remove"}]`},
+		{"relative path", `[{"op":"remove","path":"a"}]`},
+		{"add without value", `[{"op":"add","path":"/a"}]`},
+		{"move without from", `[{"op":"move","path":"/a"}]`},
+		{"path not a string", `[{"op":"remove","path":1}]`},
+	}
+
+	for _, test := range tests {
+		if _, err := ParsePatch([]byte(test.patch)); err == nil {
+			t.Errorf("%s: ParsePatch returned no error", test.name)
+		}
+	}
+}
+
+// The examples of RFC 7396 appendix A.
+func TestMergePatch(t *testing.T) {
+	tests := []struct {
+		doc   string
+		patch string
+		want  string
+	}{
+		{`{"a":"b"}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"b"}`, `{"b":"c"}`, `{"a":"b","b":"c"}`},
+		{`{"a":"b"}`, `{"a":null}`, `{}`},
+		{`{"a":"b","b":"c"}`, `{"a":null}`, `{"b":"c"}`},
+		{`{"a":["b"]}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"c"}`, `{"a":["b"]}`, `{"a":["b"]}`},
+		{`{"a":{"b":"c"}}`, `{"a":{"b":"d","c":null}}`, `{"a":{"b":"d"}}`},
+		{`{"a":[{"b":"c"}]}`, `{"a":[1]}`, `{"a":[1]}`},
+		{`["a","b"]`, `["c","d"]`, `["c","d"]`},
+		{`{"a":"b"}`, `["c"]`, `["c"]`},
+		{`{"a":"foo"}`, `null`, `null`},
+		{`{"a":"foo"}`, `"bar"`, `"bar"`},
+		{`{"e":null}`, `{"a":1}`, `{"e":null,"a":1}`},
+		{`[1,2]`, `{"a":"b","c":null}`, `{"a":"b"}`},
+		{`{}`, `{"a":{"bb":{"ccc":null}}}`, `{"a":{"bb":{}}}`},
+	}
+
+	for _, test := range tests {
+		got := MergePatch(decode(t, test.doc), decode(t, test.patch))
+		if want := decode(t, test.want); !reflect.DeepEqual(got, want) {
+			t.Errorf("MergePatch(%s, %s) = %v, want %v", test.doc, test.patch, got, want)
+		}
+	}
+}
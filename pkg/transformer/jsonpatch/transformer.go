@@ -0,0 +1,198 @@
+// Package jsonpatch applies RFC 6902 JSON Patch and RFC 7396 JSON Merge
+// Patch documents to the composer.json of packages.
+package jsonpatch
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/zachomedia/composerrepo/pkg/composer"
+	"github.com/zachomedia/composerrepo/pkg/config/schema"
+	"github.com/zachomedia/composerrepo/pkg/logging"
+	"github.com/zachomedia/composerrepo/pkg/transformer/match"
+	yaml "gopkg.in/yaml.v2"
+)
+
+type TransformerConfig struct {
+	Packages       []string      `config:"packages"`
+	Match          string        `config:"match"`
+	Patch          []interface{} `config:"patch"`
+	PatchFile      string        `config:"patchFile"`
+	MergePatch     interface{}   `config:"mergePatch"`
+	MergePatchFile string        `config:"mergePatchFile"`
+}
+
+// JSONPatchTransformer patches the composer.json of matching packages.
+// The merge patch is applied before the JSON patch.
+type JSONPatchTransformer struct {
+	ID         int
+	Packages   *match.Matcher
+	Patch      Patch
+	MergePatch interface{}
+
+	log *logging.Logger
+}
+
+// SetLogger sets the logger of the transformer.
+func (transformer *JSONPatchTransformer) SetLogger(log *logging.Logger) {
+	transformer.log = log
+}
+
+func (transformer *JSONPatchTransformer) Init(id int, conf map[string]interface{}) error {
+	config := TransformerConfig{
+		Packages: make([]string, 0),
+		Match:    match.Glob,
+	}
+	if err := schema.Decode(conf, &config); err != nil {
+		return err
+	}
+
+	packages, err := match.New(config.Packages, config.Match)
+	if err != nil {
+		return err
+	}
+
+	transformer.ID = id
+	transformer.Packages = packages
+
+	var inlinePatch interface{}
+	if config.Patch != nil {
+		inlinePatch = config.Patch
+	}
+
+	patch, err := loadDocument("patch", inlinePatch, "patchFile", config.PatchFile)
+	if err != nil {
+		return err
+	}
+
+	if patch != nil {
+		b, err := json.Marshal(patch)
+		if err != nil {
+			return &schema.FieldError{Path: "patch", Message: err.Error()}
+		}
+
+		transformer.Patch, err = ParsePatch(b)
+		if err != nil {
+			return &schema.FieldError{Path: "patch", Message: err.Error()}
+		}
+	}
+
+	transformer.MergePatch, err = loadDocument("mergePatch", config.MergePatch, "mergePatchFile", config.MergePatchFile)
+	if err != nil {
+		return err
+	}
+
+	if transformer.Patch == nil && transformer.MergePatch == nil {
+		return &schema.FieldError{Path: "patch", Message: "one of patch, patchFile, mergePatch or mergePatchFile is required"}
+	}
+
+	return nil
+}
+
+// loadDocument returns the inline document, or the document read from
+// file. The file may be JSON or YAML. Numbers are decoded as they would be
+// from JSON, so they compare equal to the package's values.
+func loadDocument(field string, inline interface{}, fileField string, file string) (interface{}, error) {
+	var doc interface{}
+
+	switch {
+	case inline != nil && file != "":
+		return nil, &schema.FieldError{Path: fileField, Message: fmt.Sprintf("cannot be used with %s", field)}
+
+	case file != "":
+		b, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, &schema.FieldError{Path: fileField, Message: err.Error()}
+		}
+
+		if err := yaml.Unmarshal(b, &doc); err != nil {
+			return nil, &schema.FieldError{Path: fileField, Message: fmt.Sprintf("unable to parse %s: %v", file, err)}
+		}
+
+		if doc == nil {
+			return nil, &schema.FieldError{Path: fileField, Message: fmt.Sprintf("%s is empty", file)}
+		}
+
+	case inline != nil:
+		doc = inline
+
+	default:
+		return nil, nil
+	}
+
+	b, err := json.Marshal(schema.Normalize(doc))
+	if err != nil {
+		return nil, &schema.FieldError{Path: field, Message: err.Error()}
+	}
+
+	var normalized interface{}
+	if err := json.Unmarshal(b, &normalized); err != nil {
+		return nil, &schema.FieldError{Path: field, Message: err.Error()}
+	}
+
+	return normalized, nil
+}
+
+func (transformer *JSONPatchTransformer) GetID() int {
+	return transformer.ID
+}
+
+func (transformer *JSONPatchTransformer) Transform(ctx context.Context, input composer.Input, name string, pkg composer.PackageVersions) error {
+	if !transformer.Packages.Matches(name) {
+		return nil
+	}
+
+	transformer.log.WithContext(ctx).Debug("Patching package", "package", name)
+
+	for version, vers := range pkg {
+		err := transformer.transformVersion(vers)
+		if err != nil {
+			return fmt.Errorf("Unable to patch %s@%s: %v", name, version, err)
+		}
+	}
+
+	return nil
+}
+
+// transformVersion patches the composer.json representation of vers.
+func (transformer *JSONPatchTransformer) transformVersion(vers *composer.Package) error {
+	b, err := json.Marshal(vers)
+	if err != nil {
+		return err
+	}
+
+	var doc interface{}
+	if err := json.Unmarshal(b, &doc); err != nil {
+		return err
+	}
+
+	if transformer.MergePatch != nil {
+		doc = MergePatch(doc, transformer.MergePatch)
+	}
+
+	if transformer.Patch != nil {
+		doc, err = transformer.Patch.Apply(doc)
+		if err != nil {
+			return err
+		}
+	}
+
+	if _, ok := doc.(map[string]interface{}); !ok {
+		return fmt.Errorf("expected the patched package to be an object")
+	}
+
+	b, err = json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+
+	var transformed composer.Package
+	if err := json.Unmarshal(b, &transformed); err != nil {
+		return err
+	}
+
+	*vers = transformed
+	return nil
+}
@@ -0,0 +1,87 @@
+package jsonpatch
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/zachomedia/composerrepo/pkg/composer"
+)
+
+func TestTransformVersion(t *testing.T) {
+	tests := []struct {
+		name string
+		conf map[string]interface{}
+		pkg  string
+		want string
+	}{
+		{
+			"modelled field",
+			map[string]interface{}{"patch": []interface{}{
+				map[string]interface{}{"op": "add", "path": "/require/php", "value": ">=7.1"},
+			}},
+			`{"name":"a/b","require":{"ext-json":"*"}}`,
+			`{"name":"a/b","require":{"ext-json":"*","php":">=7.1"}}`,
+		},
+		{
+			"unmodelled fields",
+			map[string]interface{}{"patch": []interface{}{
+				map[string]interface{}{"op": "add", "path": "/funding", "value": []interface{}{
+					map[string]interface{}{"type": "github", "url": "https://github.com/sponsors/acme"},
+				}},
+				map[string]interface{}{"op": "add", "path": "/x-custom", "value": map[string]interface{}{"level": 3}},
+			}},
+			`{"name":"a/b"}`,
+			`{"name":"a/b","funding":[{"type":"github","url":"https://github.com/sponsors/acme"}],"x-custom":{"level":3}}`,
+		},
+		{
+			"existing unmodelled fields are kept",
+			map[string]interface{}{"mergePatch": map[string]interface{}{"type": "library"}},
+			`{"name":"a/b","funding":[{"type":"other","url":"https://example.com"}]}`,
+			`{"name":"a/b","type":"library","funding":[{"type":"other","url":"https://example.com"}]}`,
+		},
+		{
+			"unmodelled fields are removed",
+			map[string]interface{}{"mergePatch": map[string]interface{}{"funding": nil}},
+			`{"name":"a/b","funding":[]}`,
+			`{"name":"a/b"}`,
+		},
+		{
+			"test unmodelled field",
+			map[string]interface{}{"patch": []interface{}{
+				map[string]interface{}{"op": "test", "path": "/x-custom", "value": "v1"},
+				map[string]interface{}{"op": "replace", "path": "/x-custom", "value": "v2"},
+			}},
+			`{"name":"a/b","x-custom":"v1"}`,
+			`{"name":"a/b","x-custom":"v2"}`,
+		},
+	}
+
+	for _, test := range tests {
+		transformer := &JSONPatchTransformer{}
+		if err := transformer.Init(0, test.conf); err != nil {
+			t.Errorf("%s: Init returned error: %v", test.name, err)
+			continue
+		}
+
+		var pkg composer.Package
+		if err := json.Unmarshal([]byte(test.pkg), &pkg); err != nil {
+			t.Fatalf("%s: invalid package: %v", test.name, err)
+		}
+
+		if err := transformer.transformVersion(&pkg); err != nil {
+			t.Errorf("%s: transformVersion returned error: %v", test.name, err)
+			continue
+		}
+
+		got, err := json.Marshal(&pkg)
+		if err != nil {
+			t.Errorf("%s: unable to marshal the package: %v", test.name, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(decode(t, string(got)), decode(t, test.want)) {
+			t.Errorf("%s: transformVersion = %s, want %s", test.name, got, test.want)
+		}
+	}
+}
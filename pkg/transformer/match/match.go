@@ -0,0 +1,109 @@
+package match
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/zachomedia/composerrepo/pkg/config/schema"
+)
+
+const (
+	// Glob matches package names with shell patterns, such as "vendor/*".
+	// "*" matches any characters, including "/", "?" matches one character
+	// and "[...]" matches one of a class of characters.
+	Glob = "glob"
+
+	// Regex matches package names with regular expressions, which must match
+	// the whole name.
+	Regex = "regex"
+)
+
+// Matcher matches package names against a list of patterns.
+// An empty list of patterns matches every package.
+type Matcher struct {
+	Patterns []*regexp.Regexp
+}
+
+// New compiles patterns of the given match type. Errors refer to the
+// patterns as the "packages" field.
+func New(patterns []string, matchType string) (*Matcher, error) {
+	if matchType != Glob && matchType != Regex && matchType != "" {
+		return nil, &schema.FieldError{Path: "match", Message: fmt.Sprintf("unknown match type %q, expected %q or %q", matchType, Glob, Regex)}
+	}
+
+	matcher := &Matcher{
+		Patterns: make([]*regexp.Regexp, 0, len(patterns)),
+	}
+
+	for indx, pattern := range patterns {
+		path := fmt.Sprintf("packages[%d]", indx)
+
+		expr := pattern
+		if matchType != Regex {
+			var err error
+			if expr, err = globToRegexp(pattern); err != nil {
+				return nil, &schema.FieldError{Path: path, Message: fmt.Sprintf("invalid pattern %q: %v", pattern, err)}
+			}
+		}
+
+		// Patterns match the whole name, so foo/bar doesn't match xfoo/barbaz
+		r, err := regexp.Compile("^(?:" + expr + ")$")
+		if err != nil {
+			return nil, &schema.FieldError{Path: path, Message: err.Error()}
+		}
+		matcher.Patterns = append(matcher.Patterns, r)
+	}
+
+	return matcher, nil
+}
+
+// globToRegexp converts a shell pattern to a regular expression.
+func globToRegexp(pattern string) (string, error) {
+	var expr strings.Builder
+
+	for indx := 0; indx < len(pattern); indx++ {
+		switch c := pattern[indx]; c {
+		case '*':
+			expr.WriteString(".*")
+		case '?':
+			expr.WriteString(".")
+		case '\\':
+			if indx+1 == len(pattern) {
+				return "", fmt.Errorf("trailing \\")
+			}
+			indx++
+			expr.WriteString(regexp.QuoteMeta(pattern[indx : indx+1]))
+		case '[':
+			end := strings.IndexByte(pattern[indx+1:], ']')
+			if end < 0 {
+				return "", fmt.Errorf("unterminated [")
+			}
+			class := pattern[indx+1 : indx+1+end]
+			if class == "" || class == "^" {
+				return "", fmt.Errorf("empty character class")
+			}
+			expr.WriteString("[" + class + "]")
+			indx += end + 1
+		default:
+			expr.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+
+	return expr.String(), nil
+}
+
+// Matches reports whether name matches any of the patterns.
+func (matcher *Matcher) Matches(name string) bool {
+	if len(matcher.Patterns) == 0 {
+		return true
+	}
+
+	for _, r := range matcher.Patterns {
+		if r.MatchString(name) {
+			return true
+		}
+	}
+
+	return false
+}
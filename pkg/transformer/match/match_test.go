@@ -0,0 +1,71 @@
+package match
+
+import "testing"
+
+func TestMatches(t *testing.T) {
+	tests := []struct {
+		patterns  []string
+		matchType string
+		name      string
+		matches   bool
+	}{
+		{nil, Glob, "vendor/package", true},
+		{[]string{"vendor/package"}, Glob, "vendor/package", true},
+		{[]string{"vendor/package"}, Glob, "vendor/package2", false},
+		{[]string{"vendor/*"}, Glob, "vendor/package", true},
+		{[]string{"vendor/*"}, Glob, "other/package", false},
+		{[]string{"vendor*"}, Glob, "vendor/package", true},
+		{[]string{"*"}, Glob, "vendor/package", true},
+		{[]string{"drupal-*/*"}, Glob, "drupal-x/foo", true},
+		{[]string{"*/foo"}, Glob, "vendor/foo", true},
+		{[]string{"*/foo"}, Glob, "vendor/foobar", false},
+		{[]string{"vendor/pack?ge"}, Glob, "vendor/package", true},
+		{[]string{"vendor/[a-c]*"}, Glob, "vendor/cake", true},
+		{[]string{"vendor/[^a-c]*"}, Glob, "vendor/cake", false},
+		{[]string{"vendor/a.b"}, Glob, "vendor/aXb", false},
+		{[]string{`vendor/\*`}, Glob, "vendor/*", true},
+		{[]string{`vendor/\*`}, Glob, "vendor/package", false},
+		{[]string{"a/*", "b/*"}, Glob, "b/package", true},
+		{[]string{"vendor/package"}, "", "vendor/package", true},
+
+		{[]string{"foo/bar"}, Regex, "foo/bar", true},
+		{[]string{"foo/bar"}, Regex, "xfoo/barbaz", false},
+		{[]string{"foo/.*"}, Regex, "foo/bar", true},
+		{[]string{"foo|bar"}, Regex, "foo", true},
+		{[]string{"foo|bar"}, Regex, "foobar", false},
+		{[]string{"drupal/(core|views)"}, Regex, "drupal/views", true},
+	}
+
+	for _, test := range tests {
+		matcher, err := New(test.patterns, test.matchType)
+		if err != nil {
+			t.Errorf("New(%q, %q) returned error: %v", test.patterns, test.matchType, err)
+			continue
+		}
+
+		if got := matcher.Matches(test.name); got != test.matches {
+			t.Errorf("%s %q matches %q = %t, want %t", test.matchType, test.patterns, test.name, got, test.matches)
+		}
+	}
+}
+
+func TestNewInvalid(t *testing.T) {
+	tests := []struct {
+		patterns  []string
+		matchType string
+		err       string
+	}{
+		{[]string{"vendor/[abc"}, Glob, "packages[0]: invalid pattern"},
+		{[]string{"ok", `vendor\`}, Glob, "packages[1]: invalid pattern"},
+		{[]string{"vendor/[]"}, Glob, "packages[0]: invalid pattern"},
+		{[]string{"vendor/(x"}, Regex, "packages[0]: "},
+		{nil, "prefix", "match: unknown match type"},
+	}
+
+	for _, test := range tests {
+		_, err := New(test.patterns, test.matchType)
+		if err == nil || len(err.Error()) < len(test.err) || err.Error()[:len(test.err)] != test.err {
+			t.Errorf("New(%q, %q) = %v, want error starting with %q", test.patterns, test.matchType, err, test.err)
+		}
+	}
+}
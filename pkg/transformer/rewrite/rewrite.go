@@ -0,0 +1,208 @@
+// Package rewrite rewrites the dist and source URLs of packages, such as to
+// download through an internal mirror or a CDN.
+package rewrite
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/zachomedia/composerrepo/pkg/composer"
+	"github.com/zachomedia/composerrepo/pkg/config/schema"
+)
+
+const (
+	// Prefix rules replace the start of a URL.
+	Prefix = "prefix"
+
+	// Regex rules replace matches of a regular expression, with $1 style
+	// references to its groups.
+	Regex = "regex"
+
+	// Dist and Source name the URLs a rule applies to.
+	Dist   = "dist"
+	Source = "source"
+)
+
+type RuleConfig struct {
+	Inputs []string `config:"inputs"`
+	Match  string   `config:"match"`
+	From   string   `config:"from,required"`
+	To     string   `config:"to"`
+	URLs   []string `config:"urls"`
+}
+
+type MirrorConfig struct {
+	DistURL   string `config:"distURL"`
+	GitURL    string `config:"gitURL"`
+	HgURL     string `config:"hgURL"`
+	Preferred bool   `config:"preferred"`
+}
+
+type TransformerConfig struct {
+	Rules   []RuleConfig   `config:"rules"`
+	Mirrors []MirrorConfig `config:"mirrors"`
+}
+
+// Rule rewrites URLs starting with, or matching, From.
+type Rule struct {
+	Inputs map[string]bool
+	Prefix string
+	Regex  *regexp.Regexp
+	To     string
+	Dist   bool
+	Source bool
+}
+
+// RewriteURLsTransformer applies the first matching rule to each dist and
+// source URL, and adds mirrors to packages.json.
+type RewriteURLsTransformer struct {
+	ID      int
+	Rules   []*Rule
+	Mirrors []*composer.Mirror
+}
+
+func (transformer *RewriteURLsTransformer) Init(id int, conf map[string]interface{}) error {
+	config := TransformerConfig{}
+	if err := schema.Decode(conf, &config); err != nil {
+		return err
+	}
+
+	transformer.ID = id
+
+	errs := schema.Errors{}
+
+	transformer.Rules = make([]*Rule, 0, len(config.Rules))
+	for indx, ruleConfig := range config.Rules {
+		rule, err := newRule(ruleConfig)
+		if err != nil {
+			errs.Add(fmt.Sprintf("rules[%d]", indx), err)
+			continue
+		}
+		transformer.Rules = append(transformer.Rules, rule)
+	}
+
+	transformer.Mirrors = make([]*composer.Mirror, 0, len(config.Mirrors))
+	for indx, mirrorConfig := range config.Mirrors {
+		if mirrorConfig.DistURL == "" && mirrorConfig.GitURL == "" && mirrorConfig.HgURL == "" {
+			errs.Add(fmt.Sprintf("mirrors[%d]", indx), &schema.FieldError{Message: "one of distURL, gitURL or hgURL is required"})
+			continue
+		}
+
+		transformer.Mirrors = append(transformer.Mirrors, &composer.Mirror{
+			DistURL:   mirrorConfig.DistURL,
+			GitURL:    mirrorConfig.GitURL,
+			HgURL:     mirrorConfig.HgURL,
+			Preferred: mirrorConfig.Preferred,
+		})
+	}
+
+	if len(transformer.Rules) == 0 && len(transformer.Mirrors) == 0 && len(errs) == 0 {
+		errs.Add("rules", &schema.FieldError{Message: "one of rules or mirrors is required"})
+	}
+
+	return errs.Err()
+}
+
+func newRule(config RuleConfig) (*Rule, error) {
+	rule := &Rule{
+		To: config.To,
+	}
+
+	if len(config.Inputs) > 0 {
+		rule.Inputs = make(map[string]bool)
+		for _, input := range config.Inputs {
+			rule.Inputs[input] = true
+		}
+	}
+
+	switch config.Match {
+	case Prefix, "":
+		rule.Prefix = config.From
+	case Regex:
+		re, err := regexp.Compile(config.From)
+		if err != nil {
+			return nil, &schema.FieldError{Path: "from", Message: fmt.Sprintf("invalid regular expression: %v", err)}
+		}
+		rule.Regex = re
+	default:
+		return nil, &schema.FieldError{Path: "match", Message: fmt.Sprintf("expected %q or %q", Prefix, Regex)}
+	}
+
+	if len(config.URLs) == 0 {
+		rule.Dist = true
+		rule.Source = true
+	}
+	for indx, url := range config.URLs {
+		switch url {
+		case Dist:
+			rule.Dist = true
+		case Source:
+			rule.Source = true
+		default:
+			return nil, &schema.FieldError{Path: fmt.Sprintf("urls[%d]", indx), Message: fmt.Sprintf("expected %q or %q", Dist, Source)}
+		}
+	}
+
+	return rule, nil
+}
+
+// Rewrite returns the rewritten url, and whether the rule matched.
+func (rule *Rule) Rewrite(url string) (string, bool) {
+	if rule.Regex != nil {
+		if !rule.Regex.MatchString(url) {
+			return url, false
+		}
+		return rule.Regex.ReplaceAllString(url, rule.To), true
+	}
+
+	if !strings.HasPrefix(url, rule.Prefix) {
+		return url, false
+	}
+
+	return rule.To + strings.TrimPrefix(url, rule.Prefix), true
+}
+
+func (transformer *RewriteURLsTransformer) GetID() int {
+	return transformer.ID
+}
+
+func (transformer *RewriteURLsTransformer) Transform(ctx context.Context, input composer.Input, name string, pkg composer.PackageVersions) error {
+	for _, vers := range pkg {
+		if vers.Dist != nil {
+			vers.Dist.URL = transformer.rewrite(input, vers.Dist.URL, Dist)
+		}
+
+		if vers.Source != nil {
+			vers.Source.URL = transformer.rewrite(input, vers.Source.URL, Source)
+		}
+	}
+
+	return nil
+}
+
+// rewrite applies the first rule matching the input, kind of URL and url.
+func (transformer *RewriteURLsTransformer) rewrite(input composer.Input, url string, kind string) string {
+	for _, rule := range transformer.Rules {
+		if rule.Inputs != nil && !rule.Inputs[input.GetID()] {
+			continue
+		}
+
+		if (kind == Dist && !rule.Dist) || (kind == Source && !rule.Source) {
+			continue
+		}
+
+		if rewritten, ok := rule.Rewrite(url); ok {
+			return rewritten
+		}
+	}
+
+	return url
+}
+
+// TransformRepository adds the mirrors to packages.json.
+func (transformer *RewriteURLsTransformer) TransformRepository(repo *composer.Repository) error {
+	repo.Mirrors = append(repo.Mirrors, transformer.Mirrors...)
+	return nil
+}
@@ -0,0 +1,120 @@
+package scope
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/zachomedia/composerrepo/pkg/composer"
+	"github.com/zachomedia/composerrepo/pkg/config/schema"
+	"github.com/zachomedia/composerrepo/pkg/semver"
+)
+
+// Keys are the config keys which scope any transformer to certain versions.
+var Keys = []string{"versions", "stability"}
+
+type Config struct {
+	Versions  string   `config:"versions"`
+	Stability []string `config:"stability"`
+}
+
+// ScopedTransformer applies a transformer only to the versions matching
+// a constraint and stability.
+type ScopedTransformer struct {
+	composer.Transformer
+
+	Constraint  semver.Constraint
+	Stabilities map[semver.Stability]bool
+}
+
+// New scopes transformer to the versions described by conf.
+func New(transformer composer.Transformer, conf map[string]interface{}) (*ScopedTransformer, error) {
+	config := Config{}
+	if err := schema.Decode(conf, &config); err != nil {
+		return nil, err
+	}
+
+	scoped := &ScopedTransformer{
+		Transformer: transformer,
+	}
+
+	if config.Versions != "" {
+		constraint, err := semver.ParseConstraint(config.Versions)
+		if err != nil {
+			return nil, &schema.FieldError{Path: "versions", Message: err.Error()}
+		}
+		scoped.Constraint = constraint
+	}
+
+	if len(config.Stability) > 0 {
+		scoped.Stabilities = make(map[semver.Stability]bool)
+		for indx, name := range config.Stability {
+			stability, err := semver.ParseStability(name)
+			if err != nil {
+				return nil, &schema.FieldError{Path: fmt.Sprintf("stability[%d]", indx), Message: err.Error()}
+			}
+			scoped.Stabilities[stability] = true
+		}
+	}
+
+	return scoped, nil
+}
+
+// Matches reports whether the transformer applies to version.
+func (scoped *ScopedTransformer) Matches(version string) bool {
+	v, err := semver.Parse(version)
+	if err != nil {
+		return false
+	}
+
+	if scoped.Stabilities != nil && !scoped.Stabilities[v.Stability] {
+		return false
+	}
+
+	return scoped.Constraint == nil || scoped.Constraint.Matches(v)
+}
+
+// Transform transforms the matching versions of pkg. Versions added or
+// removed by the transformer are added to or removed from pkg.
+func (scoped *ScopedTransformer) Transform(ctx context.Context, input composer.Input, name string, pkg composer.PackageVersions) error {
+	matching := make(composer.PackageVersions)
+	for version, vers := range pkg {
+		if scoped.Matches(version) {
+			matching[version] = vers
+		}
+	}
+
+	if len(matching) == 0 {
+		return nil
+	}
+
+	before := make(map[string]bool, len(matching))
+	for version := range matching {
+		before[version] = true
+	}
+
+	if err := scoped.Transformer.Transform(ctx, input, name, matching); err != nil {
+		return err
+	}
+
+	for version := range before {
+		if _, ok := matching[version]; !ok {
+			delete(pkg, version)
+		}
+	}
+
+	for version, vers := range matching {
+		pkg[version] = vers
+	}
+
+	return nil
+}
+
+// TransformRepository passes packages.json to the scoped transformer, which
+// isn't limited to versions.
+func (scoped *ScopedTransformer) TransformRepository(repo *composer.Repository) error {
+	if rt, ok := scoped.Transformer.(composer.RepositoryTransformer); ok {
+		return rt.TransformRepository(repo)
+	}
+
+	return nil
+}
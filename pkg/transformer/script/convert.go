@@ -0,0 +1,110 @@
+package script
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	"go.starlark.net/starlark"
+)
+
+// toStarlark converts a value decoded from JSON into a Starlark value.
+// Maps become dicts and lists become lists, so scripts can modify them.
+func toStarlark(v interface{}) (starlark.Value, error) {
+	switch v := v.(type) {
+	case nil:
+		return starlark.None, nil
+	case bool:
+		return starlark.Bool(v), nil
+	case string:
+		return starlark.String(v), nil
+	case float64:
+		if v == math.Trunc(v) && math.Abs(v) < 1<<53 {
+			return starlark.MakeInt64(int64(v)), nil
+		}
+		return starlark.Float(v), nil
+	case []interface{}:
+		elems := make([]starlark.Value, len(v))
+		for indx, val := range v {
+			elem, err := toStarlark(val)
+			if err != nil {
+				return nil, err
+			}
+			elems[indx] = elem
+		}
+		return starlark.NewList(elems), nil
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		dict := starlark.NewDict(len(v))
+		for _, k := range keys {
+			val, err := toStarlark(v[k])
+			if err != nil {
+				return nil, err
+			}
+			if err := dict.SetKey(starlark.String(k), val); err != nil {
+				return nil, err
+			}
+		}
+		return dict, nil
+	}
+
+	return nil, fmt.Errorf("unsupported value of type %T", v)
+}
+
+// fromStarlark converts a Starlark value into its JSON equivalent.
+func fromStarlark(v starlark.Value) (interface{}, error) {
+	switch v := v.(type) {
+	case starlark.NoneType:
+		return nil, nil
+	case starlark.Bool:
+		return bool(v), nil
+	case starlark.String:
+		return string(v), nil
+	case starlark.Int:
+		if i, ok := v.Int64(); ok {
+			return i, nil
+		}
+		return nil, fmt.Errorf("integer %s is too large", v)
+	case starlark.Float:
+		return float64(v), nil
+	case *starlark.List:
+		return fromIterable(v)
+	case starlark.Tuple:
+		return fromIterable(v)
+	case *starlark.Dict:
+		m := make(map[string]interface{}, v.Len())
+		for _, item := range v.Items() {
+			k, ok := item[0].(starlark.String)
+			if !ok {
+				return nil, fmt.Errorf("expected dict keys to be strings, got %s", item[0].Type())
+			}
+
+			val, err := fromStarlark(item[1])
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", k, err)
+			}
+			m[string(k)] = val
+		}
+		return m, nil
+	}
+
+	return nil, fmt.Errorf("unsupported value of type %s", v.Type())
+}
+
+func fromIterable(v starlark.Indexable) (interface{}, error) {
+	l := make([]interface{}, v.Len())
+	for indx := range l {
+		val, err := fromStarlark(v.Index(indx))
+		if err != nil {
+			return nil, fmt.Errorf("[%d]: %v", indx, err)
+		}
+		l[indx] = val
+	}
+
+	return l, nil
+}
@@ -0,0 +1,254 @@
+// Package script transforms packages with Starlark programs.
+//
+// The program defines a transform function, which is called with each
+// version of the matching packages:
+//
+//	def transform(package, ctx):
+//	    package.pop("require-dev", None)
+//
+// package is the composer.json of the version as a mutable dict, and ctx
+// is a read-only dict with the package "name" and "version", and the
+// "input_id" and "input_name" of its input. Returning False removes the
+// version.
+package script
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/zachomedia/composerrepo/pkg/composer"
+	"github.com/zachomedia/composerrepo/pkg/config/schema"
+	"github.com/zachomedia/composerrepo/pkg/logging"
+	"github.com/zachomedia/composerrepo/pkg/transformer/match"
+	"go.starlark.net/starlark"
+	"go.starlark.net/syntax"
+)
+
+// DefaultMaxSteps is the default number of steps a script may run for
+// each version. Steps are counted, rather than time, so a script is
+// stopped at the same point on every run.
+const DefaultMaxSteps = 1000000
+
+type TransformerConfig struct {
+	Packages   []string `config:"packages"`
+	Match      string   `config:"match"`
+	Script     string   `config:"script"`
+	ScriptFile string   `config:"scriptFile"`
+	MaxSteps   int      `config:"maxSteps"`
+}
+
+// ScriptTransformer runs a Starlark program against each version of the
+// matching packages.
+type ScriptTransformer struct {
+	ID       int
+	Packages *match.Matcher
+	Filename string
+	MaxSteps uint64
+
+	// transform is the program's transform function. The program's
+	// globals are frozen, so calls can't affect each other.
+	transform starlark.Callable
+
+	log *logging.Logger
+}
+
+// SetLogger sets the logger of the transformer.
+func (transformer *ScriptTransformer) SetLogger(log *logging.Logger) {
+	transformer.log = log
+}
+
+func (transformer *ScriptTransformer) Init(id int, conf map[string]interface{}) error {
+	config := TransformerConfig{
+		Packages: make([]string, 0),
+		Match:    match.Glob,
+		MaxSteps: DefaultMaxSteps,
+	}
+	if err := schema.Decode(conf, &config); err != nil {
+		return err
+	}
+
+	packages, err := match.New(config.Packages, config.Match)
+	if err != nil {
+		return err
+	}
+
+	if config.MaxSteps <= 0 {
+		return &schema.FieldError{Path: "maxSteps", Message: "must be greater than 0"}
+	}
+
+	transformer.ID = id
+	transformer.Packages = packages
+	transformer.MaxSteps = uint64(config.MaxSteps)
+
+	field := "script"
+	var src interface{}
+	switch {
+	case config.Script != "" && config.ScriptFile != "":
+		return &schema.FieldError{Path: "scriptFile", Message: "cannot be used with script"}
+	case config.ScriptFile != "":
+		field = "scriptFile"
+		transformer.Filename = config.ScriptFile
+
+		b, err := ioutil.ReadFile(config.ScriptFile)
+		if err != nil {
+			return &schema.FieldError{Path: field, Message: err.Error()}
+		}
+		src = b
+	case config.Script != "":
+		transformer.Filename = fmt.Sprintf("transformers[%d].script", id)
+		src = config.Script
+	default:
+		return &schema.FieldError{Path: "script", Message: "one of script or scriptFile is required"}
+	}
+
+	if err := transformer.load(src); err != nil {
+		return &schema.FieldError{Path: field, Message: err.Error()}
+	}
+
+	return nil
+}
+
+// load compiles and runs the program, and looks up its transform function.
+func (transformer *ScriptTransformer) load(src interface{}) error {
+	_, program, err := starlark.SourceProgram(transformer.Filename, src, starlark.StringDict{}.Has)
+	if err != nil {
+		return errorString(err)
+	}
+
+	globals, err := program.Init(transformer.newThread(transformer.log), nil)
+	if err != nil {
+		return errorString(err)
+	}
+	globals.Freeze()
+
+	transform, ok := globals["transform"].(starlark.Callable)
+	if !ok {
+		return fmt.Errorf("%s: expected the script to define a transform function", transformer.Filename)
+	}
+	transformer.transform = transform
+
+	return nil
+}
+
+// newThread returns a thread running the script, which prints with log.
+func (transformer *ScriptTransformer) newThread(log *logging.Logger) *starlark.Thread {
+	thread := &starlark.Thread{
+		Name: transformer.Filename,
+		Print: func(thread *starlark.Thread, msg string) {
+			log.Info(msg, "pos", thread.CallFrame(1).Pos)
+		},
+		Load: func(thread *starlark.Thread, module string) (starlark.StringDict, error) {
+			return nil, fmt.Errorf("load is not supported")
+		},
+	}
+	thread.SetMaxExecutionSteps(transformer.MaxSteps)
+
+	return thread
+}
+
+// errorString formats Starlark errors with the position in the script
+// they occurred at, skipping built-in functions such as fail.
+func errorString(err error) error {
+	switch err := err.(type) {
+	case *starlark.EvalError:
+		for indx := range err.CallStack {
+			frame := err.CallStack.At(indx)
+			if frame.Pos.IsValid() && frame.Pos.Filename() != "<builtin>" {
+				return fmt.Errorf("%s: in %s: %s", frame.Pos, frame.Name, err.Msg)
+			}
+		}
+	case syntax.Error:
+		return fmt.Errorf("%s: %s", err.Pos, err.Msg)
+	}
+
+	return err
+}
+
+func (transformer *ScriptTransformer) GetID() int {
+	return transformer.ID
+}
+
+func (transformer *ScriptTransformer) Transform(ctx context.Context, input composer.Input, name string, pkg composer.PackageVersions) error {
+	if !transformer.Packages.Matches(name) {
+		return nil
+	}
+
+	log := transformer.log.WithContext(ctx)
+	log.Debug("Running script", "script", transformer.Filename, "package", name)
+
+	for version, vers := range pkg {
+		keep, err := transformer.transformVersion(log, input, name, version, vers)
+		if err != nil {
+			return fmt.Errorf("Unable to transform %s@%s: %v", name, version, err)
+		}
+
+		if !keep {
+			delete(pkg, version)
+		}
+	}
+
+	return nil
+}
+
+// transformVersion calls the transform function with the composer.json
+// representation of vers, and reports whether the version should be kept.
+func (transformer *ScriptTransformer) transformVersion(log *logging.Logger, input composer.Input, name string, version string, vers *composer.Package) (bool, error) {
+	b, err := json.Marshal(vers)
+	if err != nil {
+		return false, err
+	}
+
+	doc := make(map[string]interface{})
+	if err := json.Unmarshal(b, &doc); err != nil {
+		return false, err
+	}
+
+	pkgValue, err := toStarlark(doc)
+	if err != nil {
+		return false, err
+	}
+
+	ctx, err := toStarlark(map[string]interface{}{
+		"name":       name,
+		"version":    version,
+		"input_id":   input.GetID(),
+		"input_name": input.GetName(),
+	})
+	if err != nil {
+		return false, err
+	}
+	ctx.Freeze()
+
+	result, err := starlark.Call(transformer.newThread(log), transformer.transform, starlark.Tuple{pkgValue, ctx}, nil)
+	if err != nil {
+		return false, errorString(err)
+	}
+
+	switch result {
+	case starlark.None, starlark.True:
+	case starlark.False:
+		return false, nil
+	default:
+		return false, fmt.Errorf("expected transform to return None or a bool, got %s", result.Type())
+	}
+
+	transformed, err := fromStarlark(pkgValue)
+	if err != nil {
+		return false, fmt.Errorf("invalid package: %v", err)
+	}
+
+	b, err = json.Marshal(transformed)
+	if err != nil {
+		return false, err
+	}
+
+	var pkg composer.Package
+	if err := json.Unmarshal(b, &pkg); err != nil {
+		return false, fmt.Errorf("invalid package: %v", err)
+	}
+
+	*vers = pkg
+	return true, nil
+}
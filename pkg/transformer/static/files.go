@@ -0,0 +1,401 @@
+package static
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/zachomedia/composerrepo/pkg/composer"
+	"github.com/zachomedia/composerrepo/pkg/config/schema"
+	yaml "gopkg.in/yaml.v3"
+)
+
+// FileError is a problem with a package definition file.
+type FileError struct {
+	File    string
+	Line    int
+	Message string
+}
+
+func (e *FileError) Error() string {
+	if e.Line == 0 {
+		return fmt.Sprintf("%s: %s", e.File, e.Message)
+	}
+
+	return fmt.Sprintf("%s:%d: %s", e.File, e.Line, e.Message)
+}
+
+// fileState identifies a version of a file, so changes can be detected.
+type fileState struct {
+	Size    int64
+	ModTime time.Time
+}
+
+// isPackageFile reports whether name is a package definition file.
+func isPackageFile(name string) bool {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".json", ".yml", ".yaml":
+		return true
+	}
+
+	return false
+}
+
+// scanDir returns the state of each package definition file in dir.
+func scanDir(dir string) (map[string]fileState, error) {
+	files := make(map[string]fileState)
+
+	err := filepath.Walk(dir, func(fPath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if info.IsDir() || !isPackageFile(info.Name()) {
+			return nil
+		}
+
+		files[fPath] = fileState{Size: info.Size(), ModTime: info.ModTime()}
+		return nil
+	})
+
+	return files, err
+}
+
+// loadFiles reads the packages defined in files. Problems in every file are
+// returned together.
+func loadFiles(files map[string]fileState) (composer.Packages, error) {
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	packages := make(composer.Packages)
+	errs := schema.Errors{}
+
+	for _, name := range names {
+		pkgs, err := loadFile(name)
+		if err != nil {
+			errs.Add("", err)
+			continue
+		}
+
+		for pkgName, versions := range pkgs {
+			if packages[pkgName] == nil {
+				packages[pkgName] = make(composer.PackageVersions)
+			}
+
+			for version, pkg := range versions {
+				if _, ok := packages[pkgName][version]; ok {
+					errs.Add("", &FileError{File: name, Message: fmt.Sprintf("version %q of %q is defined more than once", version, pkgName)})
+					continue
+				}
+
+				packages[pkgName][version] = pkg
+			}
+		}
+	}
+
+	if err := errs.Err(); err != nil {
+		return nil, err
+	}
+
+	return packages, nil
+}
+
+// syntaxErrorPattern extracts the line from the syntax errors of the YAML parser.
+var syntaxErrorPattern = regexp.MustCompile(`^yaml: line (\d+): (.*)$`)
+
+// leadingTabs matches the tabs indenting a line.
+var leadingTabs = regexp.MustCompile(`(?m)^\t+`)
+
+// loadFile reads a composer.json file, which defines a single version, or a
+// packages.json file, which lists the versions of each package under
+// "packages". Either can be written in JSON or YAML.
+func loadFile(filename string) (composer.Packages, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	// JSON is parsed as YAML to know the line of each value, but YAML
+	// doesn't allow tabs for indentation
+	if strings.ToLower(filepath.Ext(filename)) == ".json" {
+		data = leadingTabs.ReplaceAllFunc(data, func(tabs []byte) []byte {
+			return bytes.Repeat([]byte(" "), len(tabs))
+		})
+	}
+
+	doc := &yaml.Node{}
+	if err := yaml.Unmarshal(data, doc); err != nil {
+		if match := syntaxErrorPattern.FindStringSubmatch(err.Error()); match != nil {
+			line, _ := strconv.Atoi(match[1])
+			return nil, &FileError{File: filename, Line: line, Message: match[2]}
+		}
+
+		return nil, &FileError{File: filename, Message: strings.TrimPrefix(err.Error(), "yaml: ")}
+	}
+
+	if len(doc.Content) == 0 {
+		return nil, &FileError{File: filename, Message: "is empty"}
+	}
+
+	root := resolve(doc.Content[0])
+	if root.Kind != yaml.MappingNode {
+		return nil, &FileError{File: filename, Line: root.Line, Message: "expected a map, got " + describeNode(root)}
+	}
+
+	d := &decoder{filename: filename}
+
+	packages := make(composer.Packages)
+	if pkgsNode := mappingValue(root, "packages"); pkgsNode != nil {
+		d.decodePackages(pkgsNode, packages)
+	} else if pkg := d.decodePackage(root, "", "", ""); pkg != nil {
+		packages[pkg.Name] = composer.PackageVersions{pkg.Version: pkg}
+	}
+
+	if err := d.errs.Err(); err != nil {
+		return nil, err
+	}
+
+	return packages, nil
+}
+
+// decoder decodes the packages of a file, collecting problems.
+type decoder struct {
+	filename string
+	errs     schema.Errors
+}
+
+func (d *decoder) fail(node *yaml.Node, path string, message string) {
+	if path != "" {
+		message = path + ": " + message
+	}
+
+	d.errs.Add("", &FileError{File: d.filename, Line: node.Line, Message: message})
+}
+
+// decodePackages decodes the packages of a packages.json file. The versions
+// of each package are a map of version to package, or a list of packages.
+func (d *decoder) decodePackages(node *yaml.Node, packages composer.Packages) {
+	node = resolve(node)
+	if node.Kind != yaml.MappingNode {
+		d.fail(node, "packages", "expected a map, got "+describeNode(node))
+		return
+	}
+
+	for indx := 0; indx+1 < len(node.Content); indx += 2 {
+		name := node.Content[indx].Value
+		versionsNode := resolve(node.Content[indx+1])
+		path := schema.JoinPath("packages", name)
+
+		versions := make(composer.PackageVersions)
+
+		switch versionsNode.Kind {
+		case yaml.MappingNode:
+			for vIndx := 0; vIndx+1 < len(versionsNode.Content); vIndx += 2 {
+				version := versionsNode.Content[vIndx].Value
+				if pkg := d.decodePackage(versionsNode.Content[vIndx+1], schema.JoinPath(path, version), name, version); pkg != nil {
+					versions[version] = pkg
+				}
+			}
+		case yaml.SequenceNode:
+			for vIndx, versionNode := range versionsNode.Content {
+				if pkg := d.decodePackage(versionNode, fmt.Sprintf("%s[%d]", path, vIndx), name, ""); pkg != nil {
+					versions[pkg.Version] = pkg
+				}
+			}
+		default:
+			d.fail(versionsNode, path, "expected a map or a list, got "+describeNode(versionsNode))
+			continue
+		}
+
+		packages[name] = versions
+	}
+}
+
+var packageType = reflect.TypeOf(composer.Package{})
+
+// decodePackage decodes a version of a package. The name and version
+// default to the keys it is listed under.
+func (d *decoder) decodePackage(node *yaml.Node, path string, name string, version string) *composer.Package {
+	errCount := len(d.errs)
+	d.check(node, packageType, path)
+	if len(d.errs) > errCount {
+		return nil
+	}
+
+	var raw interface{}
+	if err := node.Decode(&raw); err != nil {
+		d.fail(node, path, err.Error())
+		return nil
+	}
+
+	b, err := json.Marshal(raw)
+	if err != nil {
+		d.fail(node, path, err.Error())
+		return nil
+	}
+
+	pkg := &composer.Package{}
+	if err := json.Unmarshal(b, pkg); err != nil {
+		d.fail(node, path, err.Error())
+		return nil
+	}
+
+	if name != "" {
+		pkg.Name = name
+	}
+	if version != "" {
+		pkg.Version = version
+	}
+
+	if pkg.Name == "" {
+		d.fail(node, schema.JoinPath(path, "name"), "is required")
+		return nil
+	}
+	if pkg.Version == "" {
+		d.fail(node, schema.JoinPath(path, "version"), "is required")
+		return nil
+	}
+
+	return pkg
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// check reports values of node which can't be decoded into t, with their line.
+// Keys which aren't fields of a struct are ignored, as composer.json files
+// often contain fields the repository doesn't use.
+func (d *decoder) check(node *yaml.Node, t reflect.Type, path string) {
+	node = resolve(node)
+	if node.Kind == yaml.ScalarNode && node.Tag == "!!null" {
+		return
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		d.check(node, t.Elem(), path)
+
+	case reflect.Interface:
+		return
+
+	case reflect.Struct:
+		if t == timeType {
+			if node.Kind != yaml.ScalarNode || node.Tag != "!!str" {
+				d.fail(node, path, "expected a time, got "+describeNode(node))
+			} else if _, err := time.Parse(time.RFC3339, node.Value); err != nil {
+				d.fail(node, path, fmt.Sprintf("expected a time such as %q, got %q", time.RFC3339, node.Value))
+			}
+			return
+		}
+
+		if node.Kind != yaml.MappingNode {
+			d.fail(node, path, "expected a map, got "+describeNode(node))
+			return
+		}
+
+		for indx := 0; indx+1 < len(node.Content); indx += 2 {
+			key := node.Content[indx].Value
+			if field, ok := jsonField(t, key); ok {
+				d.check(node.Content[indx+1], field.Type, schema.JoinPath(path, key))
+			}
+		}
+
+	case reflect.Map:
+		if node.Kind != yaml.MappingNode {
+			d.fail(node, path, "expected a map, got "+describeNode(node))
+			return
+		}
+
+		for indx := 0; indx+1 < len(node.Content); indx += 2 {
+			d.check(node.Content[indx+1], t.Elem(), schema.JoinPath(path, node.Content[indx].Value))
+		}
+
+	case reflect.Slice:
+		if node.Kind != yaml.SequenceNode {
+			d.fail(node, path, "expected a list, got "+describeNode(node))
+			return
+		}
+
+		for indx, item := range node.Content {
+			d.check(item, t.Elem(), fmt.Sprintf("%s[%d]", path, indx))
+		}
+
+	case reflect.String:
+		if node.Kind != yaml.ScalarNode || node.Tag != "!!str" {
+			d.fail(node, path, "expected a string, got "+describeNode(node))
+		}
+
+	case reflect.Bool:
+		if node.Kind != yaml.ScalarNode || node.Tag != "!!bool" {
+			d.fail(node, path, "expected a boolean, got "+describeNode(node))
+		}
+	}
+}
+
+// jsonField returns the field of t with the JSON name key.
+func jsonField(t reflect.Type, key string) (reflect.StructField, bool) {
+	for indx := 0; indx < t.NumField(); indx++ {
+		field := t.Field(indx)
+		if strings.Split(field.Tag.Get("json"), ",")[0] == key {
+			return field, true
+		}
+	}
+
+	return reflect.StructField{}, false
+}
+
+// mappingValue returns the value of key in a mapping node, or nil.
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	for indx := 0; indx+1 < len(node.Content); indx += 2 {
+		if node.Content[indx].Value == key {
+			return node.Content[indx+1]
+		}
+	}
+
+	return nil
+}
+
+// resolve follows aliases to the node they refer to.
+func resolve(node *yaml.Node) *yaml.Node {
+	for node.Kind == yaml.AliasNode && node.Alias != nil {
+		node = node.Alias
+	}
+
+	return node
+}
+
+// describeNode returns a user friendly name for the type of node.
+func describeNode(node *yaml.Node) string {
+	switch node.Kind {
+	case yaml.MappingNode:
+		return "a map"
+	case yaml.SequenceNode:
+		return "a list"
+	}
+
+	switch node.Tag {
+	case "!!null":
+		return "nothing"
+	case "!!str":
+		return "a string"
+	case "!!bool":
+		return "a boolean"
+	case "!!int":
+		return "an integer"
+	case "!!float":
+		return "a number"
+	}
+
+	return node.Tag
+}
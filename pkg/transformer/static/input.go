@@ -1,31 +1,79 @@
 package static
 
 import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+
 	"github.com/vmihailenco/msgpack"
 	"github.com/zachomedia/composerrepo/pkg/composer"
+	"github.com/zachomedia/composerrepo/pkg/config/schema"
+	"github.com/zachomedia/composerrepo/pkg/logging"
 )
 
+type InputConfig struct {
+	Packages map[string]map[string]interface{} `config:"packages"`
+	Dir      string                            `config:"dir"`
+}
+
+// StaticInput provides packages defined in the config, and in the
+// composer.json and packages.json files of Dir.
 type StaticInput struct {
 	ID       string
 	Packages composer.Packages `msgpack:"packages"`
+
+	// Dir contains package definition files, which are reloaded when they change.
+	Dir string
+
+	mux          sync.Mutex
+	files        map[string]fileState
+	filePackages composer.Packages
+
+	// watched are the packages, and the files they were read from, as of
+	// the last call to Changed.
+	watchMux     sync.Mutex
+	watchedFiles map[string]fileState
+	watched      composer.Packages
+
+	log *logging.Logger
+}
+
+// SetLogger sets the logger of the input.
+func (input *StaticInput) SetLogger(log *logging.Logger) {
+	input.log = log
 }
 
 func (input *StaticInput) Init(id string, conf map[string]interface{}) error {
+	config := InputConfig{}
+	if err := schema.Decode(conf, &config); err != nil {
+		return err
+	}
+
 	input.ID = id
+	input.Dir = config.Dir
 	input.Packages = make(composer.Packages)
 
-	for rawPackageName, rawPackageVersions := range conf["packages"].(map[interface{}]interface{}) {
-		packageName := rawPackageName.(string)
-		packageVersions := rawPackageVersions.(map[interface{}]interface{})
+	errs := schema.Errors{}
 
+	for packageName, packageVersions := range config.Packages {
 		input.Packages[packageName] = make(composer.PackageVersions)
 
-		for rawVersion, rawPackage := range packageVersions {
-			version := rawVersion.(string)
+		for version, rawPackage := range packageVersions {
+			path := schema.JoinPath(schema.JoinPath("packages", packageName), version)
+
+			b, err := msgpack.Marshal(rawPackage)
+			if err != nil {
+				errs.Add(path, err)
+				continue
+			}
 
-			b, _ := msgpack.Marshal(rawPackage)
 			var pkg composer.Package
-			msgpack.Unmarshal(b, &pkg)
+			if err := msgpack.Unmarshal(b, &pkg); err != nil {
+				errs.Add(path, err)
+				continue
+			}
 
 			pkg.Name = packageName
 			pkg.Version = version
@@ -33,7 +81,60 @@ func (input *StaticInput) Init(id string, conf map[string]interface{}) error {
 		}
 	}
 
-	return nil
+	if err := errs.Err(); err != nil {
+		return err
+	}
+
+	if input.Dir != "" {
+		if _, err := input.loadDir(input.log); err != nil {
+			errs.Add("dir", err)
+		}
+	}
+
+	return errs.Err()
+}
+
+// loadDir returns the packages defined in Dir, reading the files again if
+// any were added, changed or removed since they were last read.
+func (input *StaticInput) loadDir(log *logging.Logger) (composer.Packages, error) {
+	input.mux.Lock()
+	defer input.mux.Unlock()
+
+	files, err := scanDir(input.Dir)
+	if err != nil {
+		return nil, err
+	}
+
+	if input.filePackages == nil || !reflect.DeepEqual(files, input.files) {
+		if input.filePackages != nil {
+			log.Info("Reloading the package files")
+		}
+
+		packages, err := loadFiles(files)
+		if err != nil {
+			return nil, err
+		}
+
+		input.files = files
+		input.filePackages = packages
+	}
+
+	// Transformers change packages in place, so they get a copy
+	return copyPackages(input.filePackages)
+}
+
+func copyPackages(packages composer.Packages) (composer.Packages, error) {
+	b, err := msgpack.Marshal(packages)
+	if err != nil {
+		return nil, err
+	}
+
+	var result composer.Packages
+	if err := msgpack.Unmarshal(b, &result); err != nil {
+		return nil, err
+	}
+
+	return result, nil
 }
 
 func (input *StaticInput) GetID() string {
@@ -44,11 +145,97 @@ func (input *StaticInput) GetName() string {
 	return input.ID
 }
 
-func (input *StaticInput) GetPackages() (composer.Packages, error) {
+func (input *StaticInput) GetPackages(ctx context.Context) (composer.Packages, error) {
+	if input.Dir == "" {
+		return input.Packages, nil
+	}
+
+	filePackages, err := input.loadDir(input.log.WithContext(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("Unable to load the package files of input %q:\n%v", input.ID, err)
+	}
+
+	packages := make(composer.Packages, len(input.Packages)+len(filePackages))
+	for name, versions := range filePackages {
+		packages[name] = versions
+	}
 
-	return input.Packages, nil
+	// Versions in the config take precedence over the files
+	for name, versions := range input.Packages {
+		if packages[name] == nil {
+			packages[name] = versions
+			continue
+		}
+
+		merged := make(composer.PackageVersions, len(packages[name])+len(versions))
+		for version, pkg := range packages[name] {
+			merged[version] = pkg
+		}
+		for version, pkg := range versions {
+			merged[version] = pkg
+		}
+		packages[name] = merged
+	}
+
+	return packages, nil
+}
+
+func (input *StaticInput) GetPackage(ctx context.Context, packageName string) (composer.PackageVersions, error) {
+	packages, err := input.GetPackages(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return packages[packageName], nil
 }
 
-func (input *StaticInput) GetPackage(packageName string) (composer.PackageVersions, error) {
-	return input.Packages[packageName], nil
+// Changed returns the packages whose definitions were added, changed or
+// removed since it was last called, if the files of Dir changed.
+func (input *StaticInput) Changed() ([]string, []string, error) {
+	if input.Dir == "" {
+		return nil, nil, nil
+	}
+
+	input.watchMux.Lock()
+	defer input.watchMux.Unlock()
+
+	files, err := scanDir(input.Dir)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if input.watched != nil && reflect.DeepEqual(files, input.watchedFiles) {
+		return nil, nil, nil
+	}
+
+	packages, err := input.GetPackages(context.Background())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	previous := input.watched
+	input.watchedFiles = files
+	input.watched = packages
+
+	if previous == nil {
+		return nil, nil, nil
+	}
+
+	changed := make([]string, 0)
+	for name, versions := range packages {
+		if !reflect.DeepEqual(previous[name], versions) {
+			changed = append(changed, name)
+		}
+	}
+
+	removed := make([]string, 0)
+	for name := range previous {
+		if _, ok := packages[name]; !ok {
+			removed = append(removed, name)
+		}
+	}
+
+	sort.Strings(changed)
+	sort.Strings(removed)
+	return changed, removed, nil
 }
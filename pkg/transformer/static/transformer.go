@@ -1,39 +1,80 @@
 package static
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
-	"log"
 	"sort"
+	"strings"
 
 	"github.com/zachomedia/composerrepo/pkg/composer"
+	"github.com/zachomedia/composerrepo/pkg/config/schema"
+	"github.com/zachomedia/composerrepo/pkg/logging"
+	"github.com/zachomedia/composerrepo/pkg/transformer/match"
 )
 
+type TransformerConfig struct {
+	Packages []string               `config:"packages"`
+	Match    string                 `config:"match"`
+	Values   map[string]interface{} `config:"values"`
+	Set      map[string]interface{} `config:"set"`
+	Merge    map[string]interface{} `config:"merge"`
+	Delete   []string               `config:"delete"`
+}
+
+// StaticTransformer sets, merges and deletes fields of matching packages.
+//
+// Fields are named as in composer.json, with nested fields separated by
+// dots, such as "extra.drupal.version", or as JSON Pointers for keys
+// containing dots, such as "/extra/branch-alias/1.x-dev".
 type StaticTransformer struct {
 	ID       int
-	Packages []string
-	Values   map[string]interface{}
+	Packages *match.Matcher
+	Set      map[string]interface{}
+	Merge    map[string]interface{}
+	Delete   []string
+
+	log *logging.Logger
+}
+
+// SetLogger sets the logger of the transformer.
+func (transformer *StaticTransformer) SetLogger(log *logging.Logger) {
+	transformer.log = log
 }
 
 func (transformer *StaticTransformer) Init(id int, conf map[string]interface{}) error {
+	config := TransformerConfig{
+		Packages: make([]string, 0),
+		Match:    match.Glob,
+	}
+	if err := schema.Decode(conf, &config); err != nil {
+		return err
+	}
+
+	packages, err := match.New(config.Packages, config.Match)
+	if err != nil {
+		return err
+	}
+
 	transformer.ID = id
-	transformer.Packages = make([]string, 0)
-	transformer.Values = make(map[string]interface{})
+	transformer.Packages = packages
 
-	confPackages, ok := conf["packages"]
-	if ok {
-		for _, rPkgName := range confPackages.([]interface{}) {
-			transformer.Packages = append(transformer.Packages, rPkgName.(string))
-		}
+	// values is the original name for set
+	transformer.Set = make(map[string]interface{})
+	for k, v := range config.Values {
+		transformer.Set[k] = schema.Normalize(v)
+	}
+	for k, v := range config.Set {
+		transformer.Set[k] = schema.Normalize(v)
 	}
-	sort.Strings(transformer.Packages)
 
-	values, ok := conf["values"]
-	if ok {
-		for k, v := range values.(map[interface{}]interface{}) {
-			transformer.Values[k.(string)] = v
-		}
+	transformer.Merge = make(map[string]interface{})
+	for k, v := range config.Merge {
+		transformer.Merge[k] = schema.Normalize(v)
 	}
 
+	transformer.Delete = config.Delete
+
 	return nil
 }
 
@@ -41,25 +82,171 @@ func (transformer *StaticTransformer) GetID() int {
 	return transformer.ID
 }
 
-func (transformer *StaticTransformer) Transform(input composer.Input, name string, pkg composer.PackageVersions) error {
-	indx := sort.SearchStrings(transformer.Packages, name)
-	if len(transformer.Packages) > 0 && (indx == len(transformer.Packages) || transformer.Packages[indx] != name) {
+func (transformer *StaticTransformer) Transform(ctx context.Context, input composer.Input, name string, pkg composer.PackageVersions) error {
+	if !transformer.Packages.Matches(name) {
 		return nil
 	}
 
-	log.Printf("Transforming %q", name)
+	transformer.log.WithContext(ctx).Debug("Transforming package", "package", name)
+
+	for version, vers := range pkg {
+		err := transformer.transformVersion(vers)
+		if err != nil {
+			return fmt.Errorf("Unable to transform %s@%s: %v", name, version, err)
+		}
+	}
+
+	return nil
+}
+
+// transformVersion applies the changes to the composer.json representation of vers.
+func (transformer *StaticTransformer) transformVersion(vers *composer.Package) error {
+	b, err := json.Marshal(vers)
+	if err != nil {
+		return err
+	}
+
+	doc := make(map[string]interface{})
+	if err := json.Unmarshal(b, &doc); err != nil {
+		return err
+	}
+
+	// Parent fields are applied before their children
+	for _, k := range sortedFields(transformer.Set) {
+		setField(doc, fieldKeys(k), transformer.Set[k])
+	}
+
+	for _, k := range sortedFields(transformer.Merge) {
+		keys := fieldKeys(k)
+		setField(doc, keys, merge(getField(doc, keys), transformer.Merge[k]))
+	}
+
+	for _, k := range transformer.Delete {
+		deleteField(doc, fieldKeys(k))
+	}
+
+	b, err = json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+
+	var transformed composer.Package
+	if err := json.Unmarshal(b, &transformed); err != nil {
+		return err
+	}
+
+	*vers = transformed
+	return nil
+}
 
-	for k, v := range transformer.Values {
-		switch k {
-		case "type":
-			for _, vers := range pkg {
-				vers.Type = v.(string)
+// fieldKeys returns the keys of the nested fields in a field name, which is
+// either separated by dots or an RFC 6901 JSON Pointer.
+func fieldKeys(field string) []string {
+	if !strings.HasPrefix(field, "/") {
+		return strings.Split(field, ".")
+	}
+
+	keys := strings.Split(field[1:], "/")
+	for indx, key := range keys {
+		keys[indx] = strings.Replace(strings.Replace(key, "~1", "/", -1), "~0", "~", -1)
+	}
+
+	return keys
+}
+
+// sortedFields returns the field names of m with parents before their
+// children, however they're written.
+func sortedFields(m map[string]interface{}) []string {
+	fields := make([]string, 0, len(m))
+	for k := range m {
+		fields = append(fields, k)
+	}
+
+	sort.Slice(fields, func(i, j int) bool {
+		a, b := fieldKeys(fields[i]), fieldKeys(fields[j])
+		for indx := 0; indx < len(a) && indx < len(b); indx++ {
+			if a[indx] != b[indx] {
+				return a[indx] < b[indx]
 			}
+		}
+		if len(a) != len(b) {
+			return len(a) < len(b)
+		}
+		return fields[i] < fields[j]
+	})
+
+	return fields
+}
 
-		default:
-			return fmt.Errorf("Unsupported property %q", k)
+// getField returns the value at keys, or nil if it doesn't exist.
+func getField(doc map[string]interface{}, keys []string) interface{} {
+	var current interface{} = doc
+
+	for _, key := range keys {
+		m, ok := current.(map[string]interface{})
+		if !ok {
+			return nil
 		}
+		current = m[key]
 	}
 
-	return nil
+	return current
+}
+
+// setField sets the value at keys, creating intermediate maps as needed.
+func setField(doc map[string]interface{}, keys []string, v interface{}) {
+	current := doc
+
+	for _, key := range keys[:len(keys)-1] {
+		next, ok := current[key].(map[string]interface{})
+		if !ok {
+			next = make(map[string]interface{})
+			current[key] = next
+		}
+		current = next
+	}
+
+	current[keys[len(keys)-1]] = schema.Normalize(v)
+}
+
+// deleteField removes the value at keys.
+func deleteField(doc map[string]interface{}, keys []string) {
+	current := doc
+
+	for _, key := range keys[:len(keys)-1] {
+		next, ok := current[key].(map[string]interface{})
+		if !ok {
+			return
+		}
+		current = next
+	}
+
+	delete(current, keys[len(keys)-1])
+}
+
+// merge merges src into dst. Maps are merged recursively, lists are
+// appended and other values are replaced.
+func merge(dst interface{}, src interface{}) interface{} {
+	switch src := src.(type) {
+	case map[string]interface{}:
+		dstMap, ok := dst.(map[string]interface{})
+		if !ok {
+			return schema.Normalize(src)
+		}
+
+		for k, v := range src {
+			dstMap[k] = merge(dstMap[k], v)
+		}
+		return dstMap
+
+	case []interface{}:
+		dstList, ok := dst.([]interface{})
+		if !ok {
+			return schema.Normalize(src)
+		}
+
+		return append(dstList, schema.Normalize(src).([]interface{})...)
+	}
+
+	return src
 }
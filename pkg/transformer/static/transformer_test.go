@@ -0,0 +1,72 @@
+package static
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/zachomedia/composerrepo/pkg/composer"
+)
+
+func TestTransformVersion(t *testing.T) {
+	tests := []struct {
+		name string
+		conf map[string]interface{}
+		pkg  composer.Package
+		want composer.Package
+	}{
+		{
+			"set dotted field",
+			map[string]interface{}{"set": map[string]interface{}{"type": "drupal-module", "extra.drupal.version": "8.x"}},
+			composer.Package{Name: "a/b"},
+			composer.Package{Name: "a/b", Type: "drupal-module", Extra: map[string]interface{}{"drupal": map[string]interface{}{"version": "8.x"}}},
+		},
+		{
+			"set pointer with dots",
+			map[string]interface{}{"set": map[string]interface{}{"/extra/branch-alias/1.x-dev": "1.2.x-dev"}},
+			composer.Package{Name: "a/b", Extra: map[string]interface{}{"branch-alias": map[string]interface{}{"dev-main": "2.x-dev"}}},
+			composer.Package{Name: "a/b", Extra: map[string]interface{}{"branch-alias": map[string]interface{}{"dev-main": "2.x-dev", "1.x-dev": "1.2.x-dev"}}},
+		},
+		{
+			"set pointer with escapes",
+			map[string]interface{}{"set": map[string]interface{}{"/extra/a~1b~0c": true}},
+			composer.Package{Name: "a/b"},
+			composer.Package{Name: "a/b", Extra: map[string]interface{}{"a/b~c": true}},
+		},
+		{
+			"parent before child",
+			map[string]interface{}{"set": map[string]interface{}{"/extra/x.y": 1.0, "extra": map[string]interface{}{"z": 2.0}}},
+			composer.Package{Name: "a/b"},
+			composer.Package{Name: "a/b", Extra: map[string]interface{}{"x.y": 1.0, "z": 2.0}},
+		},
+		{
+			"merge",
+			map[string]interface{}{"merge": map[string]interface{}{"require": map[string]interface{}{"drupal/core": "^8"}}},
+			composer.Package{Name: "a/b", Require: composer.PackageLink{"php": ">=7"}},
+			composer.Package{Name: "a/b", Require: composer.PackageLink{"php": ">=7", "drupal/core": "^8"}},
+		},
+		{
+			"delete",
+			map[string]interface{}{"delete": []interface{}{"require-dev", "/extra/branch-alias/1.x-dev"}},
+			composer.Package{Name: "a/b", RequireDev: composer.PackageLink{"x/y": "*"}, Extra: map[string]interface{}{"branch-alias": map[string]interface{}{"1.x-dev": "1.0.x-dev"}}},
+			composer.Package{Name: "a/b", Extra: map[string]interface{}{"branch-alias": map[string]interface{}{}}},
+		},
+	}
+
+	for _, test := range tests {
+		transformer := &StaticTransformer{}
+		if err := transformer.Init(0, test.conf); err != nil {
+			t.Errorf("%s: Init returned error: %v", test.name, err)
+			continue
+		}
+
+		pkg := test.pkg
+		if err := transformer.transformVersion(&pkg); err != nil {
+			t.Errorf("%s: transformVersion returned error: %v", test.name, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(pkg, test.want) {
+			t.Errorf("%s: transformVersion = %+v, want %+v", test.name, pkg, test.want)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package versionmap
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/zachomedia/composerrepo/pkg/config/schema"
+)
+
+// Mapper converts versions named after tags and branches into versions
+// Composer understands.
+type Mapper interface {
+	Init(conf map[string]interface{}) error
+
+	// Map returns the new version, and whether version was converted.
+	Map(version string) (string, bool)
+}
+
+// Mappers are the available mappers, by the name used in the config.
+var Mappers = map[string]Mapper{
+	"drupal": &DrupalMapper{},
+	"regex":  &RegexMapper{},
+}
+
+var (
+	// drupalTagPattern matches Drupal contrib releases, such as 7.x-1.2 or 8.x-2.0-beta1.
+	drupalTagPattern = regexp.MustCompile(`^v?\d+\.x-(\d+\.\d+(-.*)?)$`)
+
+	// drupalBranchPattern matches Drupal contrib branches, such as 8.x-1.x.
+	drupalBranchPattern = regexp.MustCompile(`^dev-\d+\.x-(\d+)\.x$`)
+)
+
+// DrupalMapper converts the Drupal 7 and 8 contrib conventions, so tag
+// 7.x-1.2 becomes 1.2 and branch 8.x-1.x becomes 1.x-dev.
+type DrupalMapper struct{}
+
+func (mapper *DrupalMapper) Init(conf map[string]interface{}) error {
+	return schema.Decode(conf, &struct{}{})
+}
+
+func (mapper *DrupalMapper) Map(version string) (string, bool) {
+	if m := drupalTagPattern.FindStringSubmatch(version); m != nil {
+		return m[1], true
+	}
+
+	if m := drupalBranchPattern.FindStringSubmatch(version); m != nil {
+		return fmt.Sprintf("%s.x-dev", m[1]), true
+	}
+
+	return version, false
+}
+
+type RegexMapperConfig struct {
+	From string `config:"from,required"`
+	To   string `config:"to,required"`
+}
+
+// RegexMapper replaces versions matching a regular expression, with $1
+// style references to its groups.
+type RegexMapper struct {
+	From *regexp.Regexp
+	To   string
+}
+
+func (mapper *RegexMapper) Init(conf map[string]interface{}) error {
+	config := RegexMapperConfig{}
+	if err := schema.Decode(conf, &config); err != nil {
+		return err
+	}
+
+	from, err := regexp.Compile(config.From)
+	if err != nil {
+		return &schema.FieldError{Path: "from", Message: fmt.Sprintf("invalid regular expression: %v", err)}
+	}
+
+	mapper.From = from
+	mapper.To = config.To
+
+	return nil
+}
+
+func (mapper *RegexMapper) Map(version string) (string, bool) {
+	if !mapper.From.MatchString(version) {
+		return version, false
+	}
+
+	return mapper.From.ReplaceAllString(version, mapper.To), true
+}
@@ -0,0 +1,145 @@
+// Package versionmap converts versions named after tags and branches, such
+// as Drupal's 7.x-1.2, into versions Composer understands.
+package versionmap
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+
+	"github.com/zachomedia/composerrepo/pkg/composer"
+	"github.com/zachomedia/composerrepo/pkg/config/schema"
+	"github.com/zachomedia/composerrepo/pkg/logging"
+	"github.com/zachomedia/composerrepo/pkg/transformer/match"
+)
+
+type TransformerConfig struct {
+	Packages []string                 `config:"packages"`
+	Match    string                   `config:"match"`
+	Mappers  []map[string]interface{} `config:"mappers,required"`
+}
+
+// VersionMapTransformer converts the versions of matching packages with
+// the first mapper that matches each version.
+type VersionMapTransformer struct {
+	ID       int
+	Packages *match.Matcher
+	Mappers  []Mapper
+
+	log *logging.Logger
+}
+
+// SetLogger sets the logger of the transformer.
+func (transformer *VersionMapTransformer) SetLogger(log *logging.Logger) {
+	transformer.log = log
+}
+
+func (transformer *VersionMapTransformer) Init(id int, conf map[string]interface{}) error {
+	config := TransformerConfig{
+		Packages: make([]string, 0),
+		Match:    match.Glob,
+	}
+	if err := schema.Decode(conf, &config); err != nil {
+		return err
+	}
+
+	packages, err := match.New(config.Packages, config.Match)
+	if err != nil {
+		return err
+	}
+
+	transformer.ID = id
+	transformer.Packages = packages
+
+	errs := schema.Errors{}
+
+	transformer.Mappers = make([]Mapper, 0, len(config.Mappers))
+	for indx, mapperConf := range config.Mappers {
+		path := fmt.Sprintf("mappers[%d]", indx)
+
+		typ, ok := mapperConf["type"].(string)
+		if !ok {
+			errs.Add(path+".type", fmt.Errorf("is required"))
+			continue
+		}
+
+		mapperType, ok := Mappers[typ]
+		if !ok {
+			errs.Add(path+".type", fmt.Errorf("unknown mapper type %q", typ))
+			continue
+		}
+
+		mapper := reflect.New(reflect.ValueOf(mapperType).Elem().Type()).Interface().(Mapper)
+		if err := mapper.Init(mapperConf); err != nil {
+			errs.Add(path, err)
+			continue
+		}
+
+		transformer.Mappers = append(transformer.Mappers, mapper)
+	}
+
+	return errs.Err()
+}
+
+func (transformer *VersionMapTransformer) GetID() int {
+	return transformer.ID
+}
+
+func (transformer *VersionMapTransformer) Transform(ctx context.Context, input composer.Input, name string, pkg composer.PackageVersions) error {
+	if !transformer.Packages.Matches(name) {
+		return nil
+	}
+
+	log := transformer.log.WithContext(ctx)
+
+	// Versions are sorted so that conflicts are resolved the same way every time
+	versions := make([]string, 0, len(pkg))
+	for version := range pkg {
+		versions = append(versions, version)
+	}
+	sort.Strings(versions)
+
+	mapped := make(composer.PackageVersions)
+	for _, version := range versions {
+		vers := pkg[version]
+
+		newVersion, ok := transformer.mapVersion(version)
+		if !ok {
+			continue
+		}
+
+		if _, exists := pkg[newVersion]; exists {
+			log.Warn("Not changing version, as the new version already exists", "package", name, "version", version, "newVersion", newVersion)
+			continue
+		}
+
+		if _, exists := mapped[newVersion]; exists {
+			log.Warn("Not changing version, as another version was changed to it", "package", name, "version", version, "newVersion", newVersion)
+			continue
+		}
+
+		log.Debug("Changing version", "package", name, "version", version, "newVersion", newVersion)
+
+		vers.Version = newVersion
+		mapped[newVersion] = vers
+		delete(pkg, version)
+	}
+
+	for version, vers := range mapped {
+		pkg[version] = vers
+	}
+
+	return nil
+}
+
+// mapVersion converts version with the first matching mapper.
+func (transformer *VersionMapTransformer) mapVersion(version string) (string, bool) {
+	for _, mapper := range transformer.Mappers {
+		if newVersion, ok := mapper.Map(version); ok && newVersion != version {
+			return newVersion, true
+		}
+	}
+
+	return version, false
+}
@@ -0,0 +1,138 @@
+package webhook
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+)
+
+// repositoryPayload is the part of GitHub and Gitea payloads naming the repository.
+type repositoryPayload struct {
+	Action     string `json:"action"`
+	Repository struct {
+		FullName string `json:"full_name"`
+	} `json:"repository"`
+}
+
+// parseRepositoryEvent parses the events GitHub and Gitea have in common.
+func parseRepositoryEvent(event string, body []byte) (*Event, error) {
+	payload := repositoryPayload{}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, err
+	}
+
+	result := &Event{Action: ActionIgnore, Repository: payload.Repository.FullName}
+	switch event {
+	case "push", "create", "delete":
+		result.Action = ActionUpdate
+	case "repository":
+		if payload.Action == "deleted" {
+			result.Action = ActionRemove
+		}
+	}
+
+	return result, nil
+}
+
+// GitHub receives webhooks from GitHub and GitHub Enterprise, signed with
+// HMAC-SHA256 in the X-Hub-Signature-256 header.
+type GitHub struct{}
+
+func (*GitHub) Verify(r *http.Request, body []byte, secret string) error {
+	return verifyHMAC(r.Header.Get("X-Hub-Signature-256"), "sha256=", body, secret)
+}
+
+func (*GitHub) Parse(r *http.Request, body []byte) (*Event, error) {
+	return parseRepositoryEvent(r.Header.Get("X-GitHub-Event"), body)
+}
+
+// Gitea receives webhooks from Gitea, signed with HMAC-SHA256 in the
+// X-Gitea-Signature header.
+type Gitea struct{}
+
+func (*Gitea) Verify(r *http.Request, body []byte, secret string) error {
+	return verifyHMAC(r.Header.Get("X-Gitea-Signature"), "", body, secret)
+}
+
+func (*Gitea) Parse(r *http.Request, body []byte) (*Event, error) {
+	return parseRepositoryEvent(r.Header.Get("X-Gitea-Event"), body)
+}
+
+// Bitbucket receives webhooks from Bitbucket Server and Data Center, signed
+// with HMAC-SHA256 in the X-Hub-Signature header. Bitbucket Server doesn't
+// report deleted repositories, so its events only update packages.
+type Bitbucket struct{}
+
+func (*Bitbucket) Verify(r *http.Request, body []byte, secret string) error {
+	return verifyHMAC(r.Header.Get("X-Hub-Signature"), "sha256=", body, secret)
+}
+
+func (*Bitbucket) Parse(r *http.Request, body []byte) (*Event, error) {
+	payload := struct {
+		Repository struct {
+			Slug    string `json:"slug"`
+			Project struct {
+				Key string `json:"key"`
+			} `json:"project"`
+		} `json:"repository"`
+	}{}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, err
+	}
+
+	result := &Event{Action: ActionIgnore}
+	if payload.Repository.Slug != "" {
+		result.Repository = payload.Repository.Project.Key + "/" + payload.Repository.Slug
+	}
+
+	switch r.Header.Get("X-Event-Key") {
+	case "repo:refs_changed":
+		result.Action = ActionUpdate
+	}
+
+	return result, nil
+}
+
+// GitLab receives project webhooks and system hooks from GitLab, which
+// send the secret token in the X-Gitlab-Token header.
+type GitLab struct{}
+
+func (*GitLab) Verify(r *http.Request, body []byte, secret string) error {
+	if subtle.ConstantTimeCompare([]byte(r.Header.Get("X-Gitlab-Token")), []byte(secret)) != 1 {
+		return ErrSignature
+	}
+
+	return nil
+}
+
+func (*GitLab) Parse(r *http.Request, body []byte) (*Event, error) {
+	payload := struct {
+		ObjectKind        string `json:"object_kind"`
+		EventName         string `json:"event_name"`
+		PathWithNamespace string `json:"path_with_namespace"`
+		Project           struct {
+			PathWithNamespace string `json:"path_with_namespace"`
+		} `json:"project"`
+	}{}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, err
+	}
+
+	result := &Event{Action: ActionIgnore, Repository: payload.Project.PathWithNamespace}
+
+	// System hooks name the event in event_name, project hooks in object_kind
+	kind := payload.ObjectKind
+	if kind == "" {
+		kind = payload.EventName
+	}
+
+	switch kind {
+	case "push", "tag_push":
+		result.Action = ActionUpdate
+	case "project_destroy":
+		result.Action = ActionRemove
+		result.Repository = payload.PathWithNamespace
+	}
+
+	return result, nil
+}
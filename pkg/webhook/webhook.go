@@ -0,0 +1,139 @@
+// Package webhook verifies and parses the push and repository events sent
+// by Git hosts, so that `repo serve` can update or remove the package of
+// the repository which changed.
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// maxPayloadSize is the largest payload accepted.
+const maxPayloadSize = 10 << 20
+
+// ErrSignature is returned when a request isn't signed with the secret.
+var ErrSignature = errors.New("Invalid webhook signature")
+
+// Action is what a webhook event means for the package of the repository.
+type Action string
+
+const (
+	// ActionIgnore is an event which doesn't change the package, such as a ping.
+	ActionIgnore Action = "ignore"
+	// ActionUpdate is a push, or a branch or tag being created or deleted.
+	ActionUpdate Action = "update"
+	// ActionRemove is the repository being deleted.
+	ActionRemove Action = "remove"
+)
+
+// Event is a change to a repository reported by a webhook.
+type Event struct {
+	Action Action
+
+	// Repository is the full name of the repository, such as group/project.
+	Repository string
+}
+
+// Provider verifies and parses the webhooks of a Git host.
+type Provider interface {
+	// Verify checks that the request was signed with secret.
+	Verify(r *http.Request, body []byte, secret string) error
+
+	// Parse returns the event of the request.
+	Parse(r *http.Request, body []byte) (*Event, error)
+}
+
+// Providers are the Git hosts webhooks can be received from, by type.
+var Providers = map[string]Provider{
+	"github":    &GitHub{},
+	"gitea":     &Gitea{},
+	"bitbucket": &Bitbucket{},
+	"gitlab":    &GitLab{},
+}
+
+// Hook receives the webhooks of a Git host for the repositories of an input.
+type Hook struct {
+	Type     string
+	Path     string
+	Provider Provider
+	Secret   string
+	InputID  string
+
+	// Packages maps the full names of repositories to package names.
+	// Other repositories use PackageName.
+	Packages map[string]string
+}
+
+// PackageName returns the package of a repository: the name mapped in
+// Packages, or the lowercased full name with the slashes of nested groups
+// replaced by dashes, like the gitlab input names packages.
+func (hook *Hook) PackageName(repository string) string {
+	if name, ok := hook.Packages[repository]; ok {
+		return name
+	}
+
+	repository = strings.ToLower(repository)
+
+	i := strings.LastIndex(repository, "/")
+	if i < 0 {
+		return repository
+	}
+
+	return strings.ReplaceAll(repository[:i], "/", "-") + repository[i:]
+}
+
+// Read reads the body of the request, checks its signature and returns its
+// event. Without a secret, every request is rejected.
+func (hook *Hook) Read(r *http.Request) (*Event, error) {
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, maxPayloadSize))
+	if err != nil {
+		return nil, err
+	}
+
+	if hook.Secret == "" {
+		return nil, ErrSignature
+	}
+
+	if err := hook.Provider.Verify(r, body, hook.Secret); err != nil {
+		return nil, err
+	}
+
+	event, err := hook.Provider.Parse(r, body)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read the %s webhook: %v", hook.Type, err)
+	}
+
+	if event.Action != ActionIgnore && event.Repository == "" {
+		return nil, fmt.Errorf("The %s webhook doesn't name a repository", hook.Type)
+	}
+
+	return event, nil
+}
+
+// verifyHMAC checks that signature is the hex encoded HMAC-SHA256 of body
+// with secret, after removing prefix.
+func verifyHMAC(signature string, prefix string, body []byte, secret string) error {
+	if !strings.HasPrefix(signature, prefix) {
+		return ErrSignature
+	}
+
+	actual, err := hex.DecodeString(strings.TrimPrefix(signature, prefix))
+	if err != nil {
+		return ErrSignature
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	if !hmac.Equal(actual, mac.Sum(nil)) {
+		return ErrSignature
+	}
+
+	return nil
+}
@@ -0,0 +1,165 @@
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func sign(secret string, body string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(body))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestHookRead(t *testing.T) {
+	const secret = "s3cr3t"
+	const body = `{"repository":{"full_name":"Acme/Widget"}}`
+	const bitbucketBody = `{"repository":{"slug":"widget","project":{"key":"ACME"}}}`
+
+	tests := []struct {
+		name    string
+		typ     string
+		secret  string
+		headers map[string]string
+		body    string
+		want    *Event
+	}{
+		{"github", "github", secret, map[string]string{"X-Hub-Signature-256": "sha256=" + sign(secret, body), "X-GitHub-Event": "push"}, body, &Event{ActionUpdate, "Acme/Widget"}},
+		{"github wrong secret", "github", secret, map[string]string{"X-Hub-Signature-256": "sha256=" + sign("other", body), "X-GitHub-Event": "push"}, body, nil},
+		{"github tampered body", "github", secret, map[string]string{"X-Hub-Signature-256": "sha256=" + sign(secret, body), "X-GitHub-Event": "push"}, `{"repository":{"full_name":"evil/widget"}}`, nil},
+		{"github missing prefix", "github", secret, map[string]string{"X-Hub-Signature-256": sign(secret, body), "X-GitHub-Event": "push"}, body, nil},
+		{"github not hex", "github", secret, map[string]string{"X-Hub-Signature-256": "sha256=zz", "X-GitHub-Event": "push"}, body, nil},
+		{"github unsigned", "github", secret, map[string]string{"X-GitHub-Event": "push"}, body, nil},
+		{"github empty secret", "github", "", map[string]string{"X-Hub-Signature-256": "sha256=" + sign("", body), "X-GitHub-Event": "push"}, body, nil},
+
+		{"gitea", "gitea", secret, map[string]string{"X-Gitea-Signature": sign(secret, body), "X-Gitea-Event": "create"}, body, &Event{ActionUpdate, "Acme/Widget"}},
+		{"gitea wrong secret", "gitea", secret, map[string]string{"X-Gitea-Signature": sign("other", body), "X-Gitea-Event": "create"}, body, nil},
+		{"gitea github header", "gitea", secret, map[string]string{"X-Hub-Signature-256": "sha256=" + sign(secret, body), "X-Gitea-Event": "create"}, body, nil},
+
+		{"bitbucket", "bitbucket", secret, map[string]string{"X-Hub-Signature": "sha256=" + sign(secret, bitbucketBody), "X-Event-Key": "repo:refs_changed"}, bitbucketBody, &Event{ActionUpdate, "ACME/widget"}},
+		{"bitbucket wrong secret", "bitbucket", secret, map[string]string{"X-Hub-Signature": "sha256=" + sign("other", bitbucketBody), "X-Event-Key": "repo:refs_changed"}, bitbucketBody, nil},
+
+		{"gitlab", "gitlab", secret, map[string]string{"X-Gitlab-Token": secret}, `{"object_kind":"push","project":{"path_with_namespace":"acme/widget"}}`, &Event{ActionUpdate, "acme/widget"}},
+		{"gitlab wrong token", "gitlab", secret, map[string]string{"X-Gitlab-Token": "other"}, `{"object_kind":"push","project":{"path_with_namespace":"acme/widget"}}`, nil},
+		{"gitlab missing token", "gitlab", secret, nil, `{"object_kind":"push","project":{"path_with_namespace":"acme/widget"}}`, nil},
+		{"gitlab empty secret", "gitlab", "", nil, `{"object_kind":"push","project":{"path_with_namespace":"acme/widget"}}`, nil},
+	}
+
+	for _, test := range tests {
+		hook := &Hook{Type: test.typ, Provider: Providers[test.typ], Secret: test.secret}
+
+		r := httptest.NewRequest("POST", "/webhooks/"+test.typ, strings.NewReader(test.body))
+		for k, v := range test.headers {
+			r.Header.Set(k, v)
+		}
+
+		event, err := hook.Read(r)
+		if test.want == nil {
+			if err != ErrSignature {
+				t.Errorf("%s: Read = %v, %v, want ErrSignature", test.name, event, err)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%s: Read returned error: %v", test.name, err)
+		} else if *event != *test.want {
+			t.Errorf("%s: Read = %+v, want %+v", test.name, event, test.want)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name    string
+		typ     string
+		headers map[string]string
+		body    string
+		want    Event
+	}{
+		{"github push", "github", map[string]string{"X-GitHub-Event": "push"}, `{"repository":{"full_name":"acme/widget"}}`, Event{ActionUpdate, "acme/widget"}},
+		{"github delete branch", "github", map[string]string{"X-GitHub-Event": "delete"}, `{"repository":{"full_name":"acme/widget"}}`, Event{ActionUpdate, "acme/widget"}},
+		{"github repository deleted", "github", map[string]string{"X-GitHub-Event": "repository"}, `{"action":"deleted","repository":{"full_name":"acme/widget"}}`, Event{ActionRemove, "acme/widget"}},
+		{"github repository renamed", "github", map[string]string{"X-GitHub-Event": "repository"}, `{"action":"renamed","repository":{"full_name":"acme/widget"}}`, Event{ActionIgnore, "acme/widget"}},
+		{"github ping", "github", map[string]string{"X-GitHub-Event": "ping"}, `{"zen":"Keep it simple."}`, Event{ActionIgnore, ""}},
+
+		{"gitea push", "gitea", map[string]string{"X-Gitea-Event": "push"}, `{"repository":{"full_name":"acme/widget"}}`, Event{ActionUpdate, "acme/widget"}},
+		{"gitea repository deleted", "gitea", map[string]string{"X-Gitea-Event": "repository"}, `{"action":"deleted","repository":{"full_name":"acme/widget"}}`, Event{ActionRemove, "acme/widget"}},
+
+		{"bitbucket refs changed", "bitbucket", map[string]string{"X-Event-Key": "repo:refs_changed"}, `{"repository":{"slug":"widget","project":{"key":"ACME"}}}`, Event{ActionUpdate, "ACME/widget"}},
+		{"bitbucket other", "bitbucket", map[string]string{"X-Event-Key": "pr:opened"}, `{"repository":{"slug":"widget","project":{"key":"ACME"}}}`, Event{ActionIgnore, "ACME/widget"}},
+		{"bitbucket test", "bitbucket", map[string]string{"X-Event-Key": "diagnostics:ping"}, `{"test":true}`, Event{ActionIgnore, ""}},
+
+		{"gitlab push", "gitlab", nil, `{"object_kind":"push","project":{"path_with_namespace":"acme/sub/widget"}}`, Event{ActionUpdate, "acme/sub/widget"}},
+		{"gitlab tag push", "gitlab", nil, `{"object_kind":"tag_push","project":{"path_with_namespace":"acme/widget"}}`, Event{ActionUpdate, "acme/widget"}},
+		{"gitlab system push", "gitlab", nil, `{"event_name":"push","project":{"path_with_namespace":"acme/widget"}}`, Event{ActionUpdate, "acme/widget"}},
+		{"gitlab project destroyed", "gitlab", nil, `{"event_name":"project_destroy","path_with_namespace":"acme/widget"}`, Event{ActionRemove, "acme/widget"}},
+		{"gitlab merge request", "gitlab", nil, `{"object_kind":"merge_request","project":{"path_with_namespace":"acme/widget"}}`, Event{ActionIgnore, "acme/widget"}},
+	}
+
+	for _, test := range tests {
+		r := httptest.NewRequest("POST", "/webhooks/"+test.typ, nil)
+		for k, v := range test.headers {
+			r.Header.Set(k, v)
+		}
+
+		event, err := Providers[test.typ].Parse(r, []byte(test.body))
+		if err != nil {
+			t.Errorf("%s: Parse returned error: %v", test.name, err)
+		} else if *event != test.want {
+			t.Errorf("%s: Parse = %+v, want %+v", test.name, *event, test.want)
+		}
+	}
+}
+
+func TestHookReadInvalid(t *testing.T) {
+	const secret = "s3cr3t"
+
+	tests := []struct {
+		name    string
+		headers map[string]string
+		body    string
+		err     string
+	}{
+		{"invalid JSON", map[string]string{"X-GitHub-Event": "push"}, `{"repository":`, "Unable to read the github webhook"},
+		{"no repository", map[string]string{"X-GitHub-Event": "push"}, `{}`, "doesn't name a repository"},
+	}
+
+	for _, test := range tests {
+		hook := &Hook{Type: "github", Provider: Providers["github"], Secret: secret}
+
+		r := httptest.NewRequest("POST", "/webhooks/github", strings.NewReader(test.body))
+		r.Header.Set("X-Hub-Signature-256", "sha256="+sign(secret, test.body))
+		for k, v := range test.headers {
+			r.Header.Set(k, v)
+		}
+
+		if event, err := hook.Read(r); err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: Read = %v, %v, want error containing %q", test.name, event, err, test.err)
+		}
+	}
+}
+
+func TestPackageName(t *testing.T) {
+	hook := &Hook{Packages: map[string]string{"acme/legacy": "acme/renamed"}}
+
+	tests := []struct {
+		repository string
+		want       string
+	}{
+		{"Acme/Widget", "acme/widget"},
+		{"acme/sub/widget", "acme-sub/widget"},
+		{"acme/legacy", "acme/renamed"},
+		{"widget", "widget"},
+	}
+
+	for _, test := range tests {
+		if got := hook.PackageName(test.repository); got != test.want {
+			t.Errorf("PackageName(%q) = %q, want %q", test.repository, got, test.want)
+		}
+	}
+}